@@ -60,3 +60,14 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Format a map of resource quantities as the resource=quantity list of the operator flags
+*/}}
+{{- define "devopsbeerer-operator.resourceList" -}}
+{{- $pairs := list }}
+{{- range $name, $quantity := . }}
+{{- $pairs = append $pairs (printf "%s=%v" $name $quantity) }}
+{{- end }}
+{{- join "," $pairs }}
+{{- end }}
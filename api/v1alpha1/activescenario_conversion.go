@@ -0,0 +1,146 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// ConvertTo converts this ActiveScenario to the Hub version (v1beta1).
+func (src *ActiveScenario) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.ActiveScenario)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	restored := &v1beta1.ActiveScenario{}
+	ok, err := restoreConversionData(&dst.ObjectMeta, &restored.Spec, &restored.Status)
+	if err != nil {
+		return err
+	}
+
+	convertActiveScenarioToHub(src, dst)
+	if !ok {
+		return nil
+	}
+
+	// Bring back the fields v1alpha1 cannot represent, keeping the original
+	// Ready condition unless a v1alpha1 client changed its message
+	fromAlpha := meta.FindStatusCondition(dst.Status.Conditions, v1beta1.ActiveScenarioConditionReady)
+	dst.Status.ObservedGeneration = restored.Status.ObservedGeneration
+	dst.Spec.UpdatePolicy = restored.Spec.UpdatePolicy
+	dst.Spec.CompletedSteps = restored.Spec.CompletedSteps
+	dst.Spec.ResetGeneration = restored.Spec.ResetGeneration
+	dst.Status.DefinitionGeneration = restored.Status.DefinitionGeneration
+	dst.Status.AvailableRevision = restored.Status.AvailableRevision
+	dst.Status.LastSourceCheck = restored.Status.LastSourceCheck
+	dst.Status.Endpoints = restored.Status.Endpoints
+	dst.Status.CredentialsSecret = restored.Status.CredentialsSecret
+	dst.Status.Steps = restored.Status.Steps
+	dst.Status.CurrentStep = restored.Status.CurrentStep
+	dst.Status.HookJob = restored.Status.HookJob
+	dst.Status.Conditions = restored.Status.Conditions
+
+	message, lastTransitionTime := readyConditionToStatus(
+		meta.FindStatusCondition(restored.Status.Conditions, v1beta1.ActiveScenarioConditionReady))
+	if message == src.Status.Message && apiequality.Semantic.DeepEqual(lastTransitionTime, src.Status.LastTransitionTime) {
+		return nil
+	}
+	meta.RemoveStatusCondition(&dst.Status.Conditions, v1beta1.ActiveScenarioConditionReady)
+	if fromAlpha != nil {
+		dst.Status.Conditions = append(dst.Status.Conditions, *fromAlpha)
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *ActiveScenario) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.ActiveScenario)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.ScenarioId = src.Spec.ScenarioID
+	dst.Spec.Parameters = src.Spec.Parameters
+
+	dst.Status.Phase = ActiveScenarioPhase(src.Status.Phase)
+	dst.Status.ScenarioName = src.Status.ScenarioName
+	dst.Status.HelmReleaseName = src.Status.HelmReleaseName
+	dst.Status.StartTime = src.Status.StartTime
+	dst.Status.Message, dst.Status.LastTransitionTime = readyConditionToStatus(
+		meta.FindStatusCondition(src.Status.Conditions, v1beta1.ActiveScenarioConditionReady))
+
+	// Save the hub data when v1alpha1 cannot represent all of it
+	lossless := &v1beta1.ActiveScenario{}
+	convertActiveScenarioToHub(dst, lossless)
+	if apiequality.Semantic.DeepEqual(lossless.Spec, src.Spec) &&
+		apiequality.Semantic.DeepEqual(lossless.Status, src.Status) {
+		return nil
+	}
+	return storeConversionData(&dst.ObjectMeta, src.Spec, src.Status)
+}
+
+// convertActiveScenarioToHub converts the fields both versions have in common
+func convertActiveScenarioToHub(src *ActiveScenario, dst *v1beta1.ActiveScenario) {
+	dst.Spec.ScenarioID = src.Spec.ScenarioId
+	dst.Spec.Parameters = src.Spec.Parameters
+
+	dst.Status.Phase = v1beta1.ActiveScenarioPhase(src.Status.Phase)
+	dst.Status.ScenarioName = src.Status.ScenarioName
+	dst.Status.HelmReleaseName = src.Status.HelmReleaseName
+	dst.Status.StartTime = src.Status.StartTime
+	dst.Status.Conditions = nil
+	if src.Status.Phase != "" || src.Status.Message != "" || src.Status.LastTransitionTime != nil {
+		dst.Status.Conditions = []metav1.Condition{statusToReadyCondition(&src.Status)}
+	}
+}
+
+// statusToReadyCondition maps the v1alpha1 phase and message onto the Ready condition
+func statusToReadyCondition(status *ActiveScenarioStatus) metav1.Condition {
+	condition := metav1.Condition{
+		Type:    v1beta1.ActiveScenarioConditionReady,
+		Status:  metav1.ConditionUnknown,
+		Reason:  string(status.Phase),
+		Message: status.Message,
+	}
+	switch status.Phase {
+	case ActiveScenarioPhaseRunning:
+		condition.Status = metav1.ConditionTrue
+	case ActiveScenarioPhaseFailed:
+		condition.Status = metav1.ConditionFalse
+	case "":
+		condition.Reason = "Unknown"
+	}
+	if status.LastTransitionTime != nil {
+		condition.LastTransitionTime = *status.LastTransitionTime
+	}
+	return condition
+}
+
+// readyConditionToStatus maps the Ready condition onto the v1alpha1 message and transition time
+func readyConditionToStatus(condition *metav1.Condition) (string, *metav1.Time) {
+	if condition == nil {
+		return "", nil
+	}
+	if condition.LastTransitionTime.IsZero() {
+		return condition.Message, nil
+	}
+	lastTransitionTime := condition.LastTransitionTime
+	return condition.Message, &lastTransitionTime
+}
@@ -32,6 +32,10 @@ type ActiveScenarioSpec struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
 	ScenarioId string `json:"scenarioId"`
+
+	// Parameters are the values for the parameters declared by the scenario definition
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
 }
 
 // ActiveScenarioPhase defines the phase of scenario deployment
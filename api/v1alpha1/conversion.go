@@ -0,0 +1,90 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conversionDataAnnotation holds the v1beta1 spec and status of an object
+// whenever they carry data v1alpha1 cannot represent, so that a round trip
+// through v1alpha1 does not lose it.
+const conversionDataAnnotation = "devopsbeerer.io/conversion-data"
+
+// conversionData is the content of the conversion data annotation
+type conversionData struct {
+	Spec   json.RawMessage `json:"spec,omitempty"`
+	Status json.RawMessage `json:"status,omitempty"`
+}
+
+// storeConversionData saves the hub spec and status on the spoke object
+func storeConversionData(obj metav1.Object, spec, status interface{}) error {
+	specData, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversion data: %w", err)
+	}
+	statusData, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversion data: %w", err)
+	}
+	data, err := json.Marshal(conversionData{Spec: specData, Status: statusData})
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversion data: %w", err)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[conversionDataAnnotation] = string(data)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// restoreConversionData loads the hub spec and status saved on an object and
+// removes the annotation. It reports whether any data was found.
+func restoreConversionData(obj metav1.Object, spec, status interface{}) (bool, error) {
+	annotations := obj.GetAnnotations()
+	raw, ok := annotations[conversionDataAnnotation]
+	if !ok {
+		return false, nil
+	}
+	delete(annotations, conversionDataAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	obj.SetAnnotations(annotations)
+
+	data := conversionData{}
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return false, fmt.Errorf("failed to unmarshal conversion data: %w", err)
+	}
+	if len(data.Spec) > 0 {
+		if err := json.Unmarshal(data.Spec, spec); err != nil {
+			return false, fmt.Errorf("failed to unmarshal conversion data: %w", err)
+		}
+	}
+	if len(data.Status) > 0 {
+		if err := json.Unmarshal(data.Status, status); err != nil {
+			return false, fmt.Errorf("failed to unmarshal conversion data: %w", err)
+		}
+	}
+	return true, nil
+}
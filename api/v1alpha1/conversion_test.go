@@ -0,0 +1,633 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+var testTime = metav1.NewTime(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+func TestActiveScenarioSpokeRoundTrip(t *testing.T) {
+	tests := map[string]*ActiveScenario{
+		"empty": {
+			ObjectMeta: metav1.ObjectMeta{Name: "active"},
+		},
+		"running": {
+			ObjectMeta: metav1.ObjectMeta{Name: "active", Labels: map[string]string{"team": "a"}},
+			Spec: ActiveScenarioSpec{
+				ScenarioId: "basic-oauth2",
+				Parameters: map[string]string{"replicas": "2"},
+			},
+			Status: ActiveScenarioStatus{
+				Phase:              ActiveScenarioPhaseRunning,
+				ScenarioName:       "Basic OAuth2",
+				Message:            "Scenario 'Basic OAuth2' is running",
+				LastTransitionTime: &testTime,
+				HelmReleaseName:    "devopsbeerer-basic-oauth2",
+				StartTime:          &testTime,
+			},
+		},
+		"failed without transition time": {
+			ObjectMeta: metav1.ObjectMeta{Name: "active"},
+			Spec:       ActiveScenarioSpec{ScenarioId: "basic-oauth2"},
+			Status: ActiveScenarioStatus{
+				Phase:   ActiveScenarioPhaseFailed,
+				Message: "ScenarioDefinition 'basic-oauth2' not found",
+			},
+		},
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			hub := &v1beta1.ActiveScenario{}
+			if err := src.DeepCopy().ConvertTo(hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			dst := &ActiveScenario{}
+			if err := dst.ConvertFrom(hub); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			if !apiequality.Semantic.DeepEqual(src, dst) {
+				t.Errorf("round trip mismatch:\nwant %+v\ngot  %+v", src, dst)
+			}
+		})
+	}
+}
+
+func TestActiveScenarioHubRoundTrip(t *testing.T) {
+	tests := map[string]*v1beta1.ActiveScenario{
+		"ready condition": {
+			ObjectMeta: metav1.ObjectMeta{Name: "active"},
+			Spec:       v1beta1.ActiveScenarioSpec{ScenarioID: "basic-oauth2"},
+			Status: v1beta1.ActiveScenarioStatus{
+				Phase: v1beta1.ActiveScenarioPhaseRunning,
+				Conditions: []metav1.Condition{{
+					Type:               v1beta1.ActiveScenarioConditionReady,
+					Status:             metav1.ConditionTrue,
+					Reason:             "Running",
+					Message:            "Scenario 'Basic OAuth2' is running",
+					LastTransitionTime: testTime,
+				}},
+			},
+		},
+		"hub only fields": {
+			ObjectMeta: metav1.ObjectMeta{Name: "active", Annotations: map[string]string{"note": "kept"}},
+			Spec: v1beta1.ActiveScenarioSpec{
+				ScenarioID: "basic-oauth2",
+				UpdatePolicy: &v1beta1.UpdatePolicy{
+					Type:     v1beta1.UpdatePolicyWindow,
+					Interval: &metav1.Duration{Duration: 15 * time.Minute},
+					Window: &v1beta1.UpdateWindow{
+						Start:    "02:00",
+						Duration: metav1.Duration{Duration: 2 * time.Hour},
+						Days:     []v1beta1.Weekday{"Saturday", "Sunday"},
+						TimeZone: "Europe/Zurich",
+					},
+				},
+				CompletedSteps:  []string{"explore"},
+				ResetGeneration: 1,
+			},
+			Status: v1beta1.ActiveScenarioStatus{
+				Phase:                v1beta1.ActiveScenarioPhaseDeploying,
+				ObservedGeneration:   3,
+				DefinitionGeneration: 2,
+				AvailableRevision:    "4f2c9e1",
+				LastSourceCheck:      &testTime,
+				Endpoints: []v1beta1.EndpointStatus{{
+					Name:    "beer-api",
+					URL:     "https://beer-api.playground.local/",
+					Purpose: "Protected API",
+					Source:  v1beta1.EndpointSourceIngress,
+				}},
+				CredentialsSecret: &corev1.SecretReference{
+					Name:      "scenario-credentials",
+					Namespace: "devopsbeerer-basic-oauth2",
+				},
+				Steps: []v1beta1.StepStatus{
+					{
+						Name:        "explore",
+						Phase:       v1beta1.StepPhaseCompleted,
+						StartedAt:   &testTime,
+						CompletedAt: &testTime,
+					},
+					{
+						Name:             "login",
+						Phase:            v1beta1.StepPhaseInProgress,
+						StartedAt:        &testTime,
+						LastVerification: &testTime,
+						Message:          "HTTP 401",
+					},
+				},
+				CurrentStep: "login",
+				HookJob: &v1beta1.HookJobStatus{
+					Hook:      "postInstall",
+					Namespace: "devopsbeerer-oauth2",
+					Name:      "oauth2-postinstall-x7k2p",
+					StartTime: testTime,
+				},
+				Conditions: []metav1.Condition{
+					{
+						Type:               "Progressing",
+						Status:             metav1.ConditionTrue,
+						Reason:             "Installing",
+						LastTransitionTime: testTime,
+					},
+					{
+						Type:               v1beta1.ActiveScenarioConditionReady,
+						Status:             metav1.ConditionFalse,
+						Reason:             "HelmInstalling",
+						Message:            "Installing scenario: Basic OAuth2",
+						LastTransitionTime: testTime,
+						ObservedGeneration: 3,
+					},
+				},
+			},
+		},
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			spoke := &ActiveScenario{}
+			if err := spoke.ConvertFrom(src.DeepCopy()); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			dst := &v1beta1.ActiveScenario{}
+			if err := spoke.ConvertTo(dst); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			if !apiequality.Semantic.DeepEqual(src, dst) {
+				t.Errorf("round trip mismatch:\nwant %+v\ngot  %+v", src, dst)
+			}
+		})
+	}
+}
+
+func TestActiveScenarioSpokeEditWins(t *testing.T) {
+	src := &v1beta1.ActiveScenario{
+		ObjectMeta: metav1.ObjectMeta{Name: "active"},
+		Spec:       v1beta1.ActiveScenarioSpec{ScenarioID: "basic-oauth2"},
+		Status: v1beta1.ActiveScenarioStatus{
+			ObservedGeneration: 2,
+			Phase:              v1beta1.ActiveScenarioPhaseRunning,
+		},
+	}
+
+	spoke := &ActiveScenario{}
+	if err := spoke.ConvertFrom(src); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	spoke.Spec.ScenarioId = "advanced-oauth2"
+
+	dst := &v1beta1.ActiveScenario{}
+	if err := spoke.ConvertTo(dst); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if dst.Spec.ScenarioID != "advanced-oauth2" {
+		t.Errorf("ScenarioID = %q, want %q", dst.Spec.ScenarioID, "advanced-oauth2")
+	}
+	if dst.Status.ObservedGeneration != 2 {
+		t.Errorf("ObservedGeneration = %d, want 2", dst.Status.ObservedGeneration)
+	}
+	if _, ok := dst.Annotations[conversionDataAnnotation]; ok {
+		t.Errorf("conversion data annotation leaked into the hub")
+	}
+}
+
+func TestScenarioDefinitionSpokeRoundTrip(t *testing.T) {
+	src := &ScenarioDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+		Spec: ScenarioDefinitionSpec{
+			Name:        "Basic OAuth2",
+			ID:          "basic-oauth2",
+			Description: "Authorization code flow",
+			HelmChart: HelmChart{
+				Link: "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+				Dir:  "basic-oauth2",
+			},
+			Tags:     []string{"oauth2"},
+			Features: []string{"authorization-code-flow"},
+			Parameters: []ScenarioParameter{{
+				Name:    "replicas",
+				Type:    ScenarioParameterTypeInteger,
+				Default: "1",
+				Path:    "backend.replicaCount",
+			}},
+			ValuesSchema: &apiextensionsv1.JSON{Raw: []byte(`{"type":"object"}`)},
+		},
+	}
+
+	hub := &v1beta1.ScenarioDefinition{}
+	if err := src.DeepCopy().ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	dst := &ScenarioDefinition{}
+	if err := dst.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if !apiequality.Semantic.DeepEqual(src, dst) {
+		t.Errorf("round trip mismatch:\nwant %+v\ngot  %+v", src, dst)
+	}
+}
+
+func TestScenarioDefinitionHubRoundTrip(t *testing.T) {
+	tests := map[string]*v1beta1.ScenarioDefinition{
+		"git ref": {
+			ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Basic OAuth2",
+				ID:   "basic-oauth2",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL:  "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+					Ref:  "v1.2.0",
+					Path: "basic-oauth2",
+				}},
+			},
+		},
+		"empty default": {
+			ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Basic OAuth2",
+				ID:   "basic-oauth2",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL: "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+				}},
+				Parameters: []v1beta1.ScenarioParameter{{
+					Name:    "prefix",
+					Type:    v1beta1.ScenarioParameterTypeString,
+					Default: ptr.To(""),
+				}},
+			},
+		},
+		"service account": {
+			ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Basic OAuth2",
+				ID:   "basic-oauth2",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL: "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+				}},
+				ServiceAccount: &v1beta1.ScenarioServiceAccount{
+					Rules: []rbacv1.PolicyRule{{
+						APIGroups: []string{"apps"},
+						Resources: []string{"deployments"},
+						Verbs:     []string{"*"},
+					}},
+				},
+			},
+		},
+		"guardrails": {
+			ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Basic OAuth2",
+				ID:   "basic-oauth2",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL: "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+				}},
+				Guardrails: &v1beta1.ScenarioGuardrails{
+					Quota: corev1.ResourceList{
+						corev1.ResourceRequestsCPU: resource.MustParse("2"),
+					},
+					PodSecurity: v1beta1.PodSecurityLevelRestricted,
+				},
+			},
+		},
+		"requires": {
+			ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Basic OAuth2",
+				ID:   "basic-oauth2",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL: "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+				}},
+				Requires: []string{"keycloak", "postgresql"},
+			},
+		},
+		"charts": {
+			ObjectMeta: metav1.ObjectMeta{Name: "beer-shop"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Beer Shop",
+				ID:   "beer-shop",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL:  "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+					Path: "beer-shop/backend",
+				}},
+				Charts: []v1beta1.ScenarioChart{
+					{
+						Name: "frontend",
+						Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+							URL:  "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+							Path: "beer-shop/frontend",
+						}},
+						Values:          &apiextensionsv1.JSON{Raw: []byte(`{"replicaCount":2}`)},
+						NamespaceSuffix: "web",
+					},
+					{
+						Name: "seed",
+						Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+							URL:  "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+							Path: "beer-shop/seed",
+						}},
+						DependsOn: []string{"frontend"},
+						Readiness: &v1beta1.ReadinessGate{
+							Timeout:     &metav1.Duration{Duration: 5 * time.Minute},
+							WaitForJobs: true,
+						},
+					},
+				},
+			},
+		},
+		"hooks": {
+			ObjectMeta: metav1.ObjectMeta{Name: "oauth2-demo"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "OAuth2 Demo",
+				ID:   "oauth2-demo",
+				Chart: v1beta1.ChartSource{Git: &v1beta1.GitChartSource{
+					URL: "https://github.com/DevOpsBeerer/playground-scenarios-charts.git",
+				}},
+				Hooks: &v1beta1.ScenarioHooks{
+					PostInstall: &v1beta1.ScenarioHook{
+						Template: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+								RestartPolicy: corev1.RestartPolicyNever,
+								Containers: []corev1.Container{{
+									Name:    "register-client",
+									Image:   "curlimages/curl:8.8.0",
+									Command: []string{"sh", "-c", "curl -sf -X POST http://keycloak/clients"},
+								}},
+							}},
+						}},
+						FailurePolicy: v1beta1.HookFailurePolicyRollback,
+						Timeout:       &metav1.Duration{Duration: 2 * time.Minute},
+					},
+					PostUninstall: &v1beta1.ScenarioHook{
+						FailurePolicy: v1beta1.HookFailurePolicyIgnore,
+					},
+				},
+				Endpoints: []v1beta1.ScenarioEndpoint{{
+					Name:    "admin-console",
+					URL:     "https://keycloak.playground.local/admin",
+					Purpose: "Keycloak admin console",
+				}},
+				Credentials: []v1beta1.ScenarioCredential{
+					{
+						Name:   "admin-password",
+						Type:   v1beta1.CredentialTypePassword,
+						Length: ptr.To[int32](24),
+						Path:   "keycloak.admin",
+					},
+					{
+						Name: "token-signing",
+						Type: v1beta1.CredentialTypeJWTSigningKey,
+						Bits: ptr.To[int32](3072),
+					},
+				},
+				Steps: []v1beta1.ScenarioStep{
+					{
+						Name:         "explore",
+						Title:        "Explore the realm",
+						Instructions: "Open the **admin console** and find the `beer` client.",
+					},
+					{
+						Name:  "login",
+						Title: "Log in with the authorization code flow",
+						Verification: &v1beta1.StepVerification{
+							HTTP: &v1beta1.HTTPVerification{
+								URL:          "https://beer-api.playground.local/me",
+								HTTPHeaders:  []corev1.HTTPHeader{{Name: "Accept", Value: "application/json"}},
+								BodyContains: "alice",
+							},
+							Interval: &metav1.Duration{Duration: time.Minute},
+						},
+					},
+				},
+				Checks: []v1beta1.GradedCheck{
+					{
+						Name:        "deny-without-scope",
+						Description: "The API denies a token without the beers:write scope",
+						Points:      5,
+						HTTP: &v1beta1.HTTPVerification{
+							URL:            "https://beer-api.playground.local/beers",
+							ExpectedStatus: []int32{401, 403},
+						},
+					},
+					{
+						Name:   "pkce",
+						Points: 10,
+						Job: &batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{
+							Spec: corev1.PodSpec{Containers: []corev1.Container{{
+								Name:  "check",
+								Image: "ghcr.io/devopsbeerer/checks:latest",
+								Args:  []string{"pkce"},
+							}}},
+						}}},
+						Timeout: &metav1.Duration{Duration: 2 * time.Minute},
+					},
+				},
+			},
+		},
+		"repository source": {
+			ObjectMeta: metav1.ObjectMeta{Name: "keycloak"},
+			Spec: v1beta1.ScenarioDefinitionSpec{
+				Name: "Keycloak",
+				ID:   "keycloak",
+				Chart: v1beta1.ChartSource{Repository: &v1beta1.RepositoryChartSource{
+					URL:     "oci://registry-1.docker.io/bitnamicharts",
+					Chart:   "keycloak",
+					Version: "24.x",
+				}},
+			},
+			Status: v1beta1.ScenarioDefinitionStatus{
+				ObservedGeneration: 1,
+				Conditions: []metav1.Condition{{
+					Type:               "Ready",
+					Status:             metav1.ConditionTrue,
+					Reason:             "Resolved",
+					LastTransitionTime: testTime,
+				}},
+			},
+		},
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			spoke := &ScenarioDefinition{}
+			if err := spoke.ConvertFrom(src.DeepCopy()); err != nil {
+				t.Fatalf("ConvertFrom() error = %v", err)
+			}
+			dst := &v1beta1.ScenarioDefinition{}
+			if err := spoke.ConvertTo(dst); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			if !apiequality.Semantic.DeepEqual(src, dst) {
+				t.Errorf("round trip mismatch:\nwant %+v\ngot  %+v", src, dst)
+			}
+		})
+	}
+}
+
+func TestScenarioHistoryUninstallReasonToHub(t *testing.T) {
+	tests := map[string]struct {
+		reason, message string
+		wantReason      v1beta1.UninstallReason
+		wantMessage     string
+	}{
+		"none": {},
+		"enum": {
+			reason:      "Expired",
+			message:     "Scenario reached its lifetime",
+			wantReason:  v1beta1.UninstallReasonExpired,
+			wantMessage: "Scenario reached its lifetime",
+		},
+		"legacy": {
+			reason:     "Replaced by new scenario",
+			wantReason: v1beta1.UninstallReasonReplaced,
+		},
+		"free-form": {
+			reason:      "cleanup before the workshop",
+			wantReason:  v1beta1.UninstallReasonManualOverride,
+			wantMessage: "cleanup before the workshop",
+		},
+		"free-form with message": {
+			reason:      "cleanup",
+			message:     "Scenario uninstalled",
+			wantReason:  v1beta1.UninstallReasonManualOverride,
+			wantMessage: "cleanup: Scenario uninstalled",
+		},
+		"enum in another case": {
+			reason:      "replaced",
+			wantReason:  v1beta1.UninstallReasonManualOverride,
+			wantMessage: "replaced",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			src := &ScenarioHistory{
+				ObjectMeta: metav1.ObjectMeta{Name: "history-basic-oauth2-1717243200"},
+				Spec:       ScenarioHistorySpec{ScenarioID: "basic-oauth2"},
+				Status: ScenarioHistoryStatus{
+					Phase:           ScenarioHistoryPhaseArchived,
+					UninstallReason: tt.reason,
+					Message:         tt.message,
+				},
+			}
+			hub := &v1beta1.ScenarioHistory{}
+			if err := src.ConvertTo(hub); err != nil {
+				t.Fatalf("ConvertTo() error = %v", err)
+			}
+			if hub.Status.UninstallReason != tt.wantReason || hub.Status.Message != tt.wantMessage {
+				t.Errorf("ConvertTo() reason = %q, message = %q, want %q, %q",
+					hub.Status.UninstallReason, hub.Status.Message, tt.wantReason, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestScenarioHistoryRoundTrip(t *testing.T) {
+	spokeSrc := &ScenarioHistory{
+		ObjectMeta: metav1.ObjectMeta{Name: "history-basic-oauth2-1717243200"},
+		Spec: ScenarioHistorySpec{
+			ScenarioID:  "basic-oauth2",
+			Namespace:   "devopsbeerer-basic-oauth2",
+			HelmRelease: "devopsbeerer-basic-oauth2",
+			InstalledAt: testTime,
+			Values:      "replicas: 2\n",
+		},
+		Status: ScenarioHistoryStatus{
+			Phase:           ScenarioHistoryPhaseArchived,
+			UninstalledAt:   &testTime,
+			UninstallReason: "Replaced",
+		},
+	}
+
+	hub := &v1beta1.ScenarioHistory{}
+	if err := spokeSrc.DeepCopy().ConvertTo(hub); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	spokeDst := &ScenarioHistory{}
+	if err := spokeDst.ConvertFrom(hub); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	if !apiequality.Semantic.DeepEqual(spokeSrc, spokeDst) {
+		t.Errorf("spoke round trip mismatch:\nwant %+v\ngot  %+v", spokeSrc, spokeDst)
+	}
+
+	hubSrc := hub.DeepCopy()
+	hubSrc.Spec.ServiceAccount = "devopsbeerer-basic-oauth2/devopsbeerer-installer"
+	hubSrc.Spec.DefinitionGeneration = 4
+	hubSrc.Spec.SourceRevision = "4f2c9e1"
+	hubSrc.Spec.Components = []string{"postgresql", "keycloak"}
+	hubSrc.Spec.Releases = []v1beta1.ScenarioRelease{{
+		Chart:          "frontend",
+		Name:           "devopsbeerer-basic-oauth2-frontend",
+		Namespace:      "devopsbeerer-basic-oauth2-web",
+		SourceRevision: "4f2c9e1",
+		HelmRevision:   2,
+	}}
+	hubSrc.Spec.RestoredFrom = &v1beta1.RestoreSource{Restore: "group-2", Snapshot: "mid-exercise"}
+	hubSrc.Spec.ResetGeneration = 2
+	hubSrc.Status.Revisions = []v1beta1.ScenarioRevision{
+		{Revision: 1, Trigger: v1beta1.RevisionTriggerInstall, DeployedAt: testTime, DefinitionGeneration: 3, HelmRevision: 1},
+		{Revision: 2, Trigger: v1beta1.RevisionTriggerSourceChanged, DeployedAt: testTime, DefinitionGeneration: 4,
+			SourceRevision: "4f2c9e1", HelmRevision: 2},
+	}
+	hubSrc.Status.Score = &v1beta1.ScenarioScore{
+		Score:    5,
+		MaxScore: 15,
+		Check:    "keycloak-oidc-1",
+		GradedAt: testTime,
+		Attempts: 2,
+		Checks: []v1beta1.CheckScore{
+			{Name: "deny-without-scope", Passed: true, Score: 5, MaxScore: 5},
+			{Name: "pkce", MaxScore: 10},
+		},
+	}
+	hubSrc.Status.Phase = v1beta1.ScenarioHistoryPhaseTerminating
+	hubSrc.Status.UninstallReason = v1beta1.UninstallReasonReplaced
+	hubSrc.Status.Successor = "keycloak"
+	hubSrc.Status.UninstalledBy = "alice@devopsbeerer.ch"
+	hubSrc.Status.Conditions = []metav1.Condition{{
+		Type:               "Healthy",
+		Status:             metav1.ConditionTrue,
+		Reason:             "ReleaseDeployed",
+		LastTransitionTime: testTime,
+	}}
+	spoke := &ScenarioHistory{}
+	if err := spoke.ConvertFrom(hubSrc.DeepCopy()); err != nil {
+		t.Fatalf("ConvertFrom() error = %v", err)
+	}
+	hubDst := &v1beta1.ScenarioHistory{}
+	if err := spoke.ConvertTo(hubDst); err != nil {
+		t.Fatalf("ConvertTo() error = %v", err)
+	}
+	if !apiequality.Semantic.DeepEqual(hubSrc, hubDst) {
+		t.Errorf("hub round trip mismatch:\nwant %+v\ngot  %+v", hubSrc, hubDst)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// ConvertTo converts this ScenarioDefinition to the Hub version (v1beta1).
+func (src *ScenarioDefinition) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.ScenarioDefinition)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	restored := &v1beta1.ScenarioDefinition{}
+	ok, err := restoreConversionData(&dst.ObjectMeta, &restored.Spec, &restored.Status)
+	if err != nil {
+		return err
+	}
+
+	convertScenarioDefinitionToHub(src, dst)
+	if !ok {
+		return nil
+	}
+
+	// Bring back the fields v1alpha1 cannot represent, keeping the original
+	// chart source unless a v1alpha1 client changed it
+	dst.Status = restored.Status
+	dst.Spec.ServiceAccount = restored.Spec.ServiceAccount
+	dst.Spec.Guardrails = restored.Spec.Guardrails
+	dst.Spec.Requires = restored.Spec.Requires
+	dst.Spec.Charts = restored.Spec.Charts
+	dst.Spec.Hooks = restored.Spec.Hooks
+	dst.Spec.Endpoints = restored.Spec.Endpoints
+	dst.Spec.Credentials = restored.Spec.Credentials
+	dst.Spec.Steps = restored.Spec.Steps
+	dst.Spec.Checks = restored.Spec.Checks
+	if apiequality.Semantic.DeepEqual(chartSourceToHelmChart(restored.Spec.Chart), src.Spec.HelmChart) {
+		dst.Spec.Chart = restored.Spec.Chart
+	}
+	// Empty defaults read back as no default in v1alpha1
+	for i := range dst.Spec.Parameters {
+		param := &dst.Spec.Parameters[i]
+		for _, original := range restored.Spec.Parameters {
+			if original.Name == param.Name && param.Default == nil &&
+				original.Default != nil && *original.Default == "" {
+				param.Default = ptr.To("")
+			}
+		}
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *ScenarioDefinition) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.ScenarioDefinition)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.Name = src.Spec.Name
+	dst.Spec.ID = src.Spec.ID
+	dst.Spec.Description = src.Spec.Description
+	dst.Spec.HelmChart = chartSourceToHelmChart(src.Spec.Chart)
+	dst.Spec.Tags = src.Spec.Tags
+	dst.Spec.Features = src.Spec.Features
+	dst.Spec.ValuesSchema = src.Spec.ValuesSchema
+	dst.Spec.Parameters = nil
+	for _, param := range src.Spec.Parameters {
+		dst.Spec.Parameters = append(dst.Spec.Parameters, ScenarioParameter{
+			Name:        param.Name,
+			Type:        ScenarioParameterType(param.Type),
+			Description: param.Description,
+			Default:     ptr.Deref(param.Default, ""),
+			Enum:        param.Enum,
+			Required:    param.Required,
+			Path:        param.Path,
+		})
+	}
+
+	// Save the hub data when v1alpha1 cannot represent all of it
+	lossless := &v1beta1.ScenarioDefinition{}
+	convertScenarioDefinitionToHub(dst, lossless)
+	if apiequality.Semantic.DeepEqual(lossless.Spec, src.Spec) &&
+		apiequality.Semantic.DeepEqual(lossless.Status, src.Status) {
+		return nil
+	}
+	return storeConversionData(&dst.ObjectMeta, src.Spec, src.Status)
+}
+
+// convertScenarioDefinitionToHub converts the fields both versions have in common
+func convertScenarioDefinitionToHub(src *ScenarioDefinition, dst *v1beta1.ScenarioDefinition) {
+	dst.Spec.Name = src.Spec.Name
+	dst.Spec.ID = src.Spec.ID
+	dst.Spec.Description = src.Spec.Description
+	dst.Spec.Chart = v1beta1.ChartSource{
+		Git: &v1beta1.GitChartSource{
+			URL:  src.Spec.HelmChart.Link,
+			Path: src.Spec.HelmChart.Dir,
+		},
+	}
+	dst.Spec.Tags = src.Spec.Tags
+	dst.Spec.Features = src.Spec.Features
+	dst.Spec.ValuesSchema = src.Spec.ValuesSchema
+	dst.Spec.Parameters = nil
+	for _, param := range src.Spec.Parameters {
+		// v1alpha1 has no way to declare an empty default
+		var defaultValue *string
+		if param.Default != "" {
+			defaultValue = ptr.To(param.Default)
+		}
+		dst.Spec.Parameters = append(dst.Spec.Parameters, v1beta1.ScenarioParameter{
+			Name:        param.Name,
+			Type:        v1beta1.ScenarioParameterType(param.Type),
+			Description: param.Description,
+			Default:     defaultValue,
+			Enum:        param.Enum,
+			Required:    param.Required,
+			Path:        param.Path,
+		})
+	}
+	dst.Status = v1beta1.ScenarioDefinitionStatus{}
+}
+
+// chartSourceToHelmChart maps a chart source onto the v1alpha1 Git-only chart
+func chartSourceToHelmChart(source v1beta1.ChartSource) HelmChart {
+	if source.Git == nil {
+		return HelmChart{}
+	}
+	return HelmChart{
+		Link: source.Git.URL,
+		Dir:  source.Git.Path,
+	}
+}
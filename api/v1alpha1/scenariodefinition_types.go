@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -36,6 +37,56 @@ type HelmChart struct {
 	Dir string `json:"dir,omitempty"`
 }
 
+// ScenarioParameterType defines the type of a scenario parameter
+// +kubebuilder:validation:Enum=string;integer;number;boolean
+type ScenarioParameterType string
+
+const (
+	// ScenarioParameterTypeString is a free-form string parameter
+	ScenarioParameterTypeString ScenarioParameterType = "string"
+	// ScenarioParameterTypeInteger is a whole number parameter
+	ScenarioParameterTypeInteger ScenarioParameterType = "integer"
+	// ScenarioParameterTypeNumber is a floating point parameter
+	ScenarioParameterTypeNumber ScenarioParameterType = "number"
+	// ScenarioParameterTypeBoolean is a true/false parameter
+	ScenarioParameterTypeBoolean ScenarioParameterType = "boolean"
+)
+
+// ScenarioParameter defines a typed parameter that can be set on an ActiveScenario
+type ScenarioParameter struct {
+	// Name is the parameter name used in ActiveScenario parameters
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z][a-zA-Z0-9_]*$`
+	// +kubebuilder:example="replicas"
+	Name string `json:"name"`
+
+	// Type is the type of the parameter value
+	// +kubebuilder:default=string
+	// +optional
+	Type ScenarioParameterType `json:"type,omitempty"`
+
+	// Description explains what the parameter does
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Default is the value used when the parameter is not set
+	// +optional
+	Default string `json:"default,omitempty"`
+
+	// Enum restricts the parameter to a fixed set of values
+	// +optional
+	Enum []string `json:"enum,omitempty"`
+
+	// Required means the parameter must be set when it has no default
+	// +optional
+	Required bool `json:"required,omitempty"`
+
+	// Path is the dotted Helm values path the parameter is mapped onto (optional, defaults to name)
+	// +optional
+	// +kubebuilder:example="backend.replicaCount"
+	Path string `json:"path,omitempty"`
+}
+
 // ScenarioDefinitionSpec defines the desired state of ScenarioDefinition
 type ScenarioDefinitionSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -69,6 +120,17 @@ type ScenarioDefinitionSpec struct {
 	// +optional
 	// +kubebuilder:example={"authorization-code-flow","refresh-tokens","rbac","api-gateway"}
 	Features []string `json:"features,omitempty"`
+
+	// Parameters declares the typed parameters accepted by this scenario
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Parameters []ScenarioParameter `json:"parameters,omitempty"`
+
+	// ValuesSchema is a JSON schema (such as the chart's values.schema.json)
+	// the rendered Helm values are validated against
+	// +optional
+	ValuesSchema *apiextensionsv1.JSON `json:"valuesSchema,omitempty"`
 }
 
 // ScenarioDefinitionStatus defines the observed state of ScenarioDefinition
@@ -78,6 +140,7 @@ type ScenarioDefinitionStatus struct {
 }
 
 //+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
 //+kubebuilder:resource:scope=Cluster,shortName=scndef;sd
 //+kubebuilder:printcolumn:name="Name",type="string",JSONPath=".spec.name"
 //+kubebuilder:printcolumn:name="ID",type="string",JSONPath=".spec.id"
@@ -0,0 +1,139 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// ConvertTo converts this ScenarioHistory to the Hub version (v1beta1).
+func (src *ScenarioHistory) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.ScenarioHistory)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	restored := &v1beta1.ScenarioHistory{}
+	ok, err := restoreConversionData(&dst.ObjectMeta, &restored.Spec, &restored.Status)
+	if err != nil {
+		return err
+	}
+
+	convertScenarioHistoryToHub(src, dst)
+	if !ok {
+		return nil
+	}
+
+	// Bring back the fields v1alpha1 cannot represent
+	dst.Spec.ServiceAccount = restored.Spec.ServiceAccount
+	dst.Spec.DefinitionGeneration = restored.Spec.DefinitionGeneration
+	dst.Spec.SourceRevision = restored.Spec.SourceRevision
+	dst.Spec.Components = restored.Spec.Components
+	dst.Spec.Releases = restored.Spec.Releases
+	dst.Spec.RestoredFrom = restored.Spec.RestoredFrom
+	dst.Spec.ResetGeneration = restored.Spec.ResetGeneration
+	dst.Status.Revisions = restored.Status.Revisions
+	dst.Status.Score = restored.Status.Score
+	dst.Status.Successor = restored.Status.Successor
+	dst.Status.UninstalledBy = restored.Status.UninstalledBy
+	dst.Status.Conditions = restored.Status.Conditions
+	if restored.Status.Phase == v1beta1.ScenarioHistoryPhaseTerminating &&
+		src.Status.Phase == ScenarioHistoryPhaseActive {
+		dst.Status.Phase = restored.Status.Phase
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *ScenarioHistory) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.ScenarioHistory)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	dst.Spec.ScenarioID = src.Spec.ScenarioID
+	dst.Spec.Namespace = src.Spec.Namespace
+	dst.Spec.HelmRelease = src.Spec.HelmRelease
+	dst.Spec.InstalledAt = src.Spec.InstalledAt
+	dst.Spec.Values = src.Spec.Values
+	dst.Spec.HelmChartVersion = src.Spec.HelmChartVersion
+	dst.Spec.InstalledBy = src.Spec.InstalledBy
+
+	dst.Status.Phase = ScenarioHistoryPhase(src.Status.Phase)
+	if src.Status.Phase == v1beta1.ScenarioHistoryPhaseTerminating {
+		// v1alpha1 has no Terminating phase, the scenario is still in place
+		// until its namespace is gone
+		dst.Status.Phase = ScenarioHistoryPhaseActive
+	}
+	dst.Status.UninstalledAt = src.Status.UninstalledAt
+	dst.Status.UninstallReason = string(src.Status.UninstallReason)
+	dst.Status.Message = src.Status.Message
+	dst.Status.Health = src.Status.Health
+	dst.Status.LastHealthCheck = src.Status.LastHealthCheck
+
+	// Save the hub data when v1alpha1 cannot represent all of it
+	lossless := &v1beta1.ScenarioHistory{}
+	convertScenarioHistoryToHub(dst, lossless)
+	if apiequality.Semantic.DeepEqual(lossless.Spec, src.Spec) &&
+		apiequality.Semantic.DeepEqual(lossless.Status, src.Status) {
+		return nil
+	}
+	return storeConversionData(&dst.ObjectMeta, src.Spec, src.Status)
+}
+
+// convertScenarioHistoryToHub converts the fields both versions have in common
+func convertScenarioHistoryToHub(src *ScenarioHistory, dst *v1beta1.ScenarioHistory) {
+	dst.Spec.ScenarioID = src.Spec.ScenarioID
+	dst.Spec.Namespace = src.Spec.Namespace
+	dst.Spec.HelmRelease = src.Spec.HelmRelease
+	dst.Spec.InstalledAt = src.Spec.InstalledAt
+	dst.Spec.Values = src.Spec.Values
+	dst.Spec.HelmChartVersion = src.Spec.HelmChartVersion
+	dst.Spec.InstalledBy = src.Spec.InstalledBy
+
+	dst.Status.Phase = v1beta1.ScenarioHistoryPhase(src.Status.Phase)
+	dst.Status.UninstalledAt = src.Status.UninstalledAt
+	dst.Status.UninstallReason, dst.Status.Message = uninstallReasonToHub(src.Status.UninstallReason, src.Status.Message)
+	dst.Status.Health = src.Status.Health
+	dst.Status.LastHealthCheck = src.Status.LastHealthCheck
+	dst.Status.Conditions = nil
+}
+
+// legacyUninstallReasons maps the free-form reasons the operator wrote before
+// UninstallReason became an enum
+var legacyUninstallReasons = map[string]v1beta1.UninstallReason{
+	"Replaced by new scenario": v1beta1.UninstallReasonReplaced,
+}
+
+// uninstallReasonToHub maps a free-form v1alpha1 uninstall reason onto the
+// v1beta1 enum. Reasons it does not know become ManualOverride, their text
+// being kept in the message.
+func uninstallReasonToHub(reason, message string) (v1beta1.UninstallReason, string) {
+	switch v1beta1.UninstallReason(reason) {
+	case "", v1beta1.UninstallReasonReplaced, v1beta1.UninstallReasonDeleted, v1beta1.UninstallReasonExpired,
+		v1beta1.UninstallReasonDriftRepair, v1beta1.UninstallReasonFailed, v1beta1.UninstallReasonManualOverride,
+		v1beta1.UninstallReasonRestored, v1beta1.UninstallReasonReset:
+		return v1beta1.UninstallReason(reason), message
+	}
+	if legacy, ok := legacyUninstallReasons[reason]; ok {
+		return legacy, message
+	}
+	if message == "" {
+		return v1beta1.UninstallReasonManualOverride, reason
+	}
+	return v1beta1.UninstallReasonManualOverride, reason + ": " + message
+}
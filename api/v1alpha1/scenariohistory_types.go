@@ -34,11 +34,13 @@ type ScenarioHistorySpec struct {
 
 	// Namespace is the namespace where the scenario is installed
 	// +kubebuilder:validation:Required
-	// +kubebuilder:validation:Pattern=`^devopsbeerer-[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=63
 	Namespace string `json:"namespace"`
 
 	// HelmRelease is the name of the Helm release
 	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=53
 	HelmRelease string `json:"helmRelease"`
 
 	// InstalledAt is the timestamp when the scenario was installed
@@ -5,6 +5,7 @@
 package v1alpha1
 
 import (
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -13,7 +14,7 @@ func (in *ActiveScenario) DeepCopyInto(out *ActiveScenario) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -70,6 +71,13 @@ func (in *ActiveScenarioList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ActiveScenarioSpec) DeepCopyInto(out *ActiveScenarioSpec) {
 	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveScenarioSpec.
@@ -193,6 +201,18 @@ func (in *ScenarioDefinitionSpec) DeepCopyInto(out *ScenarioDefinitionSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make([]ScenarioParameter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ValuesSchema != nil {
+		in, out := &in.ValuesSchema, &out.ValuesSchema
+		*out = new(v1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioDefinitionSpec.
@@ -317,3 +337,23 @@ func (in *ScenarioHistoryStatus) DeepCopy() *ScenarioHistoryStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioParameter) DeepCopyInto(out *ScenarioParameter) {
+	*out = *in
+	if in.Enum != nil {
+		in, out := &in.Enum, &out.Enum
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioParameter.
+func (in *ScenarioParameter) DeepCopy() *ScenarioParameter {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioParameter)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,336 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ActiveScenarioSpec defines the desired state of ActiveScenario
+type ActiveScenarioSpec struct {
+	// ScenarioID is the ID of the scenario definition to deploy
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	ScenarioID string `json:"scenarioId"`
+
+	// Parameters are the values for the parameters declared by the scenario definition
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// UpdatePolicy controls how the running scenario follows changes of its
+	// ScenarioDefinition and chart source (defaults to the operator setting)
+	// +optional
+	UpdatePolicy *UpdatePolicy `json:"updatePolicy,omitempty"`
+
+	// CompletedSteps lists the steps of the scenario marked as completed by
+	// the learner or a facilitator, whether they are verified or not
+	// +optional
+	// +listType=set
+	CompletedSteps []string `json:"completedSteps,omitempty"`
+
+	// ResetGeneration is bumped to start the scenario over: it is uninstalled
+	// and installed again with the same values, and its completed steps are
+	// cleared
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:XValidation:rule="self >= oldSelf",message="resetGeneration cannot decrease"
+	ResetGeneration int64 `json:"resetGeneration,omitempty"`
+}
+
+// UpdatePolicyType defines when a running scenario is upgraded
+// +kubebuilder:validation:Enum=Manual;Auto;Window
+type UpdatePolicyType string
+
+const (
+	// UpdatePolicyManual only reports available updates
+	UpdatePolicyManual UpdatePolicyType = "Manual"
+	// UpdatePolicyAuto upgrades the scenario as soon as an update is available
+	UpdatePolicyAuto UpdatePolicyType = "Auto"
+	// UpdatePolicyWindow upgrades the scenario during its update window
+	UpdatePolicyWindow UpdatePolicyType = "Window"
+)
+
+// UpdatePolicy controls the in-place upgrades of a running scenario
+// +kubebuilder:validation:XValidation:rule="self.type != 'Window' || has(self.window)",message="window must be set for the Window type"
+// +kubebuilder:validation:XValidation:rule="!has(self.interval) || duration(self.interval) >= duration('1m')",message="interval must be at least 1m"
+type UpdatePolicy struct {
+	// Type is when the scenario is upgraded
+	// +kubebuilder:validation:Required
+	Type UpdatePolicyType `json:"type"`
+
+	// Interval is how often the chart source is checked for a new commit or
+	// version (defaults to the operator setting). The source is not checked
+	// with the Manual type.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Window is when upgrades may happen with the Window type
+	// +optional
+	Window *UpdateWindow `json:"window,omitempty"`
+}
+
+// UpdateWindow is a recurring window upgrades are allowed in
+// +kubebuilder:validation:XValidation:rule="duration(self.duration) > duration('0s') && duration(self.duration) <= duration('24h')",message="duration must be between 0 and 24h"
+type UpdateWindow struct {
+	// Start is the time of day the window opens, as HH:MM
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start"`
+
+	// Duration is how long the window stays open, at most 24h
+	// +kubebuilder:validation:Required
+	Duration metav1.Duration `json:"duration"`
+
+	// Days are the days of the week the window opens on (defaults to every day)
+	// +optional
+	Days []Weekday `json:"days,omitempty"`
+
+	// TimeZone is the IANA time zone of Start (defaults to UTC)
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Weekday is a day of the week
+// +kubebuilder:validation:Enum=Monday;Tuesday;Wednesday;Thursday;Friday;Saturday;Sunday
+type Weekday string
+
+// ActiveScenarioPhase defines the phase of scenario deployment
+// +kubebuilder:validation:Enum=Pending;Deploying;Running;Failed;Terminating
+type ActiveScenarioPhase string
+
+const (
+	// ActiveScenarioPhasePending means the scenario is waiting to be deployed
+	ActiveScenarioPhasePending ActiveScenarioPhase = "Pending"
+	// ActiveScenarioPhaseDeploying means the scenario is being deployed
+	ActiveScenarioPhaseDeploying ActiveScenarioPhase = "Deploying"
+	// ActiveScenarioPhaseRunning means the scenario is successfully running
+	ActiveScenarioPhaseRunning ActiveScenarioPhase = "Running"
+	// ActiveScenarioPhaseFailed means the scenario deployment failed
+	ActiveScenarioPhaseFailed ActiveScenarioPhase = "Failed"
+	// ActiveScenarioPhaseTerminating means the scenario is being terminated
+	ActiveScenarioPhaseTerminating ActiveScenarioPhase = "Terminating"
+)
+
+const (
+	// ActiveScenarioConditionReady reports whether the requested scenario is
+	// installed and running. Its reason is the current phase.
+	ActiveScenarioConditionReady = "Ready"
+	// ActiveScenarioConditionUpToDate reports whether the running scenario
+	// was installed from the current generation of its ScenarioDefinition
+	ActiveScenarioConditionUpToDate = "UpToDate"
+	// ActiveScenarioConditionPreInstallHook reports the outcome of the
+	// preInstall hook of the scenario
+	ActiveScenarioConditionPreInstallHook = "PreInstallHook"
+	// ActiveScenarioConditionPostInstallHook reports the outcome of the
+	// postInstall hook of the scenario
+	ActiveScenarioConditionPostInstallHook = "PostInstallHook"
+	// ActiveScenarioConditionPreUninstallHook reports the outcome of the
+	// preUninstall hook of the uninstalled scenario
+	ActiveScenarioConditionPreUninstallHook = "PreUninstallHook"
+	// ActiveScenarioConditionPostUninstallHook reports the outcome of the
+	// postUninstall hook of the uninstalled scenario
+	ActiveScenarioConditionPostUninstallHook = "PostUninstallHook"
+)
+
+// EndpointSource tells where an endpoint of a scenario was found
+// +kubebuilder:validation:Enum=Declared;Ingress;HTTPRoute;LoadBalancer;NodePort
+type EndpointSource string
+
+const (
+	// EndpointSourceDeclared is an endpoint declared by the ScenarioDefinition
+	EndpointSourceDeclared EndpointSource = "Declared"
+	// EndpointSourceIngress is a host of an Ingress
+	EndpointSourceIngress EndpointSource = "Ingress"
+	// EndpointSourceHTTPRoute is a hostname of a Gateway API HTTPRoute
+	EndpointSourceHTTPRoute EndpointSource = "HTTPRoute"
+	// EndpointSourceLoadBalancer is a port of a LoadBalancer Service
+	EndpointSourceLoadBalancer EndpointSource = "LoadBalancer"
+	// EndpointSourceNodePort is a port of a NodePort Service
+	EndpointSourceNodePort EndpointSource = "NodePort"
+)
+
+// EndpointStatus is an endpoint participants reach the running scenario at
+type EndpointStatus struct {
+	// Name identifies the endpoint, discovered endpoints are named after the
+	// resource exposing them
+	Name string `json:"name"`
+
+	// URL is the address of the endpoint
+	URL string `json:"url"`
+
+	// Purpose tells participants what the endpoint is for
+	// +optional
+	Purpose string `json:"purpose,omitempty"`
+
+	// Source tells where the endpoint was found
+	Source EndpointSource `json:"source"`
+}
+
+// StepPhase is the progress of a step of the scenario
+// +kubebuilder:validation:Enum=Pending;InProgress;Completed
+type StepPhase string
+
+const (
+	// StepPhasePending means the steps before it are not completed yet
+	StepPhasePending StepPhase = "Pending"
+	// StepPhaseInProgress means the step is the current step
+	StepPhaseInProgress StepPhase = "InProgress"
+	// StepPhaseCompleted means the step was verified or marked as completed
+	StepPhaseCompleted StepPhase = "Completed"
+)
+
+// StepStatus is the progress of a step of the running scenario
+type StepStatus struct {
+	// Name is the name of the step
+	Name string `json:"name"`
+
+	// Phase is the progress of the step
+	Phase StepPhase `json:"phase"`
+
+	// StartedAt is when the step became the current step
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// CompletedAt is when the step was completed
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// LastVerification is when the step was last verified
+	// +optional
+	LastVerification *metav1.Time `json:"lastVerification,omitempty"`
+
+	// Message is the outcome of the last verification
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// ActiveScenarioStatus defines the observed state of ActiveScenario
+type ActiveScenarioStatus struct {
+	// Phase is the current phase of the scenario deployment
+	// +optional
+	Phase ActiveScenarioPhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation last processed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// DefinitionGeneration is the generation of the ScenarioDefinition last
+	// processed by the controller
+	// +optional
+	DefinitionGeneration int64 `json:"definitionGeneration,omitempty"`
+
+	// AvailableRevision is the latest commit or chart version of the chart
+	// source found by polling it
+	// +optional
+	AvailableRevision string `json:"availableRevision,omitempty"`
+
+	// LastSourceCheck is when the chart source was last polled
+	// +optional
+	LastSourceCheck *metav1.Time `json:"lastSourceCheck,omitempty"`
+
+	// ScenarioName is the name of the deployed scenario
+	// +optional
+	ScenarioName string `json:"scenarioName,omitempty"`
+
+	// HelmReleaseName is the name of the Helm release
+	// +optional
+	HelmReleaseName string `json:"helmReleaseName,omitempty"`
+
+	// StartTime is when the scenario was started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Endpoints are the endpoints participants reach the running scenario at
+	// +optional
+	// +listType=atomic
+	Endpoints []EndpointStatus `json:"endpoints,omitempty"`
+
+	// CredentialsSecret is the Secret holding the credentials generated for
+	// the running scenario
+	// +optional
+	CredentialsSecret *corev1.SecretReference `json:"credentialsSecret,omitempty"`
+
+	// Steps is the progress of the steps of the running scenario
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Steps []StepStatus `json:"steps,omitempty"`
+
+	// CurrentStep is the first step not completed yet, empty once every step
+	// is completed
+	// +optional
+	CurrentStep string `json:"currentStep,omitempty"`
+
+	// HookJob is the Job of the hook being run, checked until it completes
+	// +optional
+	HookJob *HookJobStatus `json:"hookJob,omitempty"`
+
+	// Conditions represent the latest observations of the scenario state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// HookJobStatus is a running hook Job
+type HookJobStatus struct {
+	// Hook is the hook the Job runs, such as preInstall
+	Hook string `json:"hook"`
+
+	// Namespace is the namespace of the Job
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the Job
+	Name string `json:"name"`
+
+	// StartTime is when the Job was created
+	StartTime metav1.Time `json:"startTime"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=as;active
+//+kubebuilder:printcolumn:name="Scenario",type="string",JSONPath=".spec.scenarioId"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Step",type="string",JSONPath=".status.currentStep"
+//+kubebuilder:printcolumn:name="Helm Release",type="string",JSONPath=".status.helmReleaseName"
+//+kubebuilder:printcolumn:name="Started",type="date",JSONPath=".status.startTime"
+
+// ActiveScenario is the Schema for the activescenarios API
+type ActiveScenario struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ActiveScenarioSpec   `json:"spec,omitempty"`
+	Status ActiveScenarioStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ActiveScenarioList contains a list of ActiveScenario
+type ActiveScenarioList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ActiveScenario `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ActiveScenario{}, &ActiveScenarioList{})
+}
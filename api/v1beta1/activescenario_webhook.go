@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// RequestedByAnnotation records the user who created the ActiveScenario
+	// or last changed its spec
+	RequestedByAnnotation = "devopsbeerer.io/requested-by"
+	// DeletedByAnnotation records the user about to delete the ActiveScenario.
+	// Deletions cannot be mutated, so clients set it right before deleting,
+	// and the webhook replaces whatever they set with the request user.
+	DeletedByAnnotation = "devopsbeerer.io/deleted-by"
+)
+
+// SetupWebhookWithManager registers the ActiveScenario webhooks with the
+// manager. Changes made by the given user, the operator ServiceAccount, keep
+// the recorded requester.
+func (r *ActiveScenario) SetupWebhookWithManager(mgr ctrl.Manager, operatorUser string) error {
+	mgr.GetWebhookServer().Register("/mutate-devopsbeerer-ch-v1beta1-activescenario", &webhook.Admission{
+		Handler: &activeScenarioRequester{decoder: admission.NewDecoder(mgr.GetScheme()), operatorUser: operatorUser},
+	})
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-devopsbeerer-ch-v1beta1-activescenario,mutating=true,failurePolicy=ignore,sideEffects=None,groups=devopsbeerer.ch,resources=activescenarios,verbs=create;update,versions=v1beta1,name=mactivescenario.devopsbeerer.ch,admissionReviewVersions=v1
+
+// activeScenarioRequester records the users requesting and deleting scenarios,
+// which the controller reports as the initiator of installs and uninstalls
+type activeScenarioRequester struct {
+	decoder admission.Decoder
+	// operatorUser is the user name of the operator ServiceAccount
+	operatorUser string
+}
+
+// Handle records the request user on the ActiveScenario. It never denies a
+// request.
+func (d *activeScenarioRequester) Handle(ctx context.Context, req admission.Request) admission.Response {
+	activeScenario := &ActiveScenario{}
+	if err := d.decoder.Decode(req, activeScenario); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *ActiveScenario
+	if req.Operation == admissionv1.Update {
+		old = &ActiveScenario{}
+		if err := d.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, fmt.Errorf("failed to decode previous ActiveScenario: %w", err))
+		}
+	}
+	// The operator changes the spec on behalf of users, such as the
+	// parameters of a restored snapshot, which keeps the requester
+	if old != nil && d.operatorUser != "" && req.UserInfo.Username == d.operatorUser {
+		keepAnnotation(activeScenario, old, RequestedByAnnotation)
+		keepAnnotation(activeScenario, old, DeletedByAnnotation)
+	} else {
+		recordRequester(activeScenario, old, req.UserInfo.Username)
+		recordDeleter(activeScenario, old, req.UserInfo.Username)
+	}
+
+	marshaled, err := json.Marshal(activeScenario)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// recordRequester records the request user when the ActiveScenario is created
+// or a change of its spec asks for another installation, and otherwise keeps
+// the recorded user. Changes to the update policy or the lifetime of the
+// scenario, such as the controller clearing a manual override, keep it.
+func recordRequester(activeScenario, old *ActiveScenario, username string) {
+	if old != nil && old.Spec.ScenarioID == activeScenario.Spec.ScenarioID &&
+		apiequality.Semantic.DeepEqual(old.Spec.Parameters, activeScenario.Spec.Parameters) &&
+		old.Spec.ResetGeneration == activeScenario.Spec.ResetGeneration {
+		keepAnnotation(activeScenario, old, RequestedByAnnotation)
+		return
+	}
+	metav1.SetMetaDataAnnotation(&activeScenario.ObjectMeta, RequestedByAnnotation, username)
+}
+
+// recordDeleter records the request user as the deleting user when the
+// request sets the deleted-by annotation, so that nobody can name another
+// user, and otherwise keeps the recorded user
+func recordDeleter(activeScenario, old *ActiveScenario, username string) {
+	deletedBy, ok := activeScenario.Annotations[DeletedByAnnotation]
+	if !ok {
+		return
+	}
+	if old != nil && old.Annotations[DeletedByAnnotation] == deletedBy {
+		return
+	}
+	metav1.SetMetaDataAnnotation(&activeScenario.ObjectMeta, DeletedByAnnotation, username)
+}
+
+// keepAnnotation restores the previous value of an annotation
+func keepAnnotation(activeScenario, old *ActiveScenario, key string) {
+	if value, ok := old.Annotations[key]; ok {
+		metav1.SetMetaDataAnnotation(&activeScenario.ObjectMeta, key, value)
+	} else {
+		delete(activeScenario.Annotations, key)
+	}
+}
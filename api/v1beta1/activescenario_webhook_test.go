@@ -0,0 +1,198 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestRecordRequester(t *testing.T) {
+	old := &ActiveScenario{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "active",
+			Annotations: map[string]string{RequestedByAnnotation: "alice"},
+		},
+		Spec: ActiveScenarioSpec{
+			ScenarioID: "basic-oauth2",
+			Parameters: map[string]string{"replicas": "2"},
+		},
+	}
+
+	tests := map[string]struct {
+		old    *ActiveScenario
+		update func(*ActiveScenario)
+		want   string
+	}{
+		"create": {
+			update: func(*ActiveScenario) {},
+			want:   "bob",
+		},
+		"metadata change": {
+			old:    old,
+			update: func(as *ActiveScenario) { as.Labels = map[string]string{"team": "a"} },
+			want:   "alice",
+		},
+		"annotation overwritten": {
+			old:    old,
+			update: func(as *ActiveScenario) { as.Annotations[RequestedByAnnotation] = "mallory" },
+			want:   "alice",
+		},
+		"update policy change": {
+			old:    old,
+			update: func(as *ActiveScenario) { as.Spec.UpdatePolicy = &UpdatePolicy{Type: UpdatePolicyWindow} },
+			want:   "alice",
+		},
+		"scenario change": {
+			old:    old,
+			update: func(as *ActiveScenario) { as.Spec.ScenarioID = "keycloak" },
+			want:   "bob",
+		},
+		"parameters change": {
+			old:    old,
+			update: func(as *ActiveScenario) { as.Spec.Parameters["replicas"] = "3" },
+			want:   "bob",
+		},
+		"reset": {
+			old:    old,
+			update: func(as *ActiveScenario) { as.Spec.ResetGeneration++ },
+			want:   "bob",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			activeScenario := old.DeepCopy()
+			tt.update(activeScenario)
+			recordRequester(activeScenario, tt.old, "bob")
+			if got := activeScenario.Annotations[RequestedByAnnotation]; got != tt.want {
+				t.Errorf("requested by = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordDeleter(t *testing.T) {
+	tests := map[string]struct {
+		old         map[string]string
+		annotations map[string]string
+		want        string
+	}{
+		"not set": {
+			annotations: map[string]string{},
+		},
+		"set": {
+			annotations: map[string]string{DeletedByAnnotation: "bob"},
+			want:        "bob",
+		},
+		"another user named": {
+			annotations: map[string]string{DeletedByAnnotation: "alice"},
+			want:        "bob",
+		},
+		"kept": {
+			old:         map[string]string{DeletedByAnnotation: "alice"},
+			annotations: map[string]string{DeletedByAnnotation: "alice"},
+			want:        "alice",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var old *ActiveScenario
+			if tt.old != nil {
+				old = &ActiveScenario{ObjectMeta: metav1.ObjectMeta{Annotations: tt.old}}
+			}
+			activeScenario := &ActiveScenario{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			recordDeleter(activeScenario, old, "bob")
+			if got := activeScenario.Annotations[DeletedByAnnotation]; got != tt.want {
+				t.Errorf("deleted by = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleOperatorUser(t *testing.T) {
+	const operatorUser = "system:serviceaccount:devopsbeerer:devopsbeerer-operator"
+	old := &ActiveScenario{
+		TypeMeta: metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "ActiveScenario"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "active",
+			Annotations: map[string]string{RequestedByAnnotation: "alice"},
+		},
+		Spec: ActiveScenarioSpec{
+			ScenarioID: "basic-oauth2",
+			Parameters: map[string]string{"replicas": "2"},
+		},
+	}
+
+	tests := map[string]struct {
+		username string
+		want     string
+	}{
+		"operator restoring parameters": {username: operatorUser, want: "alice"},
+		"user changing parameters":      {username: "bob", want: "bob"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			updated := old.DeepCopy()
+			updated.Spec.Parameters["replicas"] = "3"
+			oldRaw, err := json.Marshal(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := json.Marshal(updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			requester := &activeScenarioRequester{decoder: admission.NewDecoder(scheme), operatorUser: operatorUser}
+			response := requester.Handle(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Update,
+					UserInfo:  authenticationv1.UserInfo{Username: tt.username},
+					Object:    runtime.RawExtension{Raw: raw},
+					OldObject: runtime.RawExtension{Raw: oldRaw},
+				},
+			})
+			if !response.Allowed {
+				t.Fatalf("Handle() denied the request: %v", response.Result)
+			}
+
+			got := "alice"
+			for _, operation := range response.Patches {
+				if operation.Path == "/metadata/annotations/devopsbeerer.io~1requested-by" {
+					got, _ = operation.Value.(string)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("requested by = %q, want %q (patches %v)", got, tt.want, response.Patches)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CatalogGitSource locates the charts of a catalog in a Git repository
+type CatalogGitSource struct {
+	// URL is the Git repository URL holding the scenario charts
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^https://.*\.git$`
+	// +kubebuilder:default=`https://github.com/DevOpsBeerer/playground-scenarios-charts.git`
+	URL string `json:"url"`
+
+	// Ref is the branch, tag or commit to sync (optional, defaults to the remote HEAD)
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[^-]`
+	Ref string `json:"ref,omitempty"`
+
+	// Path is the directory containing the chart directories (optional,
+	// defaults to the repository root)
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// ScenarioCatalogSpec defines the desired state of ScenarioCatalog
+type ScenarioCatalogSpec struct {
+	// Git is the repository the scenario charts are imported from
+	// +kubebuilder:validation:Required
+	Git CatalogGitSource `json:"git"`
+
+	// Manifest is the path, relative to the catalog path, of a catalog manifest
+	// listing the scenarios. When unset, every application chart under the
+	// catalog path is imported from the annotations of its Chart.yaml.
+	// +optional
+	// +kubebuilder:example="catalog.yaml"
+	Manifest string `json:"manifest,omitempty"`
+
+	// Interval is how often the repository is synced
+	// +optional
+	// +kubebuilder:default="10m"
+	// +kubebuilder:validation:XValidation:rule="duration(self) >= duration('1m')",message="interval must be at least 1m"
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Prune deletes the ScenarioDefinitions of the catalog whose scenario is no
+	// longer listed
+	// +optional
+	// +kubebuilder:default=true
+	Prune *bool `json:"prune,omitempty"`
+
+	// TrustedFields are the fields of manifest entries that run code or grant
+	// rights which are imported from the repository. Entries setting any other
+	// of these fail, so that pushing to the repository does not let one run
+	// Jobs or pick the identity charts are installed with.
+	// +optional
+	// +listType=set
+	TrustedFields []CatalogTrustedField `json:"trustedFields,omitempty"`
+}
+
+// CatalogTrustedField is a field of catalog manifest entries only imported
+// when the catalog trusts it
+// +kubebuilder:validation:Enum=serviceAccount;guardrails;charts;hooks;endpoints;credentials;steps;checks
+type CatalogTrustedField string
+
+const (
+	// CatalogTrustedFieldServiceAccount imports the identity charts are installed with
+	CatalogTrustedFieldServiceAccount CatalogTrustedField = "serviceAccount"
+	// CatalogTrustedFieldGuardrails imports the quota and pod security of namespaces
+	CatalogTrustedFieldGuardrails CatalogTrustedField = "guardrails"
+	// CatalogTrustedFieldCharts imports the additional charts installed
+	CatalogTrustedFieldCharts CatalogTrustedField = "charts"
+	// CatalogTrustedFieldHooks imports the hook Jobs
+	CatalogTrustedFieldHooks CatalogTrustedField = "hooks"
+	// CatalogTrustedFieldEndpoints imports the endpoints published
+	CatalogTrustedFieldEndpoints CatalogTrustedField = "endpoints"
+	// CatalogTrustedFieldCredentials imports the credentials generated
+	CatalogTrustedFieldCredentials CatalogTrustedField = "credentials"
+	// CatalogTrustedFieldSteps imports the steps and their verifications
+	CatalogTrustedFieldSteps CatalogTrustedField = "steps"
+	// CatalogTrustedFieldChecks imports the graded checks
+	CatalogTrustedFieldChecks CatalogTrustedField = "checks"
+)
+
+// CatalogEntryState is the sync state of a catalog entry
+// +kubebuilder:validation:Enum=Synced;Failed
+type CatalogEntryState string
+
+const (
+	// CatalogEntryStateSynced means the ScenarioDefinition matches the entry
+	CatalogEntryStateSynced CatalogEntryState = "Synced"
+	// CatalogEntryStateFailed means the entry could not be read or applied
+	CatalogEntryStateFailed CatalogEntryState = "Failed"
+)
+
+// CatalogEntryStatus reports the sync of one scenario of a catalog
+type CatalogEntryStatus struct {
+	// ID is the scenario ID of the entry
+	ID string `json:"id"`
+
+	// Path is the chart directory of the entry in the repository
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Definition is the name of the ScenarioDefinition of the entry
+	// +optional
+	Definition string `json:"definition,omitempty"`
+
+	// State is the sync state of the entry
+	State CatalogEntryState `json:"state"`
+
+	// Message explains why the entry failed to sync
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// ScenarioCatalogConditionReady reports whether the last sync of the
+	// catalog succeeded for every entry
+	ScenarioCatalogConditionReady = "Ready"
+)
+
+// ScenarioCatalogStatus defines the observed state of ScenarioCatalog
+type ScenarioCatalogStatus struct {
+	// ObservedGeneration is the generation last synced by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Revision is the commit last synced
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// LastSyncTime is when the repository was last synced
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Entries reports the sync of each scenario listed by the catalog
+	// +optional
+	// +listType=map
+	// +listMapKey=id
+	Entries []CatalogEntryStatus `json:"entries,omitempty"`
+
+	// Conditions represent the latest observations of the catalog state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=scncat
+//+kubebuilder:printcolumn:name="URL",type="string",JSONPath=".spec.git.url"
+//+kubebuilder:printcolumn:name="Revision",type="string",JSONPath=".status.revision"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ScenarioCatalog is the Schema for the scenariocatalogs API. It imports the
+// charts of a Git repository as ScenarioDefinitions it owns.
+type ScenarioCatalog struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScenarioCatalogSpec   `json:"spec,omitempty"`
+	Status ScenarioCatalogStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScenarioCatalogList contains a list of ScenarioCatalog
+type ScenarioCatalogList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScenarioCatalog `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScenarioCatalog{}, &ScenarioCatalogList{})
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScenarioCheckSpec defines the desired state of ScenarioCheck
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type ScenarioCheckSpec struct {
+	// ActiveScenario is the name of the ActiveScenario whose running scenario
+	// is graded
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:example="current"
+	ActiveScenario string `json:"activeScenario"`
+
+	// Checks are the names of the graded checks to run (optional, defaults
+	// to every check of the scenario)
+	// +optional
+	// +listType=set
+	// +kubebuilder:validation:MaxItems=50
+	Checks []string `json:"checks,omitempty"`
+}
+
+// ScenarioCheckPhase is the phase of a ScenarioCheck
+// +kubebuilder:validation:Enum=Pending;Running;Completed;Failed
+type ScenarioCheckPhase string
+
+const (
+	// ScenarioCheckPhasePending means the checks have not started
+	ScenarioCheckPhasePending ScenarioCheckPhase = "Pending"
+	// ScenarioCheckPhaseRunning means checks are running
+	ScenarioCheckPhaseRunning ScenarioCheckPhase = "Running"
+	// ScenarioCheckPhaseCompleted means every check ran and the score is
+	// recorded
+	ScenarioCheckPhaseCompleted ScenarioCheckPhase = "Completed"
+	// ScenarioCheckPhaseFailed means the checks could not be run, such as
+	// when no scenario is running
+	ScenarioCheckPhaseFailed ScenarioCheckPhase = "Failed"
+)
+
+// CheckResultPhase is the phase of a graded check
+// +kubebuilder:validation:Enum=Pending;Running;Passed;Failed
+type CheckResultPhase string
+
+const (
+	// CheckResultPhasePending means the check has not started
+	CheckResultPhasePending CheckResultPhase = "Pending"
+	// CheckResultPhaseRunning means the Job of the check is running
+	CheckResultPhaseRunning CheckResultPhase = "Running"
+	// CheckResultPhasePassed means the check passed and its points are awarded
+	CheckResultPhasePassed CheckResultPhase = "Passed"
+	// CheckResultPhaseFailed means the check failed
+	CheckResultPhaseFailed CheckResultPhase = "Failed"
+)
+
+// CheckResult reports a graded check
+type CheckResult struct {
+	// Name is the name of the graded check
+	Name string `json:"name"`
+
+	// Phase is the phase of the check
+	Phase CheckResultPhase `json:"phase"`
+
+	// Score is the points awarded
+	// +optional
+	Score int32 `json:"score,omitempty"`
+
+	// MaxScore is the points of the check
+	// +optional
+	MaxScore int32 `json:"maxScore,omitempty"`
+
+	// Job is the name of the Job running the check
+	// +optional
+	Job string `json:"job,omitempty"`
+
+	// StartedAt is when the check started
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// CompletedAt is when the check passed or failed
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+
+	// Message is the outcome of the check
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Logs are the last lines logged by the Job of the check
+	// +optional
+	Logs string `json:"logs,omitempty"`
+}
+
+// ScenarioCheckStatus defines the observed state of ScenarioCheck
+type ScenarioCheckStatus struct {
+	// Phase is the phase of the ScenarioCheck
+	// +optional
+	Phase ScenarioCheckPhase `json:"phase,omitempty"`
+
+	// ScenarioID is the ID of the scenario graded
+	// +optional
+	ScenarioID string `json:"scenarioId,omitempty"`
+
+	// History is the name of the ScenarioHistory the score is recorded on
+	// +optional
+	History string `json:"history,omitempty"`
+
+	// StartTime is when the checks started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when every check ran
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Score is the sum of the points awarded
+	// +optional
+	Score int32 `json:"score,omitempty"`
+
+	// MaxScore is the sum of the points of the checks run
+	// +optional
+	MaxScore int32 `json:"maxScore,omitempty"`
+
+	// Results report each graded check
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Results []CheckResult `json:"results,omitempty"`
+
+	// Message provides additional information about the current status
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=scnchk
+//+kubebuilder:printcolumn:name="Active Scenario",type="string",JSONPath=".spec.activeScenario"
+//+kubebuilder:printcolumn:name="Scenario",type="string",JSONPath=".status.scenarioId"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Score",type="integer",JSONPath=".status.score"
+//+kubebuilder:printcolumn:name="Max",type="integer",JSONPath=".status.maxScore"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ScenarioCheck is the Schema for the scenariochecks API. It runs the graded
+// checks of a running scenario once, and records the score on its
+// ScenarioHistory.
+type ScenarioCheck struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScenarioCheckSpec   `json:"spec,omitempty"`
+	Status ScenarioCheckStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScenarioCheckList contains a list of ScenarioCheck
+type ScenarioCheckList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScenarioCheck `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScenarioCheck{}, &ScenarioCheckList{})
+}
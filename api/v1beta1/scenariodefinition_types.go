@@ -0,0 +1,669 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ChartSource defines where the helm chart of a scenario is fetched from
+// +kubebuilder:validation:XValidation:rule="has(self.git) != has(self.repository)",message="exactly one of git or repository must be set"
+type ChartSource struct {
+	// Git fetches the chart from a directory of a Git repository
+	// +optional
+	Git *GitChartSource `json:"git,omitempty"`
+
+	// Repository fetches the chart from a Helm or OCI chart repository
+	// +optional
+	Repository *RepositoryChartSource `json:"repository,omitempty"`
+}
+
+// GitChartSource locates a helm chart inside a Git repository
+type GitChartSource struct {
+	// URL is the Git repository URL for helm charts
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^https://.*\.git$`
+	// +kubebuilder:default=`https://github.com/DevOpsBeerer/playground-scenarios-charts.git`
+	URL string `json:"url"`
+
+	// Ref is the branch, tag or commit to check out (optional, defaults to the remote HEAD)
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[^-]`
+	Ref string `json:"ref,omitempty"`
+
+	// Path is the subdirectory containing the helm chart (optional, defaults to scenario ID)
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// RepositoryChartSource locates a helm chart in a chart repository
+type RepositoryChartSource struct {
+	// URL is the chart repository URL
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^(https|oci)://.*$`
+	URL string `json:"url"`
+
+	// Chart is the name of the chart in the repository
+	// +kubebuilder:validation:Required
+	Chart string `json:"chart"`
+
+	// Version is the chart version constraint (optional, defaults to the latest version)
+	// +optional
+	Version string `json:"version,omitempty"`
+}
+
+// ScenarioServiceAccount defines the identity the chart of a scenario is
+// installed with. The operator impersonates it when running Helm.
+// +kubebuilder:validation:XValidation:rule="has(self.name) != has(self.rules)",message="exactly one of name or rules must be set"
+// +kubebuilder:validation:XValidation:rule="has(self.name) == has(self.namespace)",message="namespace must be set together with name"
+type ScenarioServiceAccount struct {
+	// Name is an existing ServiceAccount the chart is installed as. It must be
+	// granted the rights the chart needs in the scenario namespace, and be in
+	// the shared namespace or allowed by the operator.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Namespace is the namespace of the existing ServiceAccount
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Rules generate a ServiceAccount in the scenario namespace bound to a Role
+	// granting these rules, plus access to the Helm release secrets
+	// +optional
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// PodSecurityLevel is a Pod Security Standards level
+// +kubebuilder:validation:Enum=privileged;baseline;restricted
+type PodSecurityLevel string
+
+const (
+	// PodSecurityLevelPrivileged enforces no restriction
+	PodSecurityLevelPrivileged PodSecurityLevel = "privileged"
+	// PodSecurityLevelBaseline prevents known privilege escalations
+	PodSecurityLevelBaseline PodSecurityLevel = "baseline"
+	// PodSecurityLevelRestricted enforces pod hardening best practices
+	PodSecurityLevelRestricted PodSecurityLevel = "restricted"
+)
+
+// ScenarioGuardrails restricts what the chart of a scenario can do in its namespace
+type ScenarioGuardrails struct {
+	// Quota is the hard limits of the namespace ResourceQuota, capped by the operator
+	// +optional
+	// +kubebuilder:example={"requests.cpu":"2","requests.memory":"4Gi","pods":"20"}
+	Quota corev1.ResourceList `json:"quota,omitempty"`
+
+	// DefaultRequests are the container requests set by the namespace LimitRange
+	// +optional
+	DefaultRequests corev1.ResourceList `json:"defaultRequests,omitempty"`
+
+	// DefaultLimits are the container limits set by the namespace LimitRange
+	// +optional
+	DefaultLimits corev1.ResourceList `json:"defaultLimits,omitempty"`
+
+	// Ingress is the traffic allowed into the namespace, on top of the traffic
+	// between its own pods
+	// +optional
+	Ingress []networkingv1.NetworkPolicyIngressRule `json:"ingress,omitempty"`
+
+	// Egress is the traffic allowed out of the namespace, on top of DNS and the
+	// traffic between its own pods
+	// +optional
+	Egress []networkingv1.NetworkPolicyEgressRule `json:"egress,omitempty"`
+
+	// PodSecurity is the Pod Security Standards level enforced in the namespace
+	// (optional, defaults to the operator level). Levels less restrictive than
+	// the operator level are raised to it.
+	// +optional
+	PodSecurity PodSecurityLevel `json:"podSecurity,omitempty"`
+}
+
+// ScenarioParameterType defines the type of a scenario parameter
+// +kubebuilder:validation:Enum=string;integer;number;boolean
+type ScenarioParameterType string
+
+const (
+	// ScenarioParameterTypeString is a free-form string parameter
+	ScenarioParameterTypeString ScenarioParameterType = "string"
+	// ScenarioParameterTypeInteger is a whole number parameter
+	ScenarioParameterTypeInteger ScenarioParameterType = "integer"
+	// ScenarioParameterTypeNumber is a floating point parameter
+	ScenarioParameterTypeNumber ScenarioParameterType = "number"
+	// ScenarioParameterTypeBoolean is a true/false parameter
+	ScenarioParameterTypeBoolean ScenarioParameterType = "boolean"
+)
+
+// ScenarioParameter defines a typed parameter that can be set on an ActiveScenario
+type ScenarioParameter struct {
+	// Name is the parameter name used in ActiveScenario parameters
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z][a-zA-Z0-9_]*$`
+	// +kubebuilder:example="replicas"
+	Name string `json:"name"`
+
+	// Type is the type of the parameter value
+	// +kubebuilder:default=string
+	// +optional
+	Type ScenarioParameterType `json:"type,omitempty"`
+
+	// Description explains what the parameter does
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Default is the value used when the parameter is not set, which may be
+	// the empty string
+	// +optional
+	Default *string `json:"default,omitempty"`
+
+	// Enum restricts the parameter to a fixed set of values
+	// +optional
+	Enum []string `json:"enum,omitempty"`
+
+	// Required means the parameter must be set when it has no default
+	// +optional
+	Required bool `json:"required,omitempty"`
+
+	// Path is the dotted Helm values path the parameter is mapped onto (optional, defaults to name)
+	// +optional
+	// +kubebuilder:example="backend.replicaCount"
+	Path string `json:"path,omitempty"`
+}
+
+// ReadinessGate defines when the release of a chart is ready for the next
+// chart to be installed
+type ReadinessGate struct {
+	// Timeout is how long the resources of the release may take to become
+	// ready (optional, defaults to 10m)
+	// +optional
+	// +kubebuilder:validation:XValidation:rule="duration(self) > duration('0s')",message="timeout must be positive"
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// WaitForJobs also waits for the Jobs of the release to complete, such as
+	// a Job seeding data
+	// +optional
+	WaitForJobs bool `json:"waitForJobs,omitempty"`
+}
+
+// ScenarioChart defines an additional chart of a scenario, installed as a
+// Helm release of its own after the main chart
+type ScenarioChart struct {
+	// Name identifies the chart in the scenario and suffixes its release name
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:validation:MaxLength=20
+	// +kubebuilder:example="frontend"
+	Name string `json:"name"`
+
+	// Chart defines where the helm chart is fetched from
+	// +kubebuilder:validation:Required
+	Chart ChartSource `json:"chart"`
+
+	// Values are the Helm values the chart is installed with. The parameters
+	// of the scenario only apply to the main chart.
+	// +optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+
+	// NamespaceSuffix installs the chart into a namespace of its own, named
+	// after the scenario namespace followed by this suffix (optional, defaults
+	// to the scenario namespace)
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:validation:MaxLength=20
+	NamespaceSuffix string `json:"namespaceSuffix,omitempty"`
+
+	// DependsOn lists the names of the charts installed and ready before this
+	// one. Charts are otherwise installed in the order they are listed.
+	// +optional
+	// +listType=set
+	// +kubebuilder:validation:MaxItems=10
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// Readiness defines when the release is ready for the next chart
+	// +optional
+	Readiness *ReadinessGate `json:"readiness,omitempty"`
+}
+
+// ScenarioEndpoint declares an endpoint participants reach the scenario at
+type ScenarioEndpoint struct {
+	// Name identifies the endpoint
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:example="admin-console"
+	Name string `json:"name"`
+
+	// URL is the address of the endpoint
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=2048
+	// +kubebuilder:example="https://keycloak.playground.local/admin"
+	URL string `json:"url"`
+
+	// Purpose tells participants what the endpoint is for
+	// +optional
+	// +kubebuilder:validation:MaxLength=256
+	// +kubebuilder:example="Keycloak admin console"
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// CredentialType is the kind of a generated credential
+// +kubebuilder:validation:Enum=Password;ClientSecret;RSAKeyPair;ECKeyPair;JWTSigningKey
+type CredentialType string
+
+const (
+	// CredentialTypePassword is a random password of letters, digits and
+	// the -_. symbols
+	CredentialTypePassword CredentialType = "Password"
+	// CredentialTypeClientSecret is a random OAuth2 client secret of letters
+	// and digits
+	CredentialTypeClientSecret CredentialType = "ClientSecret"
+	// CredentialTypeRSAKeyPair is an RSA private key and its public key, PEM
+	// encoded
+	CredentialTypeRSAKeyPair CredentialType = "RSAKeyPair"
+	// CredentialTypeECKeyPair is an ECDSA private key and its public key, PEM
+	// encoded
+	CredentialTypeECKeyPair CredentialType = "ECKeyPair"
+	// CredentialTypeJWTSigningKey is an RSA key pair signing RS256 JWTs,
+	// published with its public JSON Web Key Set
+	CredentialTypeJWTSigningKey CredentialType = "JWTSigningKey"
+)
+
+// ScenarioCredential declares a credential generated for every activation of
+// a scenario. Credentials are stored in the scenario-credentials Secret of
+// the scenario namespace, under a key named after the credential, with the
+// .key, .pub and .jwks suffixes for the private key, public key and key set.
+// +kubebuilder:validation:XValidation:rule="!has(self.length) || self.type == 'Password' || self.type == 'ClientSecret'",message="length only applies to passwords and client secrets"
+// +kubebuilder:validation:XValidation:rule="!has(self.bits) || self.type == 'RSAKeyPair' || self.type == 'JWTSigningKey'",message="bits only applies to RSA keys"
+// +kubebuilder:validation:XValidation:rule="!has(self.curve) || self.type == 'ECKeyPair'",message="curve only applies to EC keys"
+type ScenarioCredential struct {
+	// Name identifies the credential and names its Secret keys
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9]([-_a-zA-Z0-9]*[a-zA-Z0-9])?$`
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:example="admin-password"
+	Name string `json:"name"`
+
+	// Type is the kind of credential generated
+	// +kubebuilder:validation:Required
+	Type CredentialType `json:"type"`
+
+	// Length is the number of characters of a password or client secret
+	// (optional, defaults to 32)
+	// +optional
+	// +kubebuilder:validation:Minimum=12
+	// +kubebuilder:validation:Maximum=128
+	Length *int32 `json:"length,omitempty"`
+
+	// Bits is the size of an RSA key (optional, defaults to 2048)
+	// +optional
+	// +kubebuilder:validation:Enum=2048;3072;4096
+	Bits *int32 `json:"bits,omitempty"`
+
+	// Curve is the elliptic curve of an EC key (optional, defaults to P-256)
+	// +optional
+	// +kubebuilder:validation:Enum=P-256;P-384;P-521
+	Curve string `json:"curve,omitempty"`
+
+	// Path is the dotted Helm values path the reference to the credential is
+	// injected at (optional, defaults to credentials.<name>). The reference
+	// holds the secretName of the Secret and the key, or the privateKey,
+	// publicKey and jwks keys, of the credential, never the credential itself.
+	// +optional
+	// +kubebuilder:example="keycloak.admin.existingSecret"
+	Path string `json:"path,omitempty"`
+}
+
+// HTTPVerification checks an HTTP endpoint like a readiness probe, succeeding
+// on a status code from 200 to 399 unless expected status codes are set.
+// Redirects are not followed.
+type HTTPVerification struct {
+	// URL is the address requested by the operator on a Service of the
+	// scenario namespace, with a host like <service>.<namespace>.svc. Other
+	// addresses are refused, and the Service is only reachable when the
+	// guardrails of the namespace allow traffic from the operator.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=2048
+	// +kubebuilder:example="http://beer-api.devopsbeerer-basic-oauth2.svc:8080/beers"
+	URL string `json:"url"`
+
+	// HTTPHeaders are the headers sent with the request
+	// +optional
+	HTTPHeaders []corev1.HTTPHeader `json:"httpHeaders,omitempty"`
+
+	// BodyContains also requires the response body to contain this text
+	// +optional
+	BodyContains string `json:"bodyContains,omitempty"`
+
+	// ExpectedStatus lists the status codes the check succeeds on, such as
+	// 403 for a request that must be denied (optional, defaults to 200-399)
+	// +optional
+	// +kubebuilder:validation:MaxItems=10
+	// +kubebuilder:validation:items:Minimum=100
+	// +kubebuilder:validation:items:Maximum=599
+	ExpectedStatus []int32 `json:"expectedStatus,omitempty"`
+}
+
+// StepVerification checks whether a step of a scenario is completed
+// +kubebuilder:validation:XValidation:rule="has(self.http) != has(self.job)",message="exactly one of http or job must be set"
+type StepVerification struct {
+	// HTTP completes the step once an HTTP check succeeds
+	// +optional
+	HTTP *HTTPVerification `json:"http,omitempty"`
+
+	// Job completes the step once a Job run in the scenario namespace
+	// succeeds. A failed Job is run again at the next verification.
+	// +optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	Job *batchv1.JobTemplateSpec `json:"job,omitempty"`
+
+	// Interval is how often the current step is verified (optional, defaults
+	// to 30s)
+	// +optional
+	// +kubebuilder:validation:XValidation:rule="duration(self) >= duration('10s')",message="interval must be at least 10s"
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// ScenarioStep is a step of the exercise of a scenario
+type ScenarioStep struct {
+	// Name identifies the step
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:example="login"
+	Name string `json:"name"`
+
+	// Title is the human-readable title of the step
+	// +kubebuilder:validation:Required
+	// +kubebuilder:example="Log in with the authorization code flow"
+	Title string `json:"title"`
+
+	// Instructions are the Markdown instructions of the step
+	// +optional
+	// +kubebuilder:validation:MaxLength=65536
+	Instructions string `json:"instructions,omitempty"`
+
+	// Verification completes the step automatically. Steps without one are
+	// completed by listing them in the completedSteps of the ActiveScenario.
+	// +optional
+	Verification *StepVerification `json:"verification,omitempty"`
+}
+
+// GradedCheck is a check of the exercise of a scenario, run by a
+// ScenarioCheck to grade the learner
+// +kubebuilder:validation:XValidation:rule="has(self.http) != has(self.job)",message="exactly one of http or job must be set"
+type GradedCheck struct {
+	// Name identifies the check
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:example="deny-without-scope"
+	Name string `json:"name"`
+
+	// Description tells the learner what the check verifies
+	// +optional
+	// +kubebuilder:example="The API denies a token without the beers:write scope"
+	Description string `json:"description,omitempty"`
+
+	// Points are awarded when the check passes
+	// +optional
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=1000
+	Points int32 `json:"points,omitempty"`
+
+	// HTTP passes the check when an HTTP check of a Service of the scenario
+	// namespace succeeds
+	// +optional
+	HTTP *HTTPVerification `json:"http,omitempty"`
+
+	// Job passes the check when a Job run in the scenario namespace succeeds,
+	// such as a script calling an API with a token from the scenario
+	// credentials. The logs of the Job are recorded with the result.
+	// +optional
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	Job *batchv1.JobTemplateSpec `json:"job,omitempty"`
+
+	// Timeout fails a Job check still running after it (optional, defaults
+	// to 5m)
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// HookFailurePolicy defines what happens when a hook fails
+// +kubebuilder:validation:Enum=Abort;Ignore;Rollback
+type HookFailurePolicy string
+
+const (
+	// HookFailurePolicyAbort stops the activation and leaves what was
+	// installed in place. A failed uninstall hook stops the uninstallation,
+	// which is retried.
+	HookFailurePolicyAbort HookFailurePolicy = "Abort"
+	// HookFailurePolicyIgnore carries on as if the hook succeeded
+	HookFailurePolicyIgnore HookFailurePolicy = "Ignore"
+	// HookFailurePolicyRollback stops the activation and uninstalls what was
+	// installed. Only install hooks can roll back.
+	HookFailurePolicyRollback HookFailurePolicy = "Rollback"
+)
+
+// ScenarioHook defines a Job run in the scenario namespace
+type ScenarioHook struct {
+	// Template is the Job run for the hook
+	// +kubebuilder:validation:Required
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	Template batchv1.JobTemplateSpec `json:"template"`
+
+	// FailurePolicy defines what happens when the Job fails or times out.
+	// Uninstall hooks never block the uninstallation: their failure is only
+	// recorded, whatever the policy.
+	// +optional
+	// +kubebuilder:default=Abort
+	FailurePolicy HookFailurePolicy `json:"failurePolicy,omitempty"`
+
+	// Timeout is how long the Job may run (optional, defaults to 5m, at most 1h)
+	// +optional
+	// +kubebuilder:validation:XValidation:rule="duration(self) > duration('0s') && duration(self) <= duration('1h')",message="timeout must be positive and at most 1h"
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ScenarioHooks defines the Jobs run around the installation and the
+// uninstallation of a scenario. The Jobs are created as the ServiceAccount the
+// chart is installed as, which must then be allowed to create Jobs.
+// +kubebuilder:validation:XValidation:rule="!has(self.preUninstall) || self.preUninstall.failurePolicy != 'Rollback'",message="uninstall hooks cannot roll back"
+// +kubebuilder:validation:XValidation:rule="!has(self.postUninstall) || self.postUninstall.failurePolicy != 'Rollback'",message="uninstall hooks cannot roll back"
+type ScenarioHooks struct {
+	// PreInstall runs once the namespace is ready, before the shared
+	// components and the charts are installed
+	// +optional
+	PreInstall *ScenarioHook `json:"preInstall,omitempty"`
+
+	// PostInstall runs once every chart is installed, such as to seed demo data
+	// +optional
+	PostInstall *ScenarioHook `json:"postInstall,omitempty"`
+
+	// PreUninstall runs before the charts are uninstalled
+	// +optional
+	PreUninstall *ScenarioHook `json:"preUninstall,omitempty"`
+
+	// PostUninstall runs once the charts are uninstalled, before the namespace
+	// is deleted, such as to clean external state
+	// +optional
+	PostUninstall *ScenarioHook `json:"postUninstall,omitempty"`
+}
+
+// ScenarioDefinitionSpec defines the desired state of ScenarioDefinition
+type ScenarioDefinitionSpec struct {
+	// Name is the human-readable name of the scenario
+	// +kubebuilder:validation:Required
+	// +kubebuilder:example="Basic OAuth2 Beer Management"
+	Name string `json:"name"`
+
+	// ID is the unique identifier with hyphens
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:example="basic-oauth2-beer-mgmt"
+	ID string `json:"id"`
+
+	// Description is the detailed description of the scenario
+	// +kubebuilder:validation:Required
+	Description string `json:"description"`
+
+	// Chart defines where the helm chart is fetched from
+	// +kubebuilder:validation:Required
+	Chart ChartSource `json:"chart"`
+
+	// Charts are additional charts installed after the main chart, each as a
+	// Helm release of its own, in order of their dependencies
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=10
+	// +kubebuilder:validation:XValidation:rule="self.all(c, !has(c.dependsOn) || c.dependsOn.all(d, self.exists(o, o.name == d)))",message="dependsOn must name charts of the scenario"
+	Charts []ScenarioChart `json:"charts,omitempty"`
+
+	// Tags for categorizing scenarios
+	// +optional
+	// +kubebuilder:example={"oauth2","basic","api","crud"}
+	Tags []string `json:"tags,omitempty"`
+
+	// Features is the list of features demonstrated in this scenario
+	// +optional
+	// +kubebuilder:example={"authorization-code-flow","refresh-tokens","rbac","api-gateway"}
+	Features []string `json:"features,omitempty"`
+
+	// Parameters declares the typed parameters accepted by this scenario
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Parameters []ScenarioParameter `json:"parameters,omitempty"`
+
+	// ValuesSchema is a JSON schema (such as the chart's values.schema.json)
+	// the values set by parameters are validated against. The keys it
+	// requires are left to the defaults of the chart.
+	// +optional
+	ValuesSchema *apiextensionsv1.JSON `json:"valuesSchema,omitempty"`
+
+	// ServiceAccount is the identity the chart is installed with (optional,
+	// defaults to the operator's own ServiceAccount)
+	// +optional
+	ServiceAccount *ScenarioServiceAccount `json:"serviceAccount,omitempty"`
+
+	// Guardrails restrict the resources, network traffic and pod privileges of
+	// the scenario namespace (optional, defaults to the operator guardrails)
+	// +optional
+	Guardrails *ScenarioGuardrails `json:"guardrails,omitempty"`
+
+	// Requires lists the IDs of the ScenarioDefinitions installed as shared
+	// components before this scenario. Components are installed once into the
+	// shared namespace with their default parameters, after their own
+	// requirements, and uninstalled once no installed scenario requires them.
+	// +optional
+	// +listType=set
+	// +kubebuilder:validation:items:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:example={"keycloak","postgresql"}
+	Requires []string `json:"requires,omitempty"`
+
+	// Hooks are Jobs run in the scenario namespace around its installation
+	// and uninstallation
+	// +optional
+	Hooks *ScenarioHooks `json:"hooks,omitempty"`
+
+	// Endpoints are published in the status of the ActiveScenario, on top of
+	// the endpoints discovered in the scenario namespaces
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=20
+	Endpoints []ScenarioEndpoint `json:"endpoints,omitempty"`
+
+	// Credentials are generated for every installation of the scenario,
+	// kept across upgrades and rotated when it is installed again
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=20
+	Credentials []ScenarioCredential `json:"credentials,omitempty"`
+
+	// Steps are the steps of the exercise, done in order
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=50
+	Steps []ScenarioStep `json:"steps,omitempty"`
+
+	// Checks are the graded checks of the exercise, run by ScenarioChecks
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=50
+	Checks []GradedCheck `json:"checks,omitempty"`
+}
+
+// ScenarioDefinitionStatus defines the observed state of ScenarioDefinition
+type ScenarioDefinitionStatus struct {
+	// ObservedGeneration is the generation last processed by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest observations of the definition state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=scndef;sd
+//+kubebuilder:printcolumn:name="Name",type="string",JSONPath=".spec.name"
+//+kubebuilder:printcolumn:name="ID",type="string",JSONPath=".spec.id"
+//+kubebuilder:printcolumn:name="Tags",type="string",JSONPath=".spec.tags"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ScenarioDefinition is the Schema for the scenariodefinitions API
+type ScenarioDefinition struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScenarioDefinitionSpec   `json:"spec,omitempty"`
+	Status ScenarioDefinitionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScenarioDefinitionList contains a list of ScenarioDefinition
+type ScenarioDefinitionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScenarioDefinition `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScenarioDefinition{}, &ScenarioDefinitionList{})
+}
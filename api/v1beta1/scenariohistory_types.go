@@ -0,0 +1,334 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScenarioRelease records the Helm release of an additional chart of a scenario
+type ScenarioRelease struct {
+	// Chart is the name of the chart in the ScenarioDefinition
+	Chart string `json:"chart"`
+
+	// Name is the name of the Helm release
+	Name string `json:"name"`
+
+	// Namespace is the namespace the release is installed in
+	Namespace string `json:"namespace"`
+
+	// SourceRevision is the commit or chart version the release was installed
+	// or last upgraded from
+	// +optional
+	SourceRevision string `json:"sourceRevision,omitempty"`
+
+	// HelmRevision is the current revision of the Helm release
+	// +optional
+	HelmRevision int32 `json:"helmRevision,omitempty"`
+}
+
+// ScenarioHistorySpec defines the desired state of ScenarioHistory
+type ScenarioHistorySpec struct {
+	// ScenarioID is the ID of the installed scenario
+	// +kubebuilder:validation:Required
+	ScenarioID string `json:"scenarioId"`
+
+	// Namespace is the namespace where the scenario is installed
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=63
+	Namespace string `json:"namespace"`
+
+	// HelmRelease is the name of the Helm release
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MaxLength=53
+	HelmRelease string `json:"helmRelease"`
+
+	// InstalledAt is the timestamp when the scenario was installed
+	// +kubebuilder:validation:Required
+	InstalledAt metav1.Time `json:"installedAt"`
+
+	// Values contains the Helm values used for installation
+	// +optional
+	Values string `json:"values,omitempty"`
+
+	// DefinitionGeneration is the generation of the ScenarioDefinition the
+	// scenario was installed or last upgraded from
+	// +optional
+	DefinitionGeneration int64 `json:"definitionGeneration,omitempty"`
+
+	// SourceRevision is the commit or chart version of the chart source the
+	// scenario was installed or last upgraded from
+	// +optional
+	SourceRevision string `json:"sourceRevision,omitempty"`
+
+	// HelmChartVersion is the version of the helm chart used
+	// +optional
+	HelmChartVersion string `json:"helmChartVersion,omitempty"`
+
+	// InstalledBy is the user/entity that triggered the installation
+	// +optional
+	InstalledBy string `json:"installedBy,omitempty"`
+
+	// ServiceAccount is the ServiceAccount the release was installed as, in
+	// namespace/name form (empty when installed as the operator itself)
+	// +optional
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+
+	// Releases are the Helm releases of the additional charts of the
+	// scenario, in installation order
+	// +optional
+	// +listType=map
+	// +listMapKey=chart
+	Releases []ScenarioRelease `json:"releases,omitempty"`
+
+	// ResetGeneration is the reset generation of the ActiveScenario the
+	// scenario was installed for
+	// +optional
+	ResetGeneration int64 `json:"resetGeneration,omitempty"`
+
+	// RestoredFrom is set when the scenario was installed from a
+	// ScenarioSnapshot
+	// +optional
+	RestoredFrom *RestoreSource `json:"restoredFrom,omitempty"`
+
+	// Components are the IDs of the shared components the scenario requires,
+	// in installation order. A component is uninstalled once no active
+	// history lists it.
+	// +optional
+	// +listType=atomic
+	Components []string `json:"components,omitempty"`
+}
+
+// ScenarioHistoryPhase defines the phase of scenario history
+// +kubebuilder:validation:Enum=Active;Terminating;Archived
+type ScenarioHistoryPhase string
+
+const (
+	// ScenarioHistoryPhaseActive means this is the currently active scenario
+	ScenarioHistoryPhaseActive ScenarioHistoryPhase = "Active"
+	// ScenarioHistoryPhaseTerminating means the scenario has been uninstalled
+	// and its namespace is being deleted
+	ScenarioHistoryPhaseTerminating ScenarioHistoryPhase = "Terminating"
+	// ScenarioHistoryPhaseArchived means this scenario has been uninstalled
+	ScenarioHistoryPhaseArchived ScenarioHistoryPhase = "Archived"
+)
+
+// UninstallReason explains why a scenario was uninstalled
+// +kubebuilder:validation:Enum=Replaced;Deleted;Expired;DriftRepair;Failed;ManualOverride;Restored;Reset
+type UninstallReason string
+
+const (
+	// UninstallReasonReplaced means another scenario was activated
+	UninstallReasonReplaced UninstallReason = "Replaced"
+	// UninstallReasonDeleted means the ActiveScenario was deleted
+	UninstallReasonDeleted UninstallReason = "Deleted"
+	// UninstallReasonExpired means the scenario reached the end of its lifetime
+	UninstallReasonExpired UninstallReason = "Expired"
+	// UninstallReasonDriftRepair means the scenario was reinstalled to repair drift
+	UninstallReasonDriftRepair UninstallReason = "DriftRepair"
+	// UninstallReasonFailed means the scenario was removed after failing
+	UninstallReasonFailed UninstallReason = "Failed"
+	// UninstallReasonManualOverride means an administrator forced the uninstallation
+	UninstallReasonManualOverride UninstallReason = "ManualOverride"
+	// UninstallReasonRestored means the scenario was reinstalled from a
+	// ScenarioSnapshot
+	UninstallReasonRestored UninstallReason = "Restored"
+	// UninstallReasonReset means the scenario was reinstalled to start it over
+	UninstallReasonReset UninstallReason = "Reset"
+)
+
+// RevisionTrigger explains why a revision of a scenario was deployed
+// +kubebuilder:validation:Enum=Install;SpecChanged;DefinitionChanged;SourceChanged;Restore
+type RevisionTrigger string
+
+const (
+	// RevisionTriggerInstall is the installation of the scenario
+	RevisionTriggerInstall RevisionTrigger = "Install"
+	// RevisionTriggerSpecChanged is an upgrade for new parameters
+	RevisionTriggerSpecChanged RevisionTrigger = "SpecChanged"
+	// RevisionTriggerDefinitionChanged is an upgrade for a new ScenarioDefinition generation
+	RevisionTriggerDefinitionChanged RevisionTrigger = "DefinitionChanged"
+	// RevisionTriggerSourceChanged is an upgrade for a new commit or version of the chart source
+	RevisionTriggerSourceChanged RevisionTrigger = "SourceChanged"
+	// RevisionTriggerRestore is the installation of the scenario from a
+	// ScenarioSnapshot
+	RevisionTriggerRestore RevisionTrigger = "Restore"
+)
+
+// RestoreSource identifies the restore a scenario was installed by
+type RestoreSource struct {
+	// Restore is the name of the ScenarioRestore
+	Restore string `json:"restore"`
+
+	// Snapshot is the name of the ScenarioSnapshot restored
+	Snapshot string `json:"snapshot"`
+}
+
+// ScenarioRevision records an installation or in-place upgrade of a scenario
+type ScenarioRevision struct {
+	// Revision is the position of the revision in the history, starting at 1
+	Revision int32 `json:"revision"`
+
+	// Trigger is why the revision was deployed
+	Trigger RevisionTrigger `json:"trigger"`
+
+	// DeployedAt is when the revision was deployed
+	DeployedAt metav1.Time `json:"deployedAt"`
+
+	// DefinitionGeneration is the generation of the ScenarioDefinition deployed
+	// +optional
+	DefinitionGeneration int64 `json:"definitionGeneration,omitempty"`
+
+	// SourceRevision is the commit or chart version deployed
+	// +optional
+	SourceRevision string `json:"sourceRevision,omitempty"`
+
+	// HelmRevision is the revision of the Helm release
+	// +optional
+	HelmRevision int32 `json:"helmRevision,omitempty"`
+}
+
+// CheckScore is the score of a graded check
+type CheckScore struct {
+	// Name is the name of the graded check
+	Name string `json:"name"`
+
+	// Passed is whether the check passed
+	Passed bool `json:"passed"`
+
+	// Score is the points awarded for the check
+	Score int32 `json:"score"`
+
+	// MaxScore is the points of the check
+	MaxScore int32 `json:"maxScore"`
+}
+
+// ScenarioScore is the grade of the exercise of a scenario, from its latest
+// ScenarioCheck
+type ScenarioScore struct {
+	// Score is the sum of the points awarded
+	Score int32 `json:"score"`
+
+	// MaxScore is the sum of the points of the checks run
+	MaxScore int32 `json:"maxScore"`
+
+	// Check is the name of the ScenarioCheck graded
+	Check string `json:"check"`
+
+	// GradedAt is when the ScenarioCheck completed
+	GradedAt metav1.Time `json:"gradedAt"`
+
+	// Attempts is the number of ScenarioChecks graded
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// Checks are the scores of each check
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Checks []CheckScore `json:"checks,omitempty"`
+}
+
+// ScenarioHistoryStatus defines the observed state of ScenarioHistory
+type ScenarioHistoryStatus struct {
+	// Phase indicates whether this is the active scenario or archived
+	// +kubebuilder:default=Active
+	// +optional
+	Phase ScenarioHistoryPhase `json:"phase,omitempty"`
+
+	// UninstalledAt is the timestamp when the scenario uninstallation started
+	// +optional
+	UninstalledAt *metav1.Time `json:"uninstalledAt,omitempty"`
+
+	// UninstallReason explains why the scenario was uninstalled
+	// +optional
+	UninstallReason UninstallReason `json:"uninstallReason,omitempty"`
+
+	// Successor is the ID of the scenario that replaced this one
+	// +optional
+	Successor string `json:"successor,omitempty"`
+
+	// UninstalledBy is the user who triggered the uninstallation
+	// +optional
+	UninstalledBy string `json:"uninstalledBy,omitempty"`
+
+	// Message provides additional information about the current status
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Health indicates the health status of the installed scenario
+	// +optional
+	Health string `json:"health,omitempty"`
+
+	// LastHealthCheck is the timestamp of the last health check
+	// +optional
+	LastHealthCheck *metav1.Time `json:"lastHealthCheck,omitempty"`
+
+	// Revisions are the installation and in-place upgrades of the scenario,
+	// oldest first. Only the latest ones are kept.
+	// +optional
+	// +listType=atomic
+	// +kubebuilder:validation:MaxItems=20
+	Revisions []ScenarioRevision `json:"revisions,omitempty"`
+
+	// Score is the grade of the exercise, kept once the scenario is archived
+	// +optional
+	Score *ScenarioScore `json:"score,omitempty"`
+
+	// Conditions represent the latest observations of the installation
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=sh;history
+//+kubebuilder:printcolumn:name="Scenario",type="string",JSONPath=".spec.scenarioId"
+//+kubebuilder:printcolumn:name="Namespace",type="string",JSONPath=".spec.namespace"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Installed",type="date",JSONPath=".spec.installedAt"
+//+kubebuilder:printcolumn:name="Uninstalled",type="date",JSONPath=".status.uninstalledAt"
+//+kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.uninstallReason"
+//+kubebuilder:printcolumn:name="Successor",type="string",JSONPath=".status.successor"
+//+kubebuilder:printcolumn:name="By",type="string",JSONPath=".status.uninstalledBy"
+//+kubebuilder:printcolumn:name="Score",type="integer",JSONPath=".status.score.score"
+
+// ScenarioHistory is the Schema for the scenariohistories API
+type ScenarioHistory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScenarioHistorySpec   `json:"spec,omitempty"`
+	Status ScenarioHistoryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScenarioHistoryList contains a list of ScenarioHistory
+type ScenarioHistoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScenarioHistory `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScenarioHistory{}, &ScenarioHistoryList{})
+}
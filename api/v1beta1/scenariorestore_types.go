@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScenarioRestoreSpec defines the desired state of ScenarioRestore
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type ScenarioRestoreSpec struct {
+	// Snapshot is the name of the ScenarioSnapshot restored
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	Snapshot string `json:"snapshot"`
+
+	// ActiveScenario is the name of the ActiveScenario reinstalled from the
+	// snapshot. It must run the scenario of the snapshot, its parameters are
+	// set back to those captured.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:example="current"
+	ActiveScenario string `json:"activeScenario"`
+}
+
+// ScenarioRestorePhase is the phase of a ScenarioRestore
+// +kubebuilder:validation:Enum=Pending;Restoring;Completed;Failed
+type ScenarioRestorePhase string
+
+const (
+	// ScenarioRestorePhasePending means the restore waits for the
+	// ActiveScenario to pick it up
+	ScenarioRestorePhasePending ScenarioRestorePhase = "Pending"
+	// ScenarioRestorePhaseRestoring means the scenario is being reinstalled
+	ScenarioRestorePhaseRestoring ScenarioRestorePhase = "Restoring"
+	// ScenarioRestorePhaseCompleted means the scenario runs from the snapshot
+	ScenarioRestorePhaseCompleted ScenarioRestorePhase = "Completed"
+	// ScenarioRestorePhaseFailed means the snapshot could not be restored
+	ScenarioRestorePhaseFailed ScenarioRestorePhase = "Failed"
+)
+
+// ScenarioRestoreStatus defines the observed state of ScenarioRestore
+type ScenarioRestoreStatus struct {
+	// Phase is the phase of the ScenarioRestore
+	// +optional
+	Phase ScenarioRestorePhase `json:"phase,omitempty"`
+
+	// History is the name of the ScenarioHistory of the scenario restored
+	// +optional
+	History string `json:"history,omitempty"`
+
+	// StartTime is when the scenario started to be reinstalled
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the restore completed or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Volumes report the data of claims copied back from the volume copies of
+	// the snapshot
+	// +optional
+	// +listType=map
+	// +listMapKey=claim
+	Volumes []RestoredVolumeStatus `json:"volumes,omitempty"`
+
+	// Message provides additional information about the current status
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RestoredVolumeStatus reports the copy of the data of a claim back from the
+// volume it was copied to
+type RestoredVolumeStatus struct {
+	// Claim is the name of the claim restored
+	Claim string `json:"claim"`
+
+	// Job is the Job copying the data back
+	// +optional
+	Job string `json:"job,omitempty"`
+
+	// Restored is set once the data is copied back
+	// +optional
+	Restored bool `json:"restored,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=scnrst
+//+kubebuilder:printcolumn:name="Snapshot",type="string",JSONPath=".spec.snapshot"
+//+kubebuilder:printcolumn:name="Active Scenario",type="string",JSONPath=".spec.activeScenario"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="History",type="string",JSONPath=".status.history"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ScenarioRestore is the Schema for the scenariorestores API. It reinstalls
+// the scenario of an ActiveScenario from a ScenarioSnapshot, archiving the
+// history of the scenario it replaces.
+type ScenarioRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScenarioRestoreSpec   `json:"spec,omitempty"`
+	Status ScenarioRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScenarioRestoreList contains a list of ScenarioRestore
+type ScenarioRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScenarioRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScenarioRestore{}, &ScenarioRestoreList{})
+}
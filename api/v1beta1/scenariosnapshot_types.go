@@ -0,0 +1,224 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VolumeCaptureMethod defines how the data of PersistentVolumeClaims is
+// captured
+// +kubebuilder:validation:Enum=Auto;VolumeSnapshot;Copy
+type VolumeCaptureMethod string
+
+const (
+	// VolumeCaptureMethodAuto takes VolumeSnapshots when the cluster serves
+	// the VolumeSnapshot API, and copies the data otherwise
+	VolumeCaptureMethodAuto VolumeCaptureMethod = "Auto"
+	// VolumeCaptureMethodVolumeSnapshot takes a VolumeSnapshot of every claim,
+	// whose content is retained once the scenario is uninstalled
+	VolumeCaptureMethodVolumeSnapshot VolumeCaptureMethod = "VolumeSnapshot"
+	// VolumeCaptureMethodCopy copies the data of every claim with a Job into
+	// a new claim, whose PersistentVolume is retained once the scenario is
+	// uninstalled
+	VolumeCaptureMethodCopy VolumeCaptureMethod = "Copy"
+)
+
+// ScenarioSnapshotSpec defines the desired state of ScenarioSnapshot
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="spec is immutable"
+type ScenarioSnapshotSpec struct {
+	// ActiveScenario is the name of the ActiveScenario whose running scenario
+	// is captured
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:example="current"
+	ActiveScenario string `json:"activeScenario"`
+
+	// VolumeMethod is how the data of the PersistentVolumeClaims of the
+	// scenario namespace is captured
+	// +optional
+	// +kubebuilder:default=Auto
+	VolumeMethod VolumeCaptureMethod `json:"volumeMethod,omitempty"`
+
+	// VolumeSnapshotClassName is the class of the VolumeSnapshots taken
+	// (optional, defaults to the default class of the cluster)
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// CopyImage is the image of the Jobs copying the data of claims, which
+	// must provide cp
+	// +optional
+	// +kubebuilder:default="busybox:1.36"
+	CopyImage string `json:"copyImage,omitempty"`
+}
+
+// ScenarioSnapshotPhase is the phase of a ScenarioSnapshot
+// +kubebuilder:validation:Enum=Pending;Capturing;Ready;Failed
+type ScenarioSnapshotPhase string
+
+const (
+	// ScenarioSnapshotPhasePending means the capture has not started
+	ScenarioSnapshotPhasePending ScenarioSnapshotPhase = "Pending"
+	// ScenarioSnapshotPhaseCapturing means volumes are being captured
+	ScenarioSnapshotPhaseCapturing ScenarioSnapshotPhase = "Capturing"
+	// ScenarioSnapshotPhaseReady means the snapshot can be restored
+	ScenarioSnapshotPhaseReady ScenarioSnapshotPhase = "Ready"
+	// ScenarioSnapshotPhaseFailed means the snapshot could not be captured
+	ScenarioSnapshotPhaseFailed ScenarioSnapshotPhase = "Failed"
+)
+
+// VolumeSnapshotStatus reports the capture of a PersistentVolumeClaim
+type VolumeSnapshotStatus struct {
+	// Claim is the name of the PersistentVolumeClaim captured
+	Claim string `json:"claim"`
+
+	// Method is how the data of the claim is captured
+	Method VolumeCaptureMethod `json:"method"`
+
+	// Ready is whether the data of the claim is captured
+	// +optional
+	Ready bool `json:"ready,omitempty"`
+
+	// Size is the size of the data captured
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// VolumeSnapshot is the VolumeSnapshot taken of the claim
+	// +optional
+	VolumeSnapshot string `json:"volumeSnapshot,omitempty"`
+
+	// VolumeSnapshotContent is the retained content of the VolumeSnapshot
+	// +optional
+	VolumeSnapshotContent string `json:"volumeSnapshotContent,omitempty"`
+
+	// VolumeSnapshotClassName is the class of the VolumeSnapshot
+	// +optional
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// Driver is the CSI driver of the VolumeSnapshot
+	// +optional
+	Driver string `json:"driver,omitempty"`
+
+	// SnapshotHandle identifies the VolumeSnapshot in the storage system
+	// +optional
+	SnapshotHandle string `json:"snapshotHandle,omitempty"`
+
+	// PersistentVolume is the retained volume the data was copied to
+	// +optional
+	PersistentVolume string `json:"persistentVolume,omitempty"`
+
+	// Job is the Job copying the data of the claim
+	// +optional
+	Job string `json:"job,omitempty"`
+}
+
+// ScenarioSnapshotStatus defines the observed state of ScenarioSnapshot
+type ScenarioSnapshotStatus struct {
+	// Phase is the phase of the ScenarioSnapshot
+	// +optional
+	Phase ScenarioSnapshotPhase `json:"phase,omitempty"`
+
+	// ScenarioID is the ID of the scenario captured
+	// +optional
+	ScenarioID string `json:"scenarioId,omitempty"`
+
+	// History is the name of the ScenarioHistory of the scenario captured
+	// +optional
+	History string `json:"history,omitempty"`
+
+	// Namespace is the scenario namespace captured
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// HelmRelease is the Helm release of the scenario captured
+	// +optional
+	HelmRelease string `json:"helmRelease,omitempty"`
+
+	// Parameters are the parameters of the ActiveScenario captured, set back
+	// on the ActiveScenario restored
+	// +optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// Values are the Helm values of the scenario captured
+	// +optional
+	Values string `json:"values,omitempty"`
+
+	// Data is the Secret holding the ConfigMaps, Secrets and
+	// PersistentVolumeClaims captured
+	// +optional
+	Data *corev1.SecretReference `json:"data,omitempty"`
+
+	// ConfigMaps are the names of the ConfigMaps captured
+	// +optional
+	// +listType=set
+	ConfigMaps []string `json:"configMaps,omitempty"`
+
+	// Secrets are the names of the Secrets captured
+	// +optional
+	// +listType=set
+	Secrets []string `json:"secrets,omitempty"`
+
+	// Volumes report the capture of the PersistentVolumeClaims
+	// +optional
+	// +listType=map
+	// +listMapKey=claim
+	Volumes []VolumeSnapshotStatus `json:"volumes,omitempty"`
+
+	// CapturedAt is when the capture completed
+	// +optional
+	CapturedAt *metav1.Time `json:"capturedAt,omitempty"`
+
+	// Message provides additional information about the current status
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:resource:scope=Cluster,shortName=scnsnap
+//+kubebuilder:printcolumn:name="Active Scenario",type="string",JSONPath=".spec.activeScenario"
+//+kubebuilder:printcolumn:name="Scenario",type="string",JSONPath=".status.scenarioId"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Captured",type="date",JSONPath=".status.capturedAt"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ScenarioSnapshot is the Schema for the scenariosnapshots API. It captures
+// the state of a running scenario, which ScenarioRestores reinstall the
+// scenario from.
+type ScenarioSnapshot struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScenarioSnapshotSpec   `json:"spec,omitempty"`
+	Status ScenarioSnapshotStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScenarioSnapshotList contains a list of ScenarioSnapshot
+type ScenarioSnapshotList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScenarioSnapshot `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScenarioSnapshot{}, &ScenarioSnapshotList{})
+}
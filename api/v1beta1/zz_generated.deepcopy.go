@@ -0,0 +1,1594 @@
+//go:build !ignore_autogenerated
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveScenario) DeepCopyInto(out *ActiveScenario) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveScenario.
+func (in *ActiveScenario) DeepCopy() *ActiveScenario {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveScenario)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ActiveScenario) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveScenarioList) DeepCopyInto(out *ActiveScenarioList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ActiveScenario, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveScenarioList.
+func (in *ActiveScenarioList) DeepCopy() *ActiveScenarioList {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveScenarioList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ActiveScenarioList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveScenarioSpec) DeepCopyInto(out *ActiveScenarioSpec) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.UpdatePolicy != nil {
+		in, out := &in.UpdatePolicy, &out.UpdatePolicy
+		*out = new(UpdatePolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CompletedSteps != nil {
+		in, out := &in.CompletedSteps, &out.CompletedSteps
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveScenarioSpec.
+func (in *ActiveScenarioSpec) DeepCopy() *ActiveScenarioSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveScenarioSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ActiveScenarioStatus) DeepCopyInto(out *ActiveScenarioStatus) {
+	*out = *in
+	if in.LastSourceCheck != nil {
+		in, out := &in.LastSourceCheck, &out.LastSourceCheck
+		*out = (*in).DeepCopy()
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]EndpointStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.CredentialsSecret != nil {
+		in, out := &in.CredentialsSecret, &out.CredentialsSecret
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]StepStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.HookJob != nil {
+		in, out := &in.HookJob, &out.HookJob
+		*out = new(HookJobStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ActiveScenarioStatus.
+func (in *ActiveScenarioStatus) DeepCopy() *ActiveScenarioStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ActiveScenarioStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CatalogEntryStatus) DeepCopyInto(out *CatalogEntryStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CatalogEntryStatus.
+func (in *CatalogEntryStatus) DeepCopy() *CatalogEntryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CatalogEntryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CatalogGitSource) DeepCopyInto(out *CatalogGitSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CatalogGitSource.
+func (in *CatalogGitSource) DeepCopy() *CatalogGitSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CatalogGitSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartSource) DeepCopyInto(out *ChartSource) {
+	*out = *in
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitChartSource)
+		**out = **in
+	}
+	if in.Repository != nil {
+		in, out := &in.Repository, &out.Repository
+		*out = new(RepositoryChartSource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartSource.
+func (in *ChartSource) DeepCopy() *ChartSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CheckResult) DeepCopyInto(out *CheckResult) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletedAt != nil {
+		in, out := &in.CompletedAt, &out.CompletedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CheckResult.
+func (in *CheckResult) DeepCopy() *CheckResult {
+	if in == nil {
+		return nil
+	}
+	out := new(CheckResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CheckScore) DeepCopyInto(out *CheckScore) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CheckScore.
+func (in *CheckScore) DeepCopy() *CheckScore {
+	if in == nil {
+		return nil
+	}
+	out := new(CheckScore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EndpointStatus) DeepCopyInto(out *EndpointStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EndpointStatus.
+func (in *EndpointStatus) DeepCopy() *EndpointStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(EndpointStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitChartSource) DeepCopyInto(out *GitChartSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitChartSource.
+func (in *GitChartSource) DeepCopy() *GitChartSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GitChartSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GradedCheck) DeepCopyInto(out *GradedCheck) {
+	*out = *in
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPVerification)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Job != nil {
+		in, out := &in.Job, &out.Job
+		*out = new(batchv1.JobTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GradedCheck.
+func (in *GradedCheck) DeepCopy() *GradedCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(GradedCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPVerification) DeepCopyInto(out *HTTPVerification) {
+	*out = *in
+	if in.HTTPHeaders != nil {
+		in, out := &in.HTTPHeaders, &out.HTTPHeaders
+		*out = make([]corev1.HTTPHeader, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExpectedStatus != nil {
+		in, out := &in.ExpectedStatus, &out.ExpectedStatus
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPVerification.
+func (in *HTTPVerification) DeepCopy() *HTTPVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HookJobStatus) DeepCopyInto(out *HookJobStatus) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HookJobStatus.
+func (in *HookJobStatus) DeepCopy() *HookJobStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HookJobStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReadinessGate) DeepCopyInto(out *ReadinessGate) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReadinessGate.
+func (in *ReadinessGate) DeepCopy() *ReadinessGate {
+	if in == nil {
+		return nil
+	}
+	out := new(ReadinessGate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RepositoryChartSource) DeepCopyInto(out *RepositoryChartSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RepositoryChartSource.
+func (in *RepositoryChartSource) DeepCopy() *RepositoryChartSource {
+	if in == nil {
+		return nil
+	}
+	out := new(RepositoryChartSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoreSource) DeepCopyInto(out *RestoreSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreSource.
+func (in *RestoreSource) DeepCopy() *RestoreSource {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoreSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoredVolumeStatus) DeepCopyInto(out *RestoredVolumeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoredVolumeStatus.
+func (in *RestoredVolumeStatus) DeepCopy() *RestoredVolumeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoredVolumeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCatalog) DeepCopyInto(out *ScenarioCatalog) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCatalog.
+func (in *ScenarioCatalog) DeepCopy() *ScenarioCatalog {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCatalog)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioCatalog) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCatalogList) DeepCopyInto(out *ScenarioCatalogList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScenarioCatalog, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCatalogList.
+func (in *ScenarioCatalogList) DeepCopy() *ScenarioCatalogList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCatalogList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioCatalogList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCatalogSpec) DeepCopyInto(out *ScenarioCatalogSpec) {
+	*out = *in
+	out.Git = in.Git
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Prune != nil {
+		in, out := &in.Prune, &out.Prune
+		*out = new(bool)
+		**out = **in
+	}
+	if in.TrustedFields != nil {
+		in, out := &in.TrustedFields, &out.TrustedFields
+		*out = make([]CatalogTrustedField, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCatalogSpec.
+func (in *ScenarioCatalogSpec) DeepCopy() *ScenarioCatalogSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCatalogSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCatalogStatus) DeepCopyInto(out *ScenarioCatalogStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Entries != nil {
+		in, out := &in.Entries, &out.Entries
+		*out = make([]CatalogEntryStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCatalogStatus.
+func (in *ScenarioCatalogStatus) DeepCopy() *ScenarioCatalogStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCatalogStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioChart) DeepCopyInto(out *ScenarioChart) {
+	*out = *in
+	in.Chart.DeepCopyInto(&out.Chart)
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Readiness != nil {
+		in, out := &in.Readiness, &out.Readiness
+		*out = new(ReadinessGate)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioChart.
+func (in *ScenarioChart) DeepCopy() *ScenarioChart {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioChart)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCheck) DeepCopyInto(out *ScenarioCheck) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCheck.
+func (in *ScenarioCheck) DeepCopy() *ScenarioCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioCheck) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCheckList) DeepCopyInto(out *ScenarioCheckList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScenarioCheck, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCheckList.
+func (in *ScenarioCheckList) DeepCopy() *ScenarioCheckList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCheckList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioCheckList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCheckSpec) DeepCopyInto(out *ScenarioCheckSpec) {
+	*out = *in
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCheckSpec.
+func (in *ScenarioCheckSpec) DeepCopy() *ScenarioCheckSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCheckSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCheckStatus) DeepCopyInto(out *ScenarioCheckStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Results != nil {
+		in, out := &in.Results, &out.Results
+		*out = make([]CheckResult, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCheckStatus.
+func (in *ScenarioCheckStatus) DeepCopy() *ScenarioCheckStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCheckStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioCredential) DeepCopyInto(out *ScenarioCredential) {
+	*out = *in
+	if in.Length != nil {
+		in, out := &in.Length, &out.Length
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Bits != nil {
+		in, out := &in.Bits, &out.Bits
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioCredential.
+func (in *ScenarioCredential) DeepCopy() *ScenarioCredential {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioCredential)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioDefinition) DeepCopyInto(out *ScenarioDefinition) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioDefinition.
+func (in *ScenarioDefinition) DeepCopy() *ScenarioDefinition {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioDefinition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioDefinition) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioDefinitionList) DeepCopyInto(out *ScenarioDefinitionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScenarioDefinition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioDefinitionList.
+func (in *ScenarioDefinitionList) DeepCopy() *ScenarioDefinitionList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioDefinitionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioDefinitionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioDefinitionSpec) DeepCopyInto(out *ScenarioDefinitionSpec) {
+	*out = *in
+	in.Chart.DeepCopyInto(&out.Chart)
+	if in.Charts != nil {
+		in, out := &in.Charts, &out.Charts
+		*out = make([]ScenarioChart, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Tags != nil {
+		in, out := &in.Tags, &out.Tags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Features != nil {
+		in, out := &in.Features, &out.Features
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make([]ScenarioParameter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ValuesSchema != nil {
+		in, out := &in.ValuesSchema, &out.ValuesSchema
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ServiceAccount != nil {
+		in, out := &in.ServiceAccount, &out.ServiceAccount
+		*out = new(ScenarioServiceAccount)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Guardrails != nil {
+		in, out := &in.Guardrails, &out.Guardrails
+		*out = new(ScenarioGuardrails)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Requires != nil {
+		in, out := &in.Requires, &out.Requires
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Hooks != nil {
+		in, out := &in.Hooks, &out.Hooks
+		*out = new(ScenarioHooks)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]ScenarioEndpoint, len(*in))
+		copy(*out, *in)
+	}
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = make([]ScenarioCredential, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]ScenarioStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]GradedCheck, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioDefinitionSpec.
+func (in *ScenarioDefinitionSpec) DeepCopy() *ScenarioDefinitionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioDefinitionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioDefinitionStatus) DeepCopyInto(out *ScenarioDefinitionStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioDefinitionStatus.
+func (in *ScenarioDefinitionStatus) DeepCopy() *ScenarioDefinitionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioDefinitionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioEndpoint) DeepCopyInto(out *ScenarioEndpoint) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioEndpoint.
+func (in *ScenarioEndpoint) DeepCopy() *ScenarioEndpoint {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioEndpoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioGuardrails) DeepCopyInto(out *ScenarioGuardrails) {
+	*out = *in
+	if in.Quota != nil {
+		in, out := &in.Quota, &out.Quota
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.DefaultRequests != nil {
+		in, out := &in.DefaultRequests, &out.DefaultRequests
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.DefaultLimits != nil {
+		in, out := &in.DefaultLimits, &out.DefaultLimits
+		*out = make(corev1.ResourceList, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = make([]networkingv1.NetworkPolicyIngressRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Egress != nil {
+		in, out := &in.Egress, &out.Egress
+		*out = make([]networkingv1.NetworkPolicyEgressRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioGuardrails.
+func (in *ScenarioGuardrails) DeepCopy() *ScenarioGuardrails {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioGuardrails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioHistory) DeepCopyInto(out *ScenarioHistory) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioHistory.
+func (in *ScenarioHistory) DeepCopy() *ScenarioHistory {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioHistory)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioHistory) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioHistoryList) DeepCopyInto(out *ScenarioHistoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScenarioHistory, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioHistoryList.
+func (in *ScenarioHistoryList) DeepCopy() *ScenarioHistoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioHistoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioHistoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioHistorySpec) DeepCopyInto(out *ScenarioHistorySpec) {
+	*out = *in
+	in.InstalledAt.DeepCopyInto(&out.InstalledAt)
+	if in.Releases != nil {
+		in, out := &in.Releases, &out.Releases
+		*out = make([]ScenarioRelease, len(*in))
+		copy(*out, *in)
+	}
+	if in.RestoredFrom != nil {
+		in, out := &in.RestoredFrom, &out.RestoredFrom
+		*out = new(RestoreSource)
+		**out = **in
+	}
+	if in.Components != nil {
+		in, out := &in.Components, &out.Components
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioHistorySpec.
+func (in *ScenarioHistorySpec) DeepCopy() *ScenarioHistorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioHistorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioHistoryStatus) DeepCopyInto(out *ScenarioHistoryStatus) {
+	*out = *in
+	if in.UninstalledAt != nil {
+		in, out := &in.UninstalledAt, &out.UninstalledAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LastHealthCheck != nil {
+		in, out := &in.LastHealthCheck, &out.LastHealthCheck
+		*out = (*in).DeepCopy()
+	}
+	if in.Revisions != nil {
+		in, out := &in.Revisions, &out.Revisions
+		*out = make([]ScenarioRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Score != nil {
+		in, out := &in.Score, &out.Score
+		*out = new(ScenarioScore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioHistoryStatus.
+func (in *ScenarioHistoryStatus) DeepCopy() *ScenarioHistoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioHistoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioHook) DeepCopyInto(out *ScenarioHook) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioHook.
+func (in *ScenarioHook) DeepCopy() *ScenarioHook {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioHook)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioHooks) DeepCopyInto(out *ScenarioHooks) {
+	*out = *in
+	if in.PreInstall != nil {
+		in, out := &in.PreInstall, &out.PreInstall
+		*out = new(ScenarioHook)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PostInstall != nil {
+		in, out := &in.PostInstall, &out.PostInstall
+		*out = new(ScenarioHook)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PreUninstall != nil {
+		in, out := &in.PreUninstall, &out.PreUninstall
+		*out = new(ScenarioHook)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PostUninstall != nil {
+		in, out := &in.PostUninstall, &out.PostUninstall
+		*out = new(ScenarioHook)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioHooks.
+func (in *ScenarioHooks) DeepCopy() *ScenarioHooks {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioHooks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioParameter) DeepCopyInto(out *ScenarioParameter) {
+	*out = *in
+	if in.Default != nil {
+		in, out := &in.Default, &out.Default
+		*out = new(string)
+		**out = **in
+	}
+	if in.Enum != nil {
+		in, out := &in.Enum, &out.Enum
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioParameter.
+func (in *ScenarioParameter) DeepCopy() *ScenarioParameter {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioParameter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioRelease) DeepCopyInto(out *ScenarioRelease) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioRelease.
+func (in *ScenarioRelease) DeepCopy() *ScenarioRelease {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioRelease)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioRestore) DeepCopyInto(out *ScenarioRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioRestore.
+func (in *ScenarioRestore) DeepCopy() *ScenarioRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioRestoreList) DeepCopyInto(out *ScenarioRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScenarioRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioRestoreList.
+func (in *ScenarioRestoreList) DeepCopy() *ScenarioRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioRestoreSpec) DeepCopyInto(out *ScenarioRestoreSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioRestoreSpec.
+func (in *ScenarioRestoreSpec) DeepCopy() *ScenarioRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioRestoreStatus) DeepCopyInto(out *ScenarioRestoreStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]RestoredVolumeStatus, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioRestoreStatus.
+func (in *ScenarioRestoreStatus) DeepCopy() *ScenarioRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioRevision) DeepCopyInto(out *ScenarioRevision) {
+	*out = *in
+	in.DeployedAt.DeepCopyInto(&out.DeployedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioRevision.
+func (in *ScenarioRevision) DeepCopy() *ScenarioRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioScore) DeepCopyInto(out *ScenarioScore) {
+	*out = *in
+	in.GradedAt.DeepCopyInto(&out.GradedAt)
+	if in.Checks != nil {
+		in, out := &in.Checks, &out.Checks
+		*out = make([]CheckScore, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioScore.
+func (in *ScenarioScore) DeepCopy() *ScenarioScore {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioScore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioServiceAccount) DeepCopyInto(out *ScenarioServiceAccount) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]rbacv1.PolicyRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioServiceAccount.
+func (in *ScenarioServiceAccount) DeepCopy() *ScenarioServiceAccount {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioServiceAccount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioSnapshot) DeepCopyInto(out *ScenarioSnapshot) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioSnapshot.
+func (in *ScenarioSnapshot) DeepCopy() *ScenarioSnapshot {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioSnapshot)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioSnapshot) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioSnapshotList) DeepCopyInto(out *ScenarioSnapshotList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScenarioSnapshot, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioSnapshotList.
+func (in *ScenarioSnapshotList) DeepCopy() *ScenarioSnapshotList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioSnapshotList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScenarioSnapshotList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioSnapshotSpec) DeepCopyInto(out *ScenarioSnapshotSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioSnapshotSpec.
+func (in *ScenarioSnapshotSpec) DeepCopy() *ScenarioSnapshotSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioSnapshotSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioSnapshotStatus) DeepCopyInto(out *ScenarioSnapshotStatus) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = new(corev1.SecretReference)
+		**out = **in
+	}
+	if in.ConfigMaps != nil {
+		in, out := &in.ConfigMaps, &out.ConfigMaps
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Volumes != nil {
+		in, out := &in.Volumes, &out.Volumes
+		*out = make([]VolumeSnapshotStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.CapturedAt != nil {
+		in, out := &in.CapturedAt, &out.CapturedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioSnapshotStatus.
+func (in *ScenarioSnapshotStatus) DeepCopy() *ScenarioSnapshotStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioSnapshotStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScenarioStep) DeepCopyInto(out *ScenarioStep) {
+	*out = *in
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(StepVerification)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScenarioStep.
+func (in *ScenarioStep) DeepCopy() *ScenarioStep {
+	if in == nil {
+		return nil
+	}
+	out := new(ScenarioStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepStatus) DeepCopyInto(out *StepStatus) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletedAt != nil {
+		in, out := &in.CompletedAt, &out.CompletedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LastVerification != nil {
+		in, out := &in.LastVerification, &out.LastVerification
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepStatus.
+func (in *StepStatus) DeepCopy() *StepStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(StepStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepVerification) DeepCopyInto(out *StepVerification) {
+	*out = *in
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPVerification)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Job != nil {
+		in, out := &in.Job, &out.Job
+		*out = new(batchv1.JobTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepVerification.
+func (in *StepVerification) DeepCopy() *StepVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(StepVerification)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpdatePolicy) DeepCopyInto(out *UpdatePolicy) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.Window != nil {
+		in, out := &in.Window, &out.Window
+		*out = new(UpdateWindow)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpdatePolicy.
+func (in *UpdatePolicy) DeepCopy() *UpdatePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(UpdatePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpdateWindow) DeepCopyInto(out *UpdateWindow) {
+	*out = *in
+	out.Duration = in.Duration
+	if in.Days != nil {
+		in, out := &in.Days, &out.Days
+		*out = make([]Weekday, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpdateWindow.
+func (in *UpdateWindow) DeepCopy() *UpdateWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(UpdateWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VolumeSnapshotStatus) DeepCopyInto(out *VolumeSnapshotStatus) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VolumeSnapshotStatus.
+func (in *VolumeSnapshotStatus) DeepCopy() *VolumeSnapshotStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VolumeSnapshotStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -17,24 +17,39 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
+	// Embed the time zone database for the update windows of scenarios
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	devopsbeererv1alpha1 "github.com/devopsbeerer/operator/api/v1alpha1"
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
 	"github.com/devopsbeerer/operator/controllers"
+	"github.com/devopsbeerer/operator/internal/guardrails"
 	"github.com/devopsbeerer/operator/internal/helm"
+	"github.com/devopsbeerer/operator/internal/naming"
+	"github.com/devopsbeerer/operator/internal/retention"
+	"github.com/devopsbeerer/operator/internal/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -47,6 +62,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(devopsbeererv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(devopsbeererv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -54,11 +70,97 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var webhookPort int
+	var webhookCertDir string
+	var requireServiceAccount bool
+	var autoUpgrade bool
+	var sourcePollInterval time.Duration
+	var sharedNamespace string
+	var allowedServiceAccounts string
+	var scenarioClusterRole, serviceAccount string
+	var snapshotNamespace string
+	var nodeAddress string
+	var tracingOpts tracing.Options
+	var namespaceTemplate, releaseTemplate string
+	var namespaceDeletionTimeout time.Duration
+	var defaultQuota, maxQuota, defaultRequests, defaultLimits, podSecurityLevel string
+	var historyRetention retention.Policy
+	var historyArchiveConfigMap, historyArchiveFile string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook server serves at.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "",
+		"The directory that contains the webhook server key and certificate. "+
+			"Defaults to <temp-dir>/k8s-webhook-server/serving-certs.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&requireServiceAccount, "least-privilege", false,
+		"Refuse to install scenarios whose ScenarioDefinition does not name a ServiceAccount, "+
+			"so that charts are never installed with the rights of the operator.")
+	flag.BoolVar(&autoUpgrade, "auto-upgrade", false,
+		"Make Auto rather than Manual the update policy of ActiveScenarios setting none, upgrading them in place "+
+			"when their ScenarioDefinition or chart source changes.")
+	flag.DurationVar(&sourcePollInterval, "source-poll-interval", 10*time.Minute,
+		"How often the chart source of running scenarios with an Auto or Window update policy is checked "+
+			"for a new commit or version, unless their policy sets an interval.")
+	flag.StringVar(&sharedNamespace, "shared-namespace", "devopsbeerer-shared",
+		"The namespace the shared components required by scenarios are installed into.")
+	flag.StringVar(&allowedServiceAccounts, "allowed-service-accounts", "",
+		"The existing ServiceAccounts outside the shared namespace ScenarioDefinitions may install their chart as, "+
+			"as namespace/name pairs such as ci/deployer,tools/installer.")
+	flag.StringVar(&scenarioClusterRole, "scenario-cluster-role", "",
+		"The ClusterRole granting the operator its rights inside scenario namespaces, such as running Jobs, "+
+			"bound to --service-account in each of them. Without it the operator needs these rights cluster-wide.")
+	flag.StringVar(&serviceAccount, "service-account", "",
+		"The ServiceAccount the operator runs as, as namespace/name, bound to --scenario-cluster-role. "+
+			"Its changes to ActiveScenarios keep the user recorded as their requester.")
+	flag.StringVar(&snapshotNamespace, "snapshot-namespace", "devopsbeerer-snapshots",
+		"The namespace the objects and volume copies captured by ScenarioSnapshots are kept in.")
+	flag.StringVar(&nodeAddress, "node-address", "",
+		"The address NodePort Services of scenarios are published at in ActiveScenario endpoints. "+
+			"Defaults to the external, or else internal, address of a cluster node.")
+	flag.StringVar(&namespaceTemplate, "namespace-template", naming.DefaultNamespaceTemplate,
+		"The Go template naming scenario namespaces, with the .ScenarioID, .Instance (ActiveScenario name) "+
+			"and .Owner (devopsbeerer.io/owner label) variables. Names over 63 characters are truncated with a hash suffix.")
+	flag.StringVar(&releaseTemplate, "release-template", naming.DefaultReleaseTemplate,
+		"The Go template naming scenario Helm releases, with the same variables as --namespace-template. "+
+			"Names over 53 characters are truncated with a hash suffix.")
+	flag.DurationVar(&namespaceDeletionTimeout, "namespace-deletion-timeout", 10*time.Minute,
+		"How long a scenario namespace may take to terminate before the resources and finalizers "+
+			"blocking its deletion are reported.")
+	flag.StringVar(&defaultQuota, "namespace-default-quota", "",
+		"The ResourceQuota of scenario namespaces for resources their ScenarioDefinition sets no quota for, "+
+			"as resource=quantity pairs such as requests.cpu=2,requests.memory=4Gi,pods=20.")
+	flag.StringVar(&maxQuota, "namespace-max-quota", "",
+		"The cap on the ResourceQuota of every scenario namespace, as resource=quantity pairs.")
+	flag.StringVar(&defaultRequests, "container-default-requests", "",
+		"The container requests set by the LimitRange of scenario namespaces, as resource=quantity pairs.")
+	flag.StringVar(&defaultLimits, "container-default-limits", "",
+		"The container limits set by the LimitRange of scenario namespaces, as resource=quantity pairs.")
+	flag.StringVar(&podSecurityLevel, "pod-security-level", string(devopsbeererv1beta1.PodSecurityLevelBaseline),
+		"The minimum Pod Security Standards level enforced in scenario namespaces, used when their "+
+			"ScenarioDefinition sets none.")
+	flag.IntVar(&historyRetention.MaxPerScenario, "history-max-per-scenario", 10,
+		"The number of archived ScenarioHistory entries kept per scenario, besides the failures kept "+
+			"by --history-keep-failures. 0 keeps them all.")
+	flag.DurationVar(&historyRetention.MaxAge, "history-max-age", 0,
+		"How long archived ScenarioHistory entries are kept after their uninstallation. 0 keeps them forever.")
+	flag.IntVar(&historyRetention.KeepFailures, "history-keep-failures", 3,
+		"The number of most recent failed ScenarioHistory entries kept per scenario whatever their age.")
+	flag.StringVar(&historyArchiveConfigMap, "history-archive-configmap", "",
+		"The ConfigMap, as namespace/name, pruned ScenarioHistory entries are appended to as JSON Lines. "+
+			"The oldest entries are dropped when it nears the 1MiB object limit.")
+	flag.StringVar(&historyArchiveFile, "history-archive-file", "",
+		"The file, typically on a PersistentVolume, pruned ScenarioHistory entries are appended to as JSON Lines.")
+	flag.StringVar(&tracingOpts.Endpoint, "otlp-endpoint", "",
+		"The OTLP/HTTP collector endpoint (host:port) traces are exported to. Tracing is disabled when empty.")
+	flag.BoolVar(&tracingOpts.Insecure, "otlp-insecure", false,
+		"Export traces without TLS.")
+	flag.Float64Var(&tracingOpts.SampleRatio, "trace-sample-ratio", 1.0,
+		"The fraction of reconciliations that are traced.")
+	flag.StringVar(&tracingOpts.ServiceName, "trace-service-name", "devopsbeerer-operator",
+		"The service name traces are reported under.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -67,23 +169,73 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingOpts)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
+	namingTemplates, err := naming.New(namespaceTemplate, releaseTemplate)
+	if err != nil {
+		setupLog.Error(err, "invalid naming template")
+		os.Exit(1)
+	}
+
+	guardrailOpts := guardrails.Options{PodSecurity: devopsbeererv1beta1.PodSecurityLevel(podSecurityLevel)}
+	switch guardrailOpts.PodSecurity {
+	case devopsbeererv1beta1.PodSecurityLevelPrivileged, devopsbeererv1beta1.PodSecurityLevelBaseline,
+		devopsbeererv1beta1.PodSecurityLevelRestricted:
+	default:
+		setupLog.Error(fmt.Errorf("unknown level %q", podSecurityLevel), "invalid flag", "flag", "pod-security-level")
+		os.Exit(1)
+	}
+	for _, list := range []struct {
+		flag  string
+		value string
+		dst   *corev1.ResourceList
+	}{
+		{"namespace-default-quota", defaultQuota, &guardrailOpts.DefaultQuota},
+		{"namespace-max-quota", maxQuota, &guardrailOpts.MaxQuota},
+		{"container-default-requests", defaultRequests, &guardrailOpts.DefaultRequests},
+		{"container-default-limits", defaultLimits, &guardrailOpts.DefaultLimits},
+	} {
+		if *list.dst, err = guardrails.ParseResourceList(list.value); err != nil {
+			setupLog.Error(err, "invalid flag", "flag", list.flag)
+			os.Exit(1)
+		}
+	}
+
+	var historyArchiver retention.Archiver
+	switch {
+	case historyArchiveConfigMap != "" && historyArchiveFile != "":
+		setupLog.Error(fmt.Errorf("--history-archive-configmap and --history-archive-file are exclusive"), "invalid flags")
+		os.Exit(1)
+	case historyArchiveFile != "":
+		historyArchiver = &retention.FileArchiver{Path: historyArchiveFile}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "devopsbeerer-operator-lock",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
+		// The history archive is the only ConfigMap the operator reads, and
+		// the credentials of scenarios the only Secrets, there is no point in
+		// caching every ConfigMap and Secret of the cluster for them
+		Client: client.Options{
+			Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}}},
+		},
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    webhookPort,
+			CertDir: webhookCertDir,
+		}),
+		LeaderElection:   enableLeaderElection,
+		LeaderElectionID: "devopsbeerer-operator-lock",
+		// Step down as soon as the manager stops so that a standby replica
+		// takes over without waiting for the lease to expire. The process only
+		// flushes traces after the manager stops, which is safe without the lease.
+		// Helm operations interrupted by the shutdown are recovered by the new
+		// leader from the pending state of their release.
+		LeaderElectionReleaseOnCancel: true,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -98,14 +250,116 @@ func main() {
 		helmClient = nil
 	}
 
+	var allowedServiceAccountList []string
+	for _, serviceAccount := range strings.Split(allowedServiceAccounts, ",") {
+		if serviceAccount = strings.TrimSpace(serviceAccount); serviceAccount == "" {
+			continue
+		}
+		namespace, name, ok := strings.Cut(serviceAccount, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", serviceAccount),
+				"invalid flag", "flag", "allowed-service-accounts")
+			os.Exit(1)
+		}
+		allowedServiceAccountList = append(allowedServiceAccountList, serviceAccount)
+	}
+	var operatorUser string
+	if scenarioClusterRole != "" || serviceAccount != "" {
+		namespace, name, ok := strings.Cut(serviceAccount, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", serviceAccount),
+				"invalid flag", "flag", "service-account")
+			os.Exit(1)
+		}
+		operatorUser = fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+	}
 	if err = (&controllers.ActiveScenarioReconciler{
 		Client:     mgr.GetClient(),
 		Scheme:     mgr.GetScheme(),
 		HelmClient: helmClient,
+		Recorder:   mgr.GetEventRecorderFor("activescenario-controller"),
+		Config:     mgr.GetConfig(),
+
+		NamespaceDeletionTimeout: namespaceDeletionTimeout,
+		Naming:                   namingTemplates,
+		Guardrails:               guardrailOpts,
+		RequireServiceAccount:    requireServiceAccount,
+		AutoUpgrade:              autoUpgrade,
+		SourcePollInterval:       sourcePollInterval,
+		SharedNamespace:          sharedNamespace,
+		AllowedServiceAccounts:   allowedServiceAccountList,
+		NodeAddress:              nodeAddress,
+		ScenarioClusterRole:      scenarioClusterRole,
+		ServiceAccount:           serviceAccount,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ActiveScenario")
 		os.Exit(1)
 	}
+	if historyArchiveConfigMap != "" {
+		namespace, name, ok := strings.Cut(historyArchiveConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", historyArchiveConfigMap),
+				"invalid flag", "flag", "history-archive-configmap")
+			os.Exit(1)
+		}
+		historyArchiver = &retention.ConfigMapArchiver{Client: mgr.GetClient(), Namespace: namespace, Name: name}
+	}
+	if err = (&controllers.ScenarioHistoryReconciler{
+		Client:    mgr.GetClient(),
+		Recorder:  mgr.GetEventRecorderFor("scenariohistory-controller"),
+		Retention: historyRetention,
+		Archiver:  historyArchiver,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ScenarioHistory")
+		os.Exit(1)
+	}
+	if err = (&controllers.ScenarioCatalogReconciler{
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		HelmClient: helmClient,
+		Recorder:   mgr.GetEventRecorderFor("scenariocatalog-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ScenarioCatalog")
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create clientset")
+		os.Exit(1)
+	}
+	if err = (&controllers.ScenarioCheckReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Clientset: clientset,
+		Recorder:  mgr.GetEventRecorderFor("scenariocheck-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ScenarioCheck")
+		os.Exit(1)
+	}
+	if err = (&controllers.ScenarioSnapshotReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		APIReader: mgr.GetAPIReader(),
+		Recorder:  mgr.GetEventRecorderFor("scenariosnapshot-controller"),
+		Namespace: snapshotNamespace,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ScenarioSnapshot")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&devopsbeererv1beta1.ActiveScenario{}).SetupWebhookWithManager(mgr, operatorUser); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ActiveScenario")
+			os.Exit(1)
+		}
+		if err = (&devopsbeererv1beta1.ScenarioDefinition{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ScenarioDefinition")
+			os.Exit(1)
+		}
+		if err = (&devopsbeererv1beta1.ScenarioHistory{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ScenarioHistory")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -123,4 +377,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := shutdownTracing(context.Background()); err != nil {
+		setupLog.Error(err, "unable to flush traces")
+	}
+
 }
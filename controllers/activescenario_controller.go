@@ -18,22 +18,41 @@ package controllers
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"maps"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
-	devopsbeererv1alpha1 "github.com/devopsbeerer/operator/api/v1alpha1"
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/credentials"
+	"github.com/devopsbeerer/operator/internal/guardrails"
 	"github.com/devopsbeerer/operator/internal/helm"
+	"github.com/devopsbeerer/operator/internal/metrics"
+	"github.com/devopsbeerer/operator/internal/naming"
+	"github.com/devopsbeerer/operator/internal/parameters"
+	"github.com/devopsbeerer/operator/internal/tracing"
 )
 
 // ActiveScenarioReconciler reconciles a ActiveScenario object
@@ -41,6 +60,55 @@ type ActiveScenarioReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	HelmClient *helm.Client
+	Recorder   record.EventRecorder
+
+	// Config builds the clients impersonating the ServiceAccount a scenario
+	// is installed as
+	Config *rest.Config
+
+	// NamespaceDeletionTimeout is how long a namespace may take to terminate
+	// before the resources blocking its deletion are reported
+	NamespaceDeletionTimeout time.Duration
+
+	// Naming renders the namespace and Helm release names of scenarios
+	Naming *naming.Templates
+
+	// Guardrails are the operator-wide restrictions applied to scenario namespaces
+	Guardrails guardrails.Options
+
+	// RequireServiceAccount refuses to install scenarios whose definition
+	// does not name a ServiceAccount to install the chart as
+	RequireServiceAccount bool
+
+	// AutoUpgrade makes Auto the update policy of the ActiveScenarios that
+	// set none, instead of Manual
+	AutoUpgrade bool
+
+	// SourcePollInterval is how often the chart source of scenarios following
+	// it is checked when their update policy sets no interval
+	SourcePollInterval time.Duration
+
+	// SharedNamespace is the namespace the shared components required by
+	// scenarios are installed into
+	SharedNamespace string
+
+	// AllowedServiceAccounts are the existing ServiceAccounts, in
+	// namespace/name form, outside the shared namespace that
+	// ScenarioDefinitions may install their chart as
+	AllowedServiceAccounts []string
+
+	// NodeAddress is the address NodePort endpoints are published at
+	// (optional, defaults to the address of a node)
+	NodeAddress string
+
+	// ScenarioClusterRole is the ClusterRole granting the operator its rights
+	// inside scenario namespaces, bound in each of them to ServiceAccount
+	// (optional, the operator then holds these rights cluster-wide)
+	ScenarioClusterRole string
+
+	// ServiceAccount is the ServiceAccount the operator runs as, in
+	// namespace/name form
+	ServiceAccount string
 }
 
 //+kubebuilder:rbac:groups=devopsbeerer.io,resources=activescenarios,verbs=get;list;watch;create;update;patch;delete
@@ -49,20 +117,51 @@ type ActiveScenarioReconciler struct {
 //+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariodefinitions,verbs=get;list;watch
 //+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariohistories,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariohistories/status,verbs=get;update;patch
-//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;delete
-//+kubebuilder:rbac:groups="*",resources="*",verbs="*"
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariosnapshots,verbs=get;list;watch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariorestores,verbs=get;list;watch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariorestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups="",resources=resourcequotas;limitranges,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=services;nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate,resourceNames=devopsbeerer-installer
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles,verbs=bind;escalate,resourceNames=devopsbeerer-installer
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=bind,resourceNames=devopsbeerer-operator-scenario
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=delete,resourceNames=devopsbeerer-operator
+
+// Charts of scenarios without a ServiceAccount are installed with the rights of
+// the operator itself, which the Helm chart grants on every resource unless
+// rbac.leastPrivilege is set. The rights the operator needs inside scenario
+// namespaces, such as creating Jobs, come from the ScenarioClusterRole bound
+// in each of them.
 
 const (
 	finalizerName = "devopsbeerer.io/finalizer"
+
+	// managedLabel marks the namespaces created by the operator
+	managedLabel = "devopsbeerer.io/managed"
+	// scenarioLabel holds the scenario ID of the objects created for a scenario
+	scenarioLabel = "devopsbeerer.io/scenario"
+	// ownerLabel holds the owner of an ActiveScenario, used by naming templates
+	ownerLabel = "devopsbeerer.io/owner"
 )
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-func (r *ActiveScenarioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *ActiveScenarioReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	ctx, span := tracing.Start(ctx, "ActiveScenario.Reconcile", attribute.String("activescenario", req.Name))
+	defer func() { tracing.End(span, err) }()
+
 	log := log.FromContext(ctx)
 
 	// Fetch the ActiveScenario instance
-	activeScenario := &devopsbeererv1alpha1.ActiveScenario{}
+	activeScenario := &devopsbeererv1beta1.ActiveScenario{}
 	if err := r.Get(ctx, req.NamespacedName, activeScenario); err != nil {
 		if errors.IsNotFound(err) {
 			// Object not found, could have been deleted
@@ -72,6 +171,7 @@ func (r *ActiveScenarioReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		log.Error(err, "Failed to get ActiveScenario")
 		return ctrl.Result{}, err
 	}
+	span.SetAttributes(tracing.AttrScenarioID.String(activeScenario.Spec.ScenarioID))
 
 	// Handle deletion
 	if !activeScenario.DeletionTimestamp.IsZero() {
@@ -79,9 +179,27 @@ func (r *ActiveScenarioReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return r.handleDeletion(ctx, activeScenario)
 	}
 
-	// Do not reconcil if phase is pending
-	if activeScenario.Status.Phase != "" {
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	// Do not reconcile once the scenario has settled, unless the
+	// ActiveScenario or its ScenarioDefinition changed since. A scenario seen
+	// in any other phase was interrupted mid-operation, for instance by a
+	// leader handover, and is reconciled again from the start.
+	switch activeScenario.Status.Phase {
+	case devopsbeererv1beta1.ActiveScenarioPhaseRunning, devopsbeererv1beta1.ActiveScenarioPhaseFailed:
+		if activeScenario.Status.ObservedGeneration != activeScenario.Generation {
+			log.Info("ActiveScenario changed", "generation", activeScenario.Generation)
+			break
+		}
+		requeueAfter, settled, err := r.settled(ctx, activeScenario)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if settled {
+			return ctrl.Result{RequeueAfter: requeueAfter}, nil
+		}
+		log.Info("Update available", "scenarioId", activeScenario.Spec.ScenarioID)
+	case "":
+	default:
+		log.Info("Resuming interrupted reconciliation", "phase", activeScenario.Status.Phase)
 	}
 
 	// Add finalizer if it doesn't exist
@@ -101,22 +219,81 @@ func (r *ActiveScenarioReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 	}
 
 	// Get the desired scenario definition
-	scenarioDef := &devopsbeererv1alpha1.ScenarioDefinition{}
-	if err := r.Get(ctx, types.NamespacedName{Name: activeScenario.Spec.ScenarioId}, scenarioDef); err != nil {
+	scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{}
+	if err := r.Get(ctx, types.NamespacedName{Name: activeScenario.Spec.ScenarioID}, scenarioDef); err != nil {
 		if errors.IsNotFound(err) {
-			if err := r.updateStatus(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseFailed,
-				fmt.Sprintf("ScenarioDefinition '%s' not found", activeScenario.Spec.ScenarioId)); err != nil {
+			r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonDefinitionNotFound,
+				"ScenarioDefinition '%s' not found", activeScenario.Spec.ScenarioID)
+			if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("ScenarioDefinition '%s' not found", activeScenario.Spec.ScenarioID)); err != nil {
 				return ctrl.Result{}, err
 			}
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
 	}
+	activeScenario.Status.DefinitionGeneration = scenarioDef.Generation
 
-	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhasePending,
-		fmt.Sprintf("ScenarioDefinition '%s' not found", activeScenario.Spec.ScenarioId)); err != nil {
+	// A pending restore reinstalls the scenario with the parameters captured
+	// by its snapshot
+	restore, err := r.pendingRestore(ctx, activeScenario)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if restore != nil && !maps.Equal(activeScenario.Spec.Parameters, restore.snapshot.Status.Parameters) {
+		status := activeScenario.Status
+		activeScenario.Spec.Parameters = maps.Clone(restore.snapshot.Status.Parameters)
+		if err := r.Update(ctx, activeScenario); err != nil {
+			return ctrl.Result{}, err
+		}
+		activeScenario.Status = status
+	}
+
+	// Validate the parameters and render the Helm values
+	values, err := r.renderValues(activeScenario, scenarioDef)
+	if err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonInvalidParameters,
+			"Invalid parameters: %v", err)
+		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Invalid parameters: %v", err)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if r.RequireServiceAccount && scenarioDef.Spec.ServiceAccount == nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonServiceAccountFailed,
+			"ScenarioDefinition '%s' does not name a ServiceAccount", scenarioDef.Name)
+		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("ScenarioDefinition '%s' must name a ServiceAccount to install its chart as",
+				scenarioDef.Name)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Resolve the shared components and the order of the charts before
+	// uninstalling the previous scenario
+	plan, err := r.planInstall(ctx, scenarioDef)
+	if err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonInvalidDependencies,
+			"Invalid dependencies: %v", err)
+		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Invalid dependencies: %v", err)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	plan.restore = restore
+
+	if activeScenario.Status.Phase == "" {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonDefinitionResolved,
+			"Resolved ScenarioDefinition '%s'", scenarioDef.Name)
+		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhasePending,
+			fmt.Sprintf("Resolved ScenarioDefinition '%s'", activeScenario.Spec.ScenarioID)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
 	// Find the currently active scenario from history
 	activeHistory, err := r.findActiveScenarioHistory(ctx)
@@ -124,50 +301,152 @@ func (r *ActiveScenarioReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		return ctrl.Result{}, err
 	}
 
+	// A restore whose scenario is installed only waits for it to run
+	if plan.restore != nil && activeHistory != nil && plan.restore.installedBy(activeHistory) {
+		if err := r.restoreInstalled(ctx, activeScenario, plan.restore); err != nil {
+			return ctrl.Result{}, err
+		}
+		plan.restore = nil
+	}
+	if plan.restore != nil {
+		if err := r.startRestore(ctx, plan.restore); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// A bumped reset generation reinstalls the scenario from scratch
+	reset := plan.restore == nil && activeHistory != nil &&
+		activeHistory.Spec.ScenarioID == activeScenario.Spec.ScenarioID &&
+		activeHistory.Spec.ResetGeneration != activeScenario.Spec.ResetGeneration
+	if reset && len(activeScenario.Spec.CompletedSteps) > 0 {
+		status := activeScenario.Status
+		activeScenario.Spec.CompletedSteps = nil
+		if err := r.Update(ctx, activeScenario); err != nil {
+			return ctrl.Result{}, err
+		}
+		activeScenario.Status = status
+	}
+
 	// Determine action based on current state
 	if activeHistory == nil {
 		// No active scenario - install the requested one
-		log.Info("No active scenario found, installing new scenario", "scenarioId", activeScenario.Spec.ScenarioId)
-		return r.installScenario(ctx, activeScenario, scenarioDef)
+		log.Info("No active scenario found, installing new scenario", "scenarioId", activeScenario.Spec.ScenarioID)
+		result, err := r.installScenario(ctx, activeScenario, scenarioDef, plan, values)
+		if err == nil && plan.restore != nil {
+			err = r.restoreInstalled(ctx, activeScenario, plan.restore)
+		}
+		return result, err
 	}
 
-	// Check if we need to change scenarios
-	if activeHistory.Spec.ScenarioID != activeScenario.Spec.ScenarioId {
+	// Check if we need to change scenarios, reinstall the scenario for a
+	// restore or a reset, or wait for the namespace of the previous scenario to terminate
+	if activeHistory.Spec.ScenarioID != activeScenario.Spec.ScenarioID || plan.restore != nil || reset ||
+		activeHistory.Status.Phase == devopsbeererv1beta1.ScenarioHistoryPhaseTerminating {
 		log.Info("Scenario change detected",
 			"current", activeHistory.Spec.ScenarioID,
-			"desired", activeScenario.Spec.ScenarioId)
+			"desired", activeScenario.Spec.ScenarioID)
+		reason := devopsbeererv1beta1.UninstallReasonReplaced
+		if plan.restore != nil && activeHistory.Spec.ScenarioID == activeScenario.Spec.ScenarioID {
+			reason = devopsbeererv1beta1.UninstallReasonRestored
+		}
+		if reset {
+			reason = devopsbeererv1beta1.UninstallReasonReset
+			if activeHistory.Status.Phase != devopsbeererv1beta1.ScenarioHistoryPhaseTerminating {
+				r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonResetStarted,
+					"Resetting scenario '%s' for reset generation %d", activeScenario.Spec.ScenarioID,
+					activeScenario.Spec.ResetGeneration)
+			}
+		}
 
 		// Update status to show we're transitioning
-		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseTerminating,
-			fmt.Sprintf("Uninstalling previous scenario: %s", activeHistory.Spec.ScenarioID)); err != nil {
-			return ctrl.Result{}, err
+		if activeScenario.Status.Phase != devopsbeererv1beta1.ActiveScenarioPhaseTerminating {
+			if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseTerminating,
+				fmt.Sprintf("Uninstalling previous scenario: %s", activeHistory.Spec.ScenarioID)); err != nil {
+				return ctrl.Result{}, err
+			}
 		}
 
-		// Uninstall the current scenario
-		if err := r.uninstallScenario(ctx, activeHistory); err != nil {
+		// Uninstall the current scenario. A switch resumed after waiting for
+		// the namespace counts from the start of the uninstallation.
+		switchStart := time.Now()
+		if activeHistory.Status.UninstalledAt != nil {
+			switchStart = activeHistory.Status.UninstalledAt.Time
+		}
+		if err := r.uninstallScenario(ctx, activeScenario, activeHistory,
+			reason, activeScenario.Spec.ScenarioID); err != nil {
+			if goerrors.Is(err, errHookRunning) {
+				return r.hookRunning(ctx, activeScenario)
+			}
+			if goerrors.Is(err, errNamespaceTerminating) {
+				return ctrl.Result{RequeueAfter: namespacePollInterval}, nil
+			}
+			metrics.SwitchDuration.WithLabelValues(activeHistory.Spec.ScenarioID, activeScenario.Spec.ScenarioID,
+				metrics.Result(err)).Observe(time.Since(switchStart).Seconds())
 			return ctrl.Result{}, err
 		}
 
 		// Install the new scenario
-		return r.installScenario(ctx, activeScenario, scenarioDef)
+		result, err := r.installScenario(ctx, activeScenario, scenarioDef, plan, values)
+		switchResult := metrics.Result(err)
+		if activeScenario.Status.Phase != devopsbeererv1beta1.ActiveScenarioPhaseRunning {
+			switchResult = metrics.ResultFailure
+		}
+		metrics.SwitchDuration.WithLabelValues(activeHistory.Spec.ScenarioID, activeScenario.Spec.ScenarioID,
+			switchResult).Observe(time.Since(switchStart).Seconds())
+		if err == nil && plan.restore != nil {
+			err = r.restoreInstalled(ctx, activeScenario, plan.restore)
+		}
+		return result, err
 	}
 
-	// Same scenario is active - just update status if needed
-	if activeScenario.Status.Phase != devopsbeererv1alpha1.ActiveScenarioPhaseRunning {
-		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseRunning,
-			fmt.Sprintf("Scenario '%s' is running", scenarioDef.Spec.Name)); err != nil {
-			return ctrl.Result{}, err
+	// Resume the hooks run after the scenario was installed, and the
+	// uninstallation of a scenario their failure rolls back
+	if hookJob := activeScenario.Status.HookJob; hookJob != nil && hookJob.Namespace == activeHistory.Spec.Namespace {
+		switch hookJob.Hook {
+		case hookPostInstall.name:
+			return r.finishInstall(ctx, activeScenario, scenarioDef, activeHistory)
+		case hookPreUninstall.name, hookPostUninstall.name:
+			return r.rollbackInstall(ctx, activeScenario, activeHistory)
 		}
-		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
 
+	// Same scenario is active - upgrade it in place when its values changed,
+	// or when its definition or its chart source changed and its update
+	// policy allows it now
+	var requeueAfter time.Duration
+	pending := ""
+	if trigger := upgradeTrigger(activeScenario, scenarioDef, activeHistory, values); trigger != "" {
+		due, waiting, next := r.updateDue(r.updatePolicy(activeScenario), time.Now())
+		if trigger == devopsbeererv1beta1.RevisionTriggerSpecChanged || due {
+			return r.upgradeScenario(ctx, activeScenario, scenarioDef, plan, activeHistory, values, trigger)
+		}
+		pending = pendingUpdate(activeScenario, scenarioDef, trigger) + waiting
+		requeueAfter = next
 	}
 
-	// Requeue after 5 minutes for health checks
+	// Nothing to upgrade now, record the generations processed
+	r.refreshEndpoints(ctx, activeScenario, scenarioDef, activeHistory)
+	if verifyAfter := r.trackSteps(ctx, activeScenario, scenarioDef, activeHistory); verifyAfter > 0 &&
+		(requeueAfter == 0 || verifyAfter < requeueAfter) {
+		requeueAfter = verifyAfter
+	}
+	r.recordPendingUpdate(activeScenario, scenarioDef, pending)
+	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseRunning,
+		fmt.Sprintf("Scenario '%s' is running", scenarioDef.Spec.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Requeue after 5 minutes for health checks, or when the current step is
+	// due for verification or the update window opens
+	if requeueAfter > 0 {
+		return ctrl.Result{RequeueAfter: min(requeueAfter, 5*time.Minute)}, nil
+	}
 	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
 }
 
-// handleDeletion handles the deletion of ActiveScenario
-func (r *ActiveScenarioReconciler) handleDeletion(ctx context.Context, activeScenario *devopsbeererv1alpha1.ActiveScenario) (ctrl.Result, error) {
+// handleDeletion handles the deletion of ActiveScenario, uninstalling its
+// scenario on behalf of the user recorded as deleting it
+func (r *ActiveScenarioReconciler) handleDeletion(ctx context.Context, activeScenario *devopsbeererv1beta1.ActiveScenario) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
 	if controllerutil.ContainsFinalizer(activeScenario, finalizerName) {
@@ -179,8 +458,16 @@ func (r *ActiveScenarioReconciler) handleDeletion(ctx context.Context, activeSce
 
 		if activeHistory != nil {
 			log.Info("Uninstalling active scenario due to ActiveScenario deletion",
-				"scenarioId", activeHistory.Spec.ScenarioID)
-			if err := r.uninstallScenario(ctx, activeHistory); err != nil {
+				"scenarioId", activeHistory.Spec.ScenarioID,
+				"deletedBy", uninstalledBy(activeScenario, devopsbeererv1beta1.UninstallReasonDeleted))
+			if err := r.uninstallScenario(ctx, activeScenario, activeHistory,
+				devopsbeererv1beta1.UninstallReasonDeleted, ""); err != nil {
+				if goerrors.Is(err, errHookRunning) {
+					return r.hookRunning(ctx, activeScenario)
+				}
+				if goerrors.Is(err, errNamespaceTerminating) {
+					return ctrl.Result{RequeueAfter: namespacePollInterval}, nil
+				}
 				return ctrl.Result{}, err
 			}
 		}
@@ -195,43 +482,90 @@ func (r *ActiveScenarioReconciler) handleDeletion(ctx context.Context, activeSce
 	return ctrl.Result{}, nil
 }
 
-// findActiveScenarioHistory finds the currently active scenario from history
-func (r *ActiveScenarioReconciler) findActiveScenarioHistory(ctx context.Context) (*devopsbeererv1alpha1.ScenarioHistory, error) {
-	historyList := &devopsbeererv1alpha1.ScenarioHistoryList{}
-	if err := r.List(ctx, historyList); err != nil {
-		return nil, err
+// renderValues validates the ActiveScenario parameters against the scenario
+// definition and renders them as Helm values, along with the references to the
+// generated credentials
+func (r *ActiveScenarioReconciler) renderValues(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition) (string, error) {
+
+	values, err := parameters.Resolve(scenarioDef.Spec.Parameters, activeScenario.Spec.Parameters)
+	if err != nil {
+		return "", err
+	}
+
+	// Credentials are referenced, their values never end up in the history
+	for _, credential := range scenarioDef.Spec.Credentials {
+		path := credential.Path
+		if path == "" {
+			path = "credentials." + credential.Name
+		}
+		if err := parameters.Set(values, path,
+			credentials.Reference(credentialsSecretName, credential)); err != nil {
+			return "", fmt.Errorf("credential %q: %w", credential.Name, err)
+		}
 	}
 
-	for i := range historyList.Items {
-		if historyList.Items[i].Status.Phase == devopsbeererv1alpha1.ScenarioHistoryPhaseActive {
-			return &historyList.Items[i], nil
+	if scenarioDef.Spec.ValuesSchema != nil {
+		if err := parameters.Validate(scenarioDef.Spec.ValuesSchema.Raw, values); err != nil {
+			return "", err
 		}
 	}
 
-	return nil, nil
+	return parameters.Render(values)
 }
 
-// installScenario installs a new scenario
+// installScenario installs a new scenario after the shared components it
+// requires, then its additional charts
 func (r *ActiveScenarioReconciler) installScenario(ctx context.Context,
-	activeScenario *devopsbeererv1alpha1.ActiveScenario,
-	scenarioDef *devopsbeererv1alpha1.ScenarioDefinition) (ctrl.Result, error) {
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	plan *installPlan,
+	values string) (_ ctrl.Result, err error) {
+
+	ctx, span := tracing.Start(ctx, "installScenario",
+		tracing.AttrScenarioID.String(scenarioDef.Spec.ID))
+	defer func() { tracing.End(span, err) }()
 
 	log := log.FromContext(ctx)
 
+	// Name the namespace and release of the scenario
+	namespace, helmRelease, err := r.scenarioNames(activeScenario, scenarioDef)
+	if err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonInvalidName,
+			"Failed to name scenario '%s': %v", scenarioDef.Spec.ID, err)
+		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to name scenario: %v", err)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	span.SetAttributes(tracing.AttrRelease.String(helmRelease), tracing.AttrNamespace.String(namespace))
+
+	// Wait for a namespace of the same name to finish terminating
+	existing := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, existing); err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	} else if err == nil && !existing.DeletionTimestamp.IsZero() {
+		if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhasePending,
+			fmt.Sprintf("Waiting for namespace %s to terminate", namespace)); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: namespacePollInterval}, nil
+	}
+
 	// Update status to Deploying
-	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseDeploying,
+	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseDeploying,
 		fmt.Sprintf("Installing scenario: %s", scenarioDef.Spec.Name)); err != nil {
 		return ctrl.Result{}, err
 	}
 
 	// Create namespace
-	namespace := fmt.Sprintf("devopsbeerer-%s", scenarioDef.Spec.ID)
 	ns := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: namespace,
 			Labels: map[string]string{
-				"devopsbeerer.io/scenario": scenarioDef.Spec.ID,
-				"devopsbeerer.io/managed":  "true",
+				scenarioLabel: scenarioDef.Spec.ID,
+				managedLabel:  "true",
 			},
 		},
 	}
@@ -247,127 +581,601 @@ func (r *ActiveScenarioReconciler) installScenario(ctx context.Context,
 		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 	}
 
-	if err := r.Create(ctx, ns); err != nil && !errors.IsAlreadyExists(err) {
-		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseFailed,
-			fmt.Sprintf("Failed to create namespace: %v", err))
+	if err := r.Create(ctx, ns); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonNamespaceFailed,
+				"Failed to create namespace %s: %v", namespace, err)
+			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("Failed to create namespace: %v", err))
+		}
+
+		// Refuse to adopt a namespace the operator did not create
+		if err := r.Get(ctx, client.ObjectKey{Name: namespace}, existing); err != nil {
+			return ctrl.Result{}, err
+		}
+		if existing.Labels[managedLabel] != "true" {
+			r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonNamespaceConflict,
+				"Namespace %s already exists and is not managed by the operator", namespace)
+			if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("Namespace %s already exists and is not managed by the operator", namespace)); err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+	} else {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonNamespaceCreated,
+			"Created namespace %s", namespace)
 	}
 
-	// TODO: Install helm chart here
-	// For now, we'll simulate the installation
-	log.Info("Installing helm chart",
-		"repo", scenarioDef.Spec.HelmChart.Link,
-		"chart", scenarioDef.Spec.HelmChart.Dir,
-		"namespace", namespace)
+	// Restrict the namespace before the chart can create anything in it
+	if err := guardrails.Apply(ctx, r.Client, namespace, r.scenarioGuardrails(scenarioDef, plan),
+		ns.Labels, r.Guardrails); err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonGuardrailsFailed,
+			"Failed to apply guardrails to namespace %s: %v", namespace, err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to apply namespace guardrails: %v", err))
+	}
 
-	// Install helm chart
-	helmRelease := fmt.Sprintf("devopsbeerer-%s", scenarioDef.Spec.ID)
-	chartPath := scenarioDef.Spec.HelmChart.Dir
-	if chartPath == "" {
-		chartPath = scenarioDef.Spec.ID // Default to scenario ID
+	// Grant the operator its rights in the namespace, and prepare the
+	// ServiceAccount the chart is installed as
+	if err := r.bindOperator(ctx, scenarioDef, namespace); err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonServiceAccountFailed,
+			"Failed to bind the operator role: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to bind the operator role: %v", err))
+	}
+	serviceAccount, err := r.ensureServiceAccount(ctx, scenarioDef, namespace, installerName)
+	if err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonServiceAccountFailed,
+			"Failed to prepare service account: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to prepare service account: %v", err))
 	}
 
-	if r.HelmClient != nil {
-		if err := r.HelmClient.Install(ctx, helmRelease, namespace,
-			scenarioDef.Spec.HelmChart.Link, chartPath, ""); err != nil {
-			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseFailed,
+	// Generate fresh credentials for every installation, keeping those the
+	// preInstall hook being run may use
+	if err := r.ensureCredentials(ctx, activeScenario, scenarioDef, namespace,
+		activeScenario.Status.HookJob == nil); err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonCredentialsFailed,
+			"Failed to generate credentials: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to generate credentials: %v", err))
+	}
+
+	// Restore the objects and volumes of the snapshot, for the chart to adopt
+	if plan.restore != nil {
+		if err := r.restoreSnapshot(ctx, activeScenario, plan.restore, namespace, helmRelease); err != nil {
+			if goerrors.Is(err, errRestoreCopying) {
+				if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseDeploying,
+					fmt.Sprintf("Copying the volumes of snapshot %s back", plan.restore.snapshot.Name)); err != nil {
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+			}
+			r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonRestoreFailed,
+				"Failed to restore snapshot %s: %v", plan.restore.snapshot.Name, err)
+			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("Failed to restore snapshot %s: %v", plan.restore.snapshot.Name, err))
+		}
+	}
+
+	// Run the preInstall hook before anything is installed
+	if policy, err := r.runHook(ctx, activeScenario, scenarioDef.Spec.Hooks, hookPreInstall,
+		namespace, helmRelease, serviceAccount); err != nil {
+		if goerrors.Is(err, errHookRunning) {
+			return r.hookRunning(ctx, activeScenario)
+		}
+		message := err.Error()
+		if policy == devopsbeererv1beta1.HookFailurePolicyRollback {
+			if err := r.deleteNamespace(ctx, namespace); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to delete namespace: %w", err)
+			}
+			message += ", namespace deleted"
+		}
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed, message)
+	}
+
+	// Install the shared components the chart depends on
+	if err := r.installComponents(ctx, activeScenario, plan.components); err != nil {
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonComponentFailed,
+			truncateMessage(err.Error()))
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to install shared components: %v", err))
+	}
+
+	// Install helm chart
+	chart := chartSource(scenarioDef)
+	sourceRevision := r.resolveRevision(ctx, activeScenario, &chart)
+	log.Info("Installing helm chart",
+		"chart", chart.String(),
+		"namespace", namespace,
+		"serviceAccount", serviceAccount)
+
+	var helmRevision int32
+	if helmClient := r.helmClientFor(serviceAccount); helmClient != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonInstallStarted,
+			"Installing helm release %s from %s", helmRelease, chart.String())
+		if err := r.recoverRelease(ctx, activeScenario, helmClient, helmRelease, namespace); err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+				helmFailureReason(metrics.ReasonRecover, err)).Inc()
+			r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonInstallFailed,
+				truncateMessage(fmt.Sprintf("Failed to recover helm release %s: %v", helmRelease, err)))
+			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("Failed to recover helm release: %v", err))
+		}
+		installStart := time.Now()
+		err := helmClient.Install(ctx, helmRelease, namespace, chart, values, helm.InstallOptions{})
+		metrics.InstallDuration.WithLabelValues(scenarioDef.Spec.ID, metrics.Result(err)).
+			Observe(time.Since(installStart).Seconds())
+		if err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+				helmFailureReason(metrics.ReasonInstall, err)).Inc()
+			r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonInstallFailed,
+				truncateMessage(fmt.Sprintf("Failed to install helm release %s: %v", helmRelease, err)))
+			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
 				fmt.Sprintf("Failed to install helm chart: %v", err))
 		}
+		helmRevision = r.helmRevision(ctx, helmClient, helmRelease, namespace)
+	}
+
+	// Install the additional charts in order, each ready before the next
+	releases, err := r.installCharts(ctx, activeScenario, scenarioDef, plan, namespace, helmRelease,
+		serviceAccount, ns.Labels)
+	if err != nil {
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonInstallFailed,
+			truncateMessage(err.Error()))
+		// No history records the charts installed before the failure, which
+		// nothing would uninstall otherwise
+		if err := r.rollbackCharts(ctx, scenarioDef.Spec.ID, serviceAccount, namespace, releases); err != nil {
+			log.Error(err, "Failed to roll back scenario charts")
+			r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUninstallFailed,
+				truncateMessage(err.Error()))
+		}
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to install scenario charts: %v", err))
 	}
 
 	// Create history entry
-	history := &devopsbeererv1alpha1.ScenarioHistory{
+	history := &devopsbeererv1beta1.ScenarioHistory{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: fmt.Sprintf("history-%s-%d", scenarioDef.Spec.ID, time.Now().Unix()),
+			Labels: map[string]string{
+				managedLabel:        "true",
+				scenarioLabel:       scenarioDef.Spec.ID,
+				activeScenarioLabel: activeScenario.Name,
+				phaseLabel:          string(devopsbeererv1beta1.ScenarioHistoryPhaseActive),
+			},
 		},
-		Spec: devopsbeererv1alpha1.ScenarioHistorySpec{
-			ScenarioID:  scenarioDef.Spec.ID,
-			Namespace:   namespace,
-			HelmRelease: fmt.Sprintf("devopsbeerer-%s", scenarioDef.Spec.ID),
-			InstalledAt: metav1.Now(),
+		Spec: devopsbeererv1beta1.ScenarioHistorySpec{
+			ScenarioID:           scenarioDef.Spec.ID,
+			ResetGeneration:      activeScenario.Spec.ResetGeneration,
+			Namespace:            namespace,
+			HelmRelease:          helmRelease,
+			InstalledAt:          metav1.Now(),
+			InstalledBy:          activeScenario.Annotations[devopsbeererv1beta1.RequestedByAnnotation],
+			Values:               values,
+			DefinitionGeneration: scenarioDef.Generation,
+			SourceRevision:       sourceRevision,
+			ServiceAccount:       serviceAccount,
+			Components:           componentIDs(plan.components),
+			Releases:             releases,
 		},
-		Status: devopsbeererv1alpha1.ScenarioHistoryStatus{
-			Phase: devopsbeererv1alpha1.ScenarioHistoryPhaseActive,
+		Status: devopsbeererv1beta1.ScenarioHistoryStatus{
+			Phase: devopsbeererv1beta1.ScenarioHistoryPhaseActive,
 		},
 	}
 
+	trigger := devopsbeererv1beta1.RevisionTriggerInstall
+	if plan.restore != nil {
+		history.Spec.RestoredFrom = &devopsbeererv1beta1.RestoreSource{
+			Restore:  plan.restore.restore.Name,
+			Snapshot: plan.restore.snapshot.Name,
+		}
+		trigger = devopsbeererv1beta1.RevisionTriggerRestore
+	}
+	recordRevision(history, trigger, helmRevision)
+	status := history.Status
 	if err := r.Create(ctx, history); err != nil {
-		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1alpha1.ActiveScenarioPhaseFailed,
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonHistoryFailed,
+			"Failed to create history: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
 			fmt.Sprintf("Failed to create history: %v", err))
 	}
+	// The status is not persisted on creation, while the phase is what the
+	// active history is looked up by
+	history.Status = status
+	if err := r.Status().Update(ctx, history); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update history status: %w", err)
+	}
+
+	metrics.ActiveScenarios.WithLabelValues(scenarioDef.Spec.ID, activeScenario.Name).Set(1)
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonInstallSucceeded,
+		"Installed scenario '%s' as helm release %s", scenarioDef.Spec.Name, helmRelease)
+	r.Recorder.Eventf(history, corev1.EventTypeNormal, EventReasonHistoryCreated,
+		"Scenario '%s' installed in namespace %s", scenarioDef.Spec.ID, namespace)
+
+	return r.finishInstall(ctx, activeScenario, scenarioDef, history)
+}
+
+// finishInstall runs the postInstall hook of a scenario just installed, then
+// reports it running
+func (r *ActiveScenarioReconciler) finishInstall(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	history *devopsbeererv1beta1.ScenarioHistory) (ctrl.Result, error) {
 
+	// Run the postInstall hook once every chart is installed
+	if policy, err := r.runHook(ctx, activeScenario, scenarioDef.Spec.Hooks, hookPostInstall,
+		history.Spec.Namespace, history.Spec.HelmRelease, history.Spec.ServiceAccount); err != nil {
+		if goerrors.Is(err, errHookRunning) {
+			return r.hookRunning(ctx, activeScenario)
+		}
+		if policy == devopsbeererv1beta1.HookFailurePolicyRollback {
+			return r.rollbackInstall(ctx, activeScenario, history)
+		}
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed, err.Error())
+	}
 	// Update ActiveScenario status
-	activeScenario.Status.Phase = devopsbeererv1alpha1.ActiveScenarioPhaseRunning
+	r.refreshEndpoints(ctx, activeScenario, scenarioDef, history)
+	requeueAfter := r.trackSteps(ctx, activeScenario, scenarioDef, history)
+	activeScenario.Status.Phase = devopsbeererv1beta1.ActiveScenarioPhaseRunning
 	activeScenario.Status.ScenarioName = scenarioDef.Spec.Name
 	activeScenario.Status.HelmReleaseName = history.Spec.HelmRelease
 	activeScenario.Status.StartTime = &history.Spec.InstalledAt
-	activeScenario.Status.LastTransitionTime = &metav1.Time{Time: time.Now()}
-	activeScenario.Status.Message = fmt.Sprintf("Scenario '%s' is running", scenarioDef.Spec.Name)
+	setUpToDateCondition(activeScenario, scenarioDef, "")
+	setReadyCondition(activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseRunning,
+		fmt.Sprintf("Scenario '%s' is running", scenarioDef.Spec.Name))
 
 	if err := r.Status().Update(ctx, activeScenario); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-// uninstallScenario uninstalls a scenario
+// rollbackInstall uninstalls a scenario whose postInstall hook failed. The
+// uninstallation completes with the next activation once the namespace is
+// gone.
+func (r *ActiveScenarioReconciler) rollbackInstall(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, history *devopsbeererv1beta1.ScenarioHistory) (ctrl.Result, error) {
+
+	if err := r.uninstallScenario(ctx, activeScenario, history,
+		devopsbeererv1beta1.UninstallReasonFailed, ""); err != nil {
+		if goerrors.Is(err, errHookRunning) {
+			return r.hookRunning(ctx, activeScenario)
+		}
+		if !goerrors.Is(err, errNamespaceTerminating) {
+			return ctrl.Result{}, err
+		}
+	}
+	message := "The postInstall hook failed"
+	if condition := meta.FindStatusCondition(activeScenario.Status.Conditions,
+		devopsbeererv1beta1.ActiveScenarioConditionPostInstallHook); condition != nil {
+		message = condition.Message
+	}
+	return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+		message+", scenario uninstalled")
+}
+
+// uninstallScenario uninstalls a scenario for the given reason, recording the
+// scenario replacing it if any. It returns errHookRunning while its uninstall
+// hooks run, then errNamespaceTerminating until the namespace of the scenario
+// is gone, and is called again to resume the uninstallation.
 func (r *ActiveScenarioReconciler) uninstallScenario(ctx context.Context,
-	history *devopsbeererv1alpha1.ScenarioHistory) error {
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	history *devopsbeererv1beta1.ScenarioHistory,
+	reason devopsbeererv1beta1.UninstallReason, successor string) (err error) {
+
+	ctx, span := tracing.Start(ctx, "uninstallScenario",
+		tracing.AttrScenarioID.String(history.Spec.ScenarioID),
+		tracing.AttrRelease.String(history.Spec.HelmRelease),
+		tracing.AttrNamespace.String(history.Spec.Namespace))
+	defer func() { tracing.End(span, err) }()
 
 	log := log.FromContext(ctx)
 
-	// TODO: Uninstall helm chart here
-	log.Info("Uninstalling helm chart",
-		"release", history.Spec.HelmRelease,
-		"namespace", history.Spec.Namespace)
+	if history.Status.Phase != devopsbeererv1beta1.ScenarioHistoryPhaseTerminating {
+		start := metav1.Now()
+		// A reset installs the scenario again at the same endpoints
+		if reason != devopsbeererv1beta1.UninstallReasonReset {
+			activeScenario.Status.Endpoints = nil
+		}
+		activeScenario.Status.CredentialsSecret = nil
+		activeScenario.Status.Steps = nil
+		activeScenario.Status.CurrentStep = ""
+
+		// An uninstallation resumed while its hooks run was already reported
+		hookJob := activeScenario.Status.HookJob
+		if hookJob != nil && hookJob.Namespace != history.Spec.Namespace {
+			hookJob = nil
+		}
+		if hookJob == nil || (hookJob.Hook != hookPreUninstall.name && hookJob.Hook != hookPostUninstall.name) {
+			log.Info("Uninstalling helm chart",
+				"release", history.Spec.HelmRelease,
+				"namespace", history.Spec.Namespace)
+			r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonUninstallStarted,
+				"Uninstalling scenario '%s' (helm release %s)", history.Spec.ScenarioID, history.Spec.HelmRelease)
+			r.Recorder.Eventf(history, corev1.EventTypeNormal, EventReasonUninstallStarted,
+				"Uninstalling helm release %s", history.Spec.HelmRelease)
+		}
+		hooks, err := r.scenarioHooks(ctx, history.Spec.ScenarioID)
+		if err != nil {
+			return err
+		}
+
+		// The charts are already uninstalled once the postUninstall hook runs
+		if hookJob == nil || hookJob.Hook != hookPostUninstall.name {
+			// Run the preUninstall hook while the scenario is still installed
+			if err := r.runUninstallHook(ctx, activeScenario, history, hooks, hookPreUninstall); err != nil {
+				return err
+			}
+
+			// The additional charts first, in reverse installation order
+			if err := r.uninstallCharts(ctx, history.Spec.ScenarioID, history.Spec.ServiceAccount,
+				history.Spec.Releases); err != nil {
+				metrics.UninstallDuration.WithLabelValues(history.Spec.ScenarioID, metrics.ResultFailure).
+					Observe(time.Since(start.Time).Seconds())
+				message := truncateMessage(err.Error())
+				r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUninstallFailed, message)
+				r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonUninstallFailed, message)
+				return err
+			}
+			if helmClient := r.helmClientFor(history.Spec.ServiceAccount); helmClient != nil {
+				if err := helmClient.Uninstall(ctx, history.Spec.HelmRelease, history.Spec.Namespace); err != nil {
+					metrics.UninstallDuration.WithLabelValues(history.Spec.ScenarioID, metrics.ResultFailure).
+						Observe(time.Since(start.Time).Seconds())
+					metrics.HelmFailures.WithLabelValues(history.Spec.ScenarioID,
+						helmFailureReason(metrics.ReasonUninstall, err)).Inc()
+					message := truncateMessage(fmt.Sprintf("Failed to uninstall helm release %s: %v", history.Spec.HelmRelease, err))
+					r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUninstallFailed, message)
+					r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonUninstallFailed, message)
+					return fmt.Errorf("failed to uninstall helm chart: %w", err)
+				}
+			}
+		}
+
+		// Run the postUninstall hook before the namespace goes away
+		if err := r.runUninstallHook(ctx, activeScenario, history, hooks, hookPostUninstall); err != nil {
+			return err
+		}
+
+		// Delete namespaces
+		for _, namespace := range historyNamespaces(history) {
+			if err := r.deleteNamespace(ctx, namespace); err != nil {
+				r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonNamespaceFailed,
+					"Failed to delete namespace %s: %v", namespace, err)
+				return fmt.Errorf("failed to delete namespace: %w", err)
+			}
+		}
 
-	// Uninstall helm chart
-	if r.HelmClient != nil {
-		if err := r.HelmClient.Uninstall(ctx, history.Spec.HelmRelease, history.Spec.Namespace); err != nil {
-			return fmt.Errorf("failed to uninstall helm chart: %w", err)
+		// Track the namespace termination on the history
+		history.Status.Phase = devopsbeererv1beta1.ScenarioHistoryPhaseTerminating
+		history.Status.UninstalledAt = &start
+		history.Status.UninstallReason = reason
+		history.Status.Successor = successor
+		history.Status.UninstalledBy = uninstalledBy(activeScenario, reason)
+		history.Status.Message = fmt.Sprintf("Waiting for namespace %s to terminate", history.Spec.Namespace)
+		if err := r.Status().Update(ctx, history); err != nil {
+			return fmt.Errorf("failed to update history status: %w", err)
 		}
 	}
 
-	// Delete namespace
-	ns := &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: history.Spec.Namespace,
-		},
+	// The next scenario is not installed until the namespace is gone
+	if err := r.waitForNamespace(ctx, activeScenario, history); err != nil {
+		return err
+	}
+
+	// Then the components it required are released
+	if err := r.releaseComponents(ctx, activeScenario, history, history.Spec.Components, successor); err != nil {
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonComponentFailed,
+			truncateMessage(err.Error()))
+		return fmt.Errorf("failed to release shared components: %w", err)
 	}
 
-	if err := r.Delete(ctx, ns); err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete namespace: %w", err)
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonUninstallSucceeded,
+		"Uninstalled scenario '%s'", history.Spec.ScenarioID)
+	metrics.ActiveScenarios.DeletePartialMatch(prometheus.Labels{"scenario": history.Spec.ScenarioID})
+	metrics.HealthChecks.Forget(history.Spec.ScenarioID)
+	if history.Status.UninstalledAt != nil {
+		metrics.UninstallDuration.WithLabelValues(history.Spec.ScenarioID, metrics.ResultSuccess).
+			Observe(time.Since(history.Status.UninstalledAt.Time).Seconds())
 	}
 
 	// Update history to archived
-	history.Status.Phase = devopsbeererv1alpha1.ScenarioHistoryPhaseArchived
-	history.Status.UninstalledAt = &metav1.Time{Time: time.Now()}
-	history.Status.UninstallReason = "Replaced by new scenario"
+	history.Status.Phase = devopsbeererv1beta1.ScenarioHistoryPhaseArchived
+	history.Status.Message = ""
 
 	if err := r.Status().Update(ctx, history); err != nil {
 		return fmt.Errorf("failed to update history status: %w", err)
 	}
+	r.Recorder.Eventf(history, corev1.EventTypeNormal, EventReasonHistoryArchived,
+		"Archived: %s", history.Status.UninstallReason)
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonHistoryArchived,
+		"Archived history %s", history.Name)
 
 	return nil
 }
 
+// uninstalledBy returns the user who triggered an uninstallation, as recorded
+// on the ActiveScenario by its webhook. The deleting user is only known when
+// the deleted-by annotation was set before the deletion.
+func uninstalledBy(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	reason devopsbeererv1beta1.UninstallReason) string {
+
+	if reason == devopsbeererv1beta1.UninstallReasonDeleted {
+		return activeScenario.Annotations[devopsbeererv1beta1.DeletedByAnnotation]
+	}
+	return activeScenario.Annotations[devopsbeererv1beta1.RequestedByAnnotation]
+}
+
+// scenarioNames renders the namespace and Helm release name of a scenario
+func (r *ActiveScenarioReconciler) scenarioNames(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition) (namespace, release string, err error) {
+
+	vars := naming.Variables{
+		ScenarioID: scenarioDef.Spec.ID,
+		Instance:   activeScenario.Name,
+		Owner:      activeScenario.Labels[ownerLabel],
+	}
+	if vars.Owner == "" {
+		vars.Owner = activeScenario.Name
+	}
+
+	if namespace, err = r.Naming.Namespace(vars); err != nil {
+		return "", "", err
+	}
+	if release, err = r.Naming.Release(vars); err != nil {
+		return "", "", err
+	}
+	return namespace, release, nil
+}
+
+// recoverRelease unlocks a helm release left pending by an operation that was
+// interrupted, so that it can be installed again
+func (r *ActiveScenarioReconciler) recoverRelease(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	helmClient *helm.Client, releaseName, namespace string) error {
+
+	status, err := helmClient.Recover(ctx, releaseName, namespace)
+	if err != nil || status == "" {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Recovered interrupted helm release", "release", releaseName, "status", status)
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonReleaseRecovered,
+		"Recovered helm release %s left %s by an interrupted operation", releaseName, status)
+	return nil
+}
+
+// checkHealth checks the helm release of the running scenario and records
+// the result on its history
+func (r *ActiveScenarioReconciler) checkHealth(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario) error {
+
+	history, err := r.findActiveScenarioHistory(ctx)
+	if err != nil || history == nil || r.HelmClient == nil {
+		return err
+	}
+	metrics.ActiveScenarios.WithLabelValues(history.Spec.ScenarioID, activeScenario.Name).Set(1)
+
+	// The scenario is healthy when its release and the releases of its
+	// additional charts are all deployed
+	releases := append([]devopsbeererv1beta1.ScenarioRelease{{
+		Name:      history.Spec.HelmRelease,
+		Namespace: history.Spec.Namespace,
+	}}, history.Spec.Releases...)
+	helmClient := r.helmClientFor(history.Spec.ServiceAccount)
+	now := metav1.Now()
+	history.Status.LastHealthCheck = &now
+	history.Status.Health = "Healthy"
+	history.Status.Message = ""
+	for _, release := range releases {
+		status, err := helmClient.ReleaseStatus(ctx, release.Name, release.Namespace)
+		if err != nil {
+			metrics.HelmFailures.WithLabelValues(history.Spec.ScenarioID,
+				helmFailureReason(metrics.ReasonStatus, err)).Inc()
+			history.Status.Health = "Unknown"
+			history.Status.Message = truncateMessage(fmt.Sprintf("Health check failed: %v", err))
+			break
+		}
+		if status != "deployed" {
+			history.Status.Health = "Unhealthy"
+			history.Status.Message = fmt.Sprintf("Helm release %s is %s", release.Name, status)
+			break
+		}
+	}
+	if history.Status.Health == "Healthy" {
+		metrics.HealthChecks.Succeeded(history.Spec.ScenarioID, now.Time)
+	}
+
+	return r.Status().Update(ctx, history)
+}
+
 // updateStatus updates the ActiveScenario status
 func (r *ActiveScenarioReconciler) updateStatus(ctx context.Context,
-	activeScenario *devopsbeererv1alpha1.ActiveScenario,
-	phase devopsbeererv1alpha1.ActiveScenarioPhase,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	phase devopsbeererv1beta1.ActiveScenarioPhase,
 	message string) error {
 
 	activeScenario.Status.Phase = phase
-	activeScenario.Status.Message = message
-	activeScenario.Status.LastTransitionTime = &metav1.Time{Time: time.Now()}
+	setReadyCondition(activeScenario, phase, message)
 
 	return r.Status().Update(ctx, activeScenario)
 }
 
+// setReadyCondition records the phase and message on the Ready condition
+func setReadyCondition(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	phase devopsbeererv1beta1.ActiveScenarioPhase, message string) {
+
+	status := metav1.ConditionUnknown
+	switch phase {
+	case devopsbeererv1beta1.ActiveScenarioPhaseRunning:
+		status = metav1.ConditionTrue
+	case devopsbeererv1beta1.ActiveScenarioPhaseFailed:
+		status = metav1.ConditionFalse
+	}
+
+	activeScenario.Status.ObservedGeneration = activeScenario.Generation
+	meta.SetStatusCondition(&activeScenario.Status.Conditions, metav1.Condition{
+		Type:               devopsbeererv1beta1.ActiveScenarioConditionReady,
+		Status:             status,
+		Reason:             string(phase),
+		Message:            message,
+		ObservedGeneration: activeScenario.Generation,
+	})
+}
+
+// setUpToDateCondition records whether the running scenario runs the latest
+// revision of its ScenarioDefinition and chart source, or the update pending
+func setUpToDateCondition(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition, pending string) {
+
+	condition := metav1.Condition{
+		Type:               devopsbeererv1beta1.ActiveScenarioConditionUpToDate,
+		Status:             metav1.ConditionTrue,
+		Reason:             "UpToDate",
+		Message:            fmt.Sprintf("Running the latest revision of ScenarioDefinition '%s'", scenarioDef.Name),
+		ObservedGeneration: activeScenario.Generation,
+	}
+	if pending != "" {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "UpdatePending"
+		condition.Message = pending
+	}
+	meta.SetStatusCondition(&activeScenario.Status.Conditions, condition)
+}
+
+// chartSource converts the chart source of a scenario definition for the helm client
+func chartSource(scenarioDef *devopsbeererv1beta1.ScenarioDefinition) helm.ChartSource {
+	return helmChartSource(scenarioDef.Spec.Chart, scenarioDef.Spec.ID)
+}
+
+// helmChartSource converts a chart source for the helm client, defaulting the
+// path of a Git source
+func helmChartSource(source devopsbeererv1beta1.ChartSource, defaultPath string) helm.ChartSource {
+	if repo := source.Repository; repo != nil {
+		return helm.ChartSource{
+			RepoURL: repo.URL,
+			Chart:   repo.Chart,
+			Version: repo.Version,
+		}
+	}
+
+	chart := helm.ChartSource{}
+	if git := source.Git; git != nil {
+		chart.GitURL = git.URL
+		chart.GitRef = git.Ref
+		chart.Path = git.Path
+	}
+	if chart.Path == "" {
+		chart.Path = defaultPath
+	}
+	return chart
+}
+
 // updateStatusAndRequeue updates status and returns a requeue result
 func (r *ActiveScenarioReconciler) updateStatusAndRequeue(ctx context.Context,
-	activeScenario *devopsbeererv1alpha1.ActiveScenario,
-	phase devopsbeererv1alpha1.ActiveScenarioPhase,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	phase devopsbeererv1beta1.ActiveScenarioPhase,
 	message string) (ctrl.Result, error) {
 
 	if err := r.updateStatus(ctx, activeScenario, phase, message); err != nil {
@@ -376,10 +1184,96 @@ func (r *ActiveScenarioReconciler) updateStatusAndRequeue(ctx context.Context,
 	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// helmFailureReason classifies a failed helm operation for metrics
+func helmFailureReason(operation string, err error) string {
+	switch {
+	case goerrors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timed out waiting"):
+		return metrics.ReasonTimeout
+	case goerrors.Is(err, helm.ErrChartFetch):
+		return metrics.ReasonFetch
+	default:
+		return operation
+	}
+}
+
+// activeScenariosForDefinition maps a ScenarioDefinition to the
+// ActiveScenarios requesting it
+func (r *ActiveScenarioReconciler) activeScenariosForDefinition(ctx context.Context, obj client.Object) []reconcile.Request {
+	activeScenarios := &devopsbeererv1beta1.ActiveScenarioList{}
+	if err := r.List(ctx, activeScenarios, client.MatchingFields{scenarioIDField: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ActiveScenarios", "scenarioDefinition", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(activeScenarios.Items))
+	for _, activeScenario := range activeScenarios.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&activeScenario)})
+	}
+	return requests
+}
+
+// activeScenarioForHistory maps a ScenarioHistory to the ActiveScenario it was
+// installed for
+func activeScenarioForHistory(_ context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[activeScenarioLabel]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name}}}
+}
+
+// historyPhaseChanged only lets through the ScenarioHistory updates changing
+// its phase, as health checks update the history on every reconciliation
+var historyPhaseChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldHistory, ok := e.ObjectOld.(*devopsbeererv1beta1.ScenarioHistory)
+		if !ok {
+			return false
+		}
+		newHistory, ok := e.ObjectNew.(*devopsbeererv1beta1.ScenarioHistory)
+		if !ok {
+			return false
+		}
+		return oldHistory.Status.Phase != newHistory.Status.Phase
+	},
+}
+
+// SetupWithManager sets up the controller with the Manager. Histories outlive
+// their ActiveScenario and ScenarioDefinition, so the objects are linked by
+// the scenario ID and history labels rather than by owner references, which
+// would garbage collect the histories.
 func (r *ActiveScenarioReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Index histories by phase to look up the active one
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &devopsbeererv1beta1.ScenarioHistory{},
+		historyPhaseField, indexHistoryPhase); err != nil {
+		return err
+	}
+	// Index ScenarioRestores by ActiveScenario to find the pending ones
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &devopsbeererv1beta1.ScenarioRestore{},
+		restoreActiveScenarioField, func(obj client.Object) []string {
+			return []string{obj.(*devopsbeererv1beta1.ScenarioRestore).Spec.ActiveScenario}
+		}); err != nil {
+		return err
+	}
+	// Index ActiveScenarios by scenario ID to find those a ScenarioDefinition affects
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &devopsbeererv1beta1.ActiveScenario{},
+		scenarioIDField, func(obj client.Object) []string {
+			return []string{obj.(*devopsbeererv1beta1.ActiveScenario).Spec.ScenarioID}
+		}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&devopsbeererv1alpha1.ActiveScenario{}).
+		For(&devopsbeererv1beta1.ActiveScenario{}).
+		Watches(&devopsbeererv1beta1.ScenarioDefinition{},
+			handler.EnqueueRequestsFromMapFunc(r.activeScenariosForDefinition),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&devopsbeererv1beta1.ScenarioHistory{},
+			handler.EnqueueRequestsFromMapFunc(activeScenarioForHistory),
+			builder.WithPredicates(historyPhaseChanged)).
+		Watches(&devopsbeererv1beta1.ScenarioRestore{},
+			handler.EnqueueRequestsFromMapFunc(activeScenarioForRestore),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: 1, // Process one at a time
 		}).
@@ -0,0 +1,166 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+func TestReconcileUpdatePolicy(t *testing.T) {
+	closedWindow := &devopsbeererv1beta1.UpdateWindow{
+		Start:    "00:00",
+		Duration: metav1.Duration{Duration: time.Hour},
+		Days:     []devopsbeererv1beta1.Weekday{devopsbeererv1beta1.Weekday(time.Now().AddDate(0, 0, 2).Weekday().String())},
+	}
+
+	tests := map[string]struct {
+		policy      devopsbeererv1beta1.UpdatePolicy
+		wantPending string
+		wantUpgrade bool
+	}{
+		"auto": {
+			policy:      devopsbeererv1beta1.UpdatePolicy{Type: devopsbeererv1beta1.UpdatePolicyAuto},
+			wantUpgrade: true,
+		},
+		"manual": {
+			policy:      devopsbeererv1beta1.UpdatePolicy{Type: devopsbeererv1beta1.UpdatePolicyManual},
+			wantPending: "the update policy is Manual",
+		},
+		"outside the update window": {
+			policy:      devopsbeererv1beta1.UpdatePolicy{Type: devopsbeererv1beta1.UpdatePolicyWindow, Window: closedWindow},
+			wantPending: "waiting for the update window",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			if err := devopsbeererv1beta1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+
+			// The ActiveScenario was edited without changing its parameters,
+			// while its definition changed since the scenario was installed
+			activeScenario := &devopsbeererv1beta1.ActiveScenario{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:       "active",
+					Generation: 2,
+					Finalizers: []string{finalizerName},
+				},
+				Spec: devopsbeererv1beta1.ActiveScenarioSpec{
+					ScenarioID:     "basic-oauth2",
+					UpdatePolicy:   &tt.policy,
+					CompletedSteps: []string{"login"},
+				},
+				Status: devopsbeererv1beta1.ActiveScenarioStatus{
+					Phase:                devopsbeererv1beta1.ActiveScenarioPhaseRunning,
+					ObservedGeneration:   1,
+					DefinitionGeneration: 1,
+				},
+			}
+			scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2", Generation: 2},
+				Spec: devopsbeererv1beta1.ScenarioDefinitionSpec{
+					ID:   "basic-oauth2",
+					Name: "Basic OAuth2",
+				},
+			}
+			r := &ActiveScenarioReconciler{Recorder: record.NewFakeRecorder(10)}
+			values, err := r.renderValues(activeScenario, scenarioDef)
+			if err != nil {
+				t.Fatal(err)
+			}
+			history := &devopsbeererv1beta1.ScenarioHistory{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "history-basic-oauth2",
+					Labels: map[string]string{scenarioLabel: "basic-oauth2"},
+				},
+				Spec: devopsbeererv1beta1.ScenarioHistorySpec{
+					ScenarioID:           "basic-oauth2",
+					Namespace:            "devopsbeerer-basic-oauth2",
+					HelmRelease:          "devopsbeerer-basic-oauth2",
+					Values:               values,
+					DefinitionGeneration: 1,
+				},
+				Status: devopsbeererv1beta1.ScenarioHistoryStatus{Phase: devopsbeererv1beta1.ScenarioHistoryPhaseActive},
+			}
+
+			r.Client = fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(activeScenario, scenarioDef, history).
+				WithStatusSubresource(&devopsbeererv1beta1.ActiveScenario{}, &devopsbeererv1beta1.ScenarioHistory{}).
+				WithIndex(&devopsbeererv1beta1.ScenarioHistory{}, historyPhaseField, indexHistoryPhase).
+				WithIndex(&devopsbeererv1beta1.ScenarioRestore{}, restoreActiveScenarioField, func(obj client.Object) []string {
+					return []string{obj.(*devopsbeererv1beta1.ScenarioRestore).Spec.ActiveScenario}
+				}).
+				Build()
+
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: activeScenario.Name},
+			}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			got := &devopsbeererv1beta1.ScenarioHistory{}
+			if err := r.Get(context.Background(), types.NamespacedName{Name: history.Name}, got); err != nil {
+				t.Fatal(err)
+			}
+			if upgraded := got.Spec.DefinitionGeneration == scenarioDef.Generation; upgraded != tt.wantUpgrade {
+				t.Errorf("history at definition generation %d, want upgraded %v",
+					got.Spec.DefinitionGeneration, tt.wantUpgrade)
+			}
+
+			updated := &devopsbeererv1beta1.ActiveScenario{}
+			if err := r.Get(context.Background(), types.NamespacedName{Name: activeScenario.Name}, updated); err != nil {
+				t.Fatal(err)
+			}
+			if updated.Status.Phase != devopsbeererv1beta1.ActiveScenarioPhaseRunning ||
+				updated.Status.ObservedGeneration != updated.Generation {
+				t.Errorf("status = %s at generation %d, want Running at generation %d",
+					updated.Status.Phase, updated.Status.ObservedGeneration, updated.Generation)
+			}
+			upToDate := meta.FindStatusCondition(updated.Status.Conditions,
+				devopsbeererv1beta1.ActiveScenarioConditionUpToDate)
+			wantStatus := metav1.ConditionFalse
+			if tt.wantUpgrade {
+				wantStatus = metav1.ConditionTrue
+			}
+			if upToDate == nil || upToDate.Status != wantStatus ||
+				!strings.Contains(upToDate.Message, tt.wantPending) {
+				t.Errorf("UpToDate condition = %v, want %s with %q", upToDate, wantStatus, tt.wantPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/dependencies"
+	"github.com/devopsbeerer/operator/internal/guardrails"
+	"github.com/devopsbeerer/operator/internal/helm"
+	"github.com/devopsbeerer/operator/internal/metrics"
+	"github.com/devopsbeerer/operator/internal/naming"
+)
+
+// installPlan is what installing a scenario involves besides its main chart
+type installPlan struct {
+	// components are the shared components required, in installation order
+	components []*devopsbeererv1beta1.ScenarioDefinition
+	// charts are the additional charts, in installation order
+	charts []devopsbeererv1beta1.ScenarioChart
+	// restore is the restore the scenario is installed for, if any
+	restore *scenarioRestore
+}
+
+// planInstall resolves the shared components and the order of the
+// additional charts of a scenario
+func (r *ActiveScenarioReconciler) planInstall(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition) (*installPlan, error) {
+
+	components, err := r.resolveComponents(ctx, scenarioDef)
+	if err != nil {
+		return nil, err
+	}
+	charts, err := orderCharts(scenarioDef.Spec.Charts)
+	if err != nil {
+		return nil, err
+	}
+	return &installPlan{components: components, charts: charts}, nil
+}
+
+// orderCharts returns the charts in installation order, every chart after
+// the charts it depends on and in the listed order otherwise
+func orderCharts(charts []devopsbeererv1beta1.ScenarioChart) ([]devopsbeererv1beta1.ScenarioChart, error) {
+	byName := make(map[string]devopsbeererv1beta1.ScenarioChart, len(charts))
+	names := make([]string, 0, len(charts))
+	for _, chart := range charts {
+		byName[chart.Name] = chart
+		names = append(names, chart.Name)
+	}
+
+	// The scenario itself, with an empty name, depends on every chart
+	order, err := dependencies.Order("", func(name string) ([]string, error) {
+		if name == "" {
+			return names, nil
+		}
+		chart, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown chart '%s' in dependsOn", name)
+		}
+		return chart.DependsOn, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]devopsbeererv1beta1.ScenarioChart, 0, len(order))
+	for _, name := range order {
+		ordered = append(ordered, byName[name])
+	}
+	return ordered, nil
+}
+
+// chartNamespace names the namespace of an additional chart
+func chartNamespace(namespace, suffix string) string {
+	if suffix == "" {
+		return namespace
+	}
+	return naming.Truncate(namespace+"-"+suffix, naming.MaxNamespaceLength)
+}
+
+// chartRelease names the helm release of an additional chart
+func chartRelease(release, name string) string {
+	return naming.Truncate(release+"-"+name, naming.MaxReleaseLength)
+}
+
+// historyNamespaces returns the namespaces of a scenario, its own first
+func historyNamespaces(history *devopsbeererv1beta1.ScenarioHistory) []string {
+	namespaces := []string{history.Spec.Namespace}
+	for _, release := range history.Spec.Releases {
+		if !slices.Contains(namespaces, release.Namespace) {
+			namespaces = append(namespaces, release.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// installCharts installs or upgrades the additional charts of a scenario in
+// order, each release ready before the next chart starts, and returns their
+// releases. On failure it returns the releases installed so far, the failed
+// one included once its namespace is prepared, for them to be rolled back.
+func (r *ActiveScenarioReconciler) installCharts(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	plan *installPlan, namespace, release, serviceAccount string,
+	labels map[string]string) ([]devopsbeererv1beta1.ScenarioRelease, error) {
+
+	log := log.FromContext(ctx)
+
+	var releases []devopsbeererv1beta1.ScenarioRelease
+	for _, chart := range plan.charts {
+		scenarioRelease := devopsbeererv1beta1.ScenarioRelease{
+			Chart:     chart.Name,
+			Name:      chartRelease(release, chart.Name),
+			Namespace: chartNamespace(namespace, chart.NamespaceSuffix),
+		}
+
+		if scenarioRelease.Namespace != namespace {
+			if err := r.ensureChartNamespace(ctx, scenarioDef, plan, scenarioRelease.Namespace, labels); err != nil {
+				return releases, fmt.Errorf("chart '%s': %w", chart.Name, err)
+			}
+			if err := r.grantInstaller(ctx, scenarioDef, scenarioRelease.Namespace, serviceAccount); err != nil {
+				return append(releases, scenarioRelease), fmt.Errorf("chart '%s': %w", chart.Name, err)
+			}
+		}
+
+		source := helmChartSource(chart.Chart, scenarioDef.Spec.ID+"/"+chart.Name)
+		opts := helm.InstallOptions{}
+		if chart.Readiness != nil {
+			if chart.Readiness.Timeout != nil {
+				opts.Timeout = chart.Readiness.Timeout.Duration
+			}
+			opts.WaitForJobs = chart.Readiness.WaitForJobs
+		}
+		values := ""
+		if chart.Values != nil {
+			// JSON values are valid YAML
+			values = string(chart.Values.Raw)
+		}
+
+		helmClient := r.helmClientFor(serviceAccount)
+		if helmClient == nil {
+			releases = append(releases, scenarioRelease)
+			continue
+		}
+		scenarioRelease.SourceRevision = r.chartRevision(ctx, &source)
+		if err := r.recoverRelease(ctx, activeScenario, helmClient, scenarioRelease.Name, scenarioRelease.Namespace); err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+				helmFailureReason(metrics.ReasonRecover, err)).Inc()
+			return append(releases, scenarioRelease), fmt.Errorf("failed to recover the release of chart '%s': %w", chart.Name, err)
+		}
+		log.Info("Installing chart", "chart", chart.Name, "source", source.String(),
+			"release", scenarioRelease.Name, "namespace", scenarioRelease.Namespace)
+		if err := helmClient.Install(ctx, scenarioRelease.Name, scenarioRelease.Namespace, source, values, opts); err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+				helmFailureReason(metrics.ReasonInstall, err)).Inc()
+			return append(releases, scenarioRelease), fmt.Errorf("failed to install chart '%s': %w", chart.Name, err)
+		}
+		scenarioRelease.HelmRevision = r.helmRevision(ctx, helmClient, scenarioRelease.Name, scenarioRelease.Namespace)
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonChartInstalled,
+			"Installed chart '%s' as helm release %s in namespace %s",
+			chart.Name, scenarioRelease.Name, scenarioRelease.Namespace)
+		releases = append(releases, scenarioRelease)
+	}
+
+	return releases, nil
+}
+
+// ensureChartNamespace creates the namespace of an additional chart with the
+// guardrails of the scenario. An existing namespace is only used when the
+// operator created it for the same scenario.
+func (r *ActiveScenarioReconciler) ensureChartNamespace(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	plan *installPlan, namespace string, labels map[string]string) error {
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: labels}}
+	if err := r.Create(ctx, ns); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create namespace %s: %w", namespace, err)
+		}
+		if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return err
+		}
+		if ns.Labels[managedLabel] != "true" {
+			return fmt.Errorf("namespace %s already exists and is not managed by the operator", namespace)
+		}
+		if ns.Labels[scenarioLabel] != scenarioDef.Spec.ID {
+			return fmt.Errorf("namespace %s already exists for scenario '%s'", namespace, ns.Labels[scenarioLabel])
+		}
+		if !ns.DeletionTimestamp.IsZero() {
+			return fmt.Errorf("namespace %s is terminating", namespace)
+		}
+	}
+
+	if err := guardrails.Apply(ctx, r.Client, namespace, r.scenarioGuardrails(scenarioDef, plan),
+		labels, r.Guardrails); err != nil {
+		return fmt.Errorf("failed to apply guardrails to namespace %s: %w", namespace, err)
+	}
+	return nil
+}
+
+// uninstallCharts uninstalls releases of additional charts in reverse
+// installation order
+func (r *ActiveScenarioReconciler) uninstallCharts(ctx context.Context,
+	scenarioID, serviceAccount string, releases []devopsbeererv1beta1.ScenarioRelease) error {
+
+	helmClient := r.helmClientFor(serviceAccount)
+	if helmClient == nil {
+		return nil
+	}
+	for i := len(releases) - 1; i >= 0; i-- {
+		release := releases[i]
+		if err := helmClient.Uninstall(ctx, release.Name, release.Namespace); err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioID,
+				helmFailureReason(metrics.ReasonUninstall, err)).Inc()
+			return fmt.Errorf("failed to uninstall chart '%s': %w", release.Chart, err)
+		}
+	}
+	return nil
+}
+
+// rollbackCharts uninstalls, in reverse installation order, the releases of
+// the additional charts of a scenario whose installation failed, which no
+// history records, and deletes their namespaces other than the scenario one
+func (r *ActiveScenarioReconciler) rollbackCharts(ctx context.Context,
+	scenarioID, serviceAccount, namespace string, releases []devopsbeererv1beta1.ScenarioRelease) error {
+
+	if err := r.uninstallCharts(ctx, scenarioID, serviceAccount, releases); err != nil {
+		return err
+	}
+	for _, release := range releases {
+		if release.Namespace == namespace {
+			continue
+		}
+		if err := r.deleteNamespace(ctx, release.Namespace); err != nil {
+			return fmt.Errorf("failed to delete namespace %s: %w", release.Namespace, err)
+		}
+	}
+	return nil
+}
+
+// removeCharts uninstalls the releases an upgrade removed from a scenario,
+// comparing its previous releases to the ones of its history, and deletes the
+// namespaces no chart uses anymore
+func (r *ActiveScenarioReconciler) removeCharts(ctx context.Context,
+	history *devopsbeererv1beta1.ScenarioHistory, previous []devopsbeererv1beta1.ScenarioRelease) error {
+
+	var removed []devopsbeererv1beta1.ScenarioRelease
+	for _, release := range previous {
+		if !slices.ContainsFunc(history.Spec.Releases, func(current devopsbeererv1beta1.ScenarioRelease) bool {
+			return current.Name == release.Name && current.Namespace == release.Namespace
+		}) {
+			removed = append(removed, release)
+		}
+	}
+	if err := r.uninstallCharts(ctx, history.Spec.ScenarioID, history.Spec.ServiceAccount, removed); err != nil {
+		return err
+	}
+
+	namespaces := historyNamespaces(history)
+	for _, release := range removed {
+		if slices.Contains(namespaces, release.Namespace) {
+			continue
+		}
+		if err := r.deleteNamespace(ctx, release.Namespace); err != nil {
+			return fmt.Errorf("failed to delete namespace %s: %w", release.Namespace, err)
+		}
+	}
+	return nil
+}
+
+// chartRevision resolves the revision of a chart source, pinning a chart
+// repository source to the version it resolves to
+func (r *ActiveScenarioReconciler) chartRevision(ctx context.Context, chart *helm.ChartSource) string {
+	if r.HelmClient == nil {
+		return ""
+	}
+	revision, err := r.HelmClient.ResolveRevision(ctx, *chart)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to resolve the chart source revision", "chart", chart.String())
+		return ""
+	}
+	if chart.RepoURL != "" {
+		chart.Version = revision
+	}
+	return revision
+}
@@ -0,0 +1,376 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/dependencies"
+	"github.com/devopsbeerer/operator/internal/helm"
+	"github.com/devopsbeerer/operator/internal/metrics"
+	"github.com/devopsbeerer/operator/internal/naming"
+	"github.com/devopsbeerer/operator/internal/parameters"
+	"github.com/devopsbeerer/operator/internal/tracing"
+)
+
+// sharedLabel marks the namespace shared components are installed into
+const sharedLabel = "devopsbeerer.io/shared"
+
+// resolveComponents returns the ScenarioDefinitions of the shared components
+// a scenario requires, transitively, in installation order
+func (r *ActiveScenarioReconciler) resolveComponents(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition) ([]*devopsbeererv1beta1.ScenarioDefinition, error) {
+
+	definitions := map[string]*devopsbeererv1beta1.ScenarioDefinition{scenarioDef.Spec.ID: scenarioDef}
+	order, err := dependencies.Order(scenarioDef.Spec.ID, func(id string) ([]string, error) {
+		if definition, ok := definitions[id]; ok {
+			return definition.Spec.Requires, nil
+		}
+		definition := &devopsbeererv1beta1.ScenarioDefinition{}
+		if err := r.Get(ctx, client.ObjectKey{Name: id}, definition); err != nil {
+			if errors.IsNotFound(err) {
+				return nil, fmt.Errorf("required ScenarioDefinition '%s' not found", id)
+			}
+			return nil, err
+		}
+		definitions[id] = definition
+		return definition.Spec.Requires, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	components := make([]*devopsbeererv1beta1.ScenarioDefinition, 0, len(order))
+	for _, id := range order {
+		components = append(components, definitions[id])
+	}
+	return components, nil
+}
+
+// componentIDs returns the IDs of components
+func componentIDs(components []*devopsbeererv1beta1.ScenarioDefinition) []string {
+	if len(components) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(components))
+	for _, component := range components {
+		ids = append(ids, component.Spec.ID)
+	}
+	return ids
+}
+
+// removedComponents returns the components of previous missing from current,
+// in the order of previous
+func removedComponents(previous, current []string) []string {
+	var removed []string
+	for _, id := range previous {
+		if !slices.Contains(current, id) {
+			removed = append(removed, id)
+		}
+	}
+	return removed
+}
+
+// componentRelease names the helm release of a shared component
+func componentRelease(id string) string {
+	return naming.Truncate("devopsbeerer-"+id, naming.MaxReleaseLength)
+}
+
+// componentInstallerName names the ServiceAccount generated in the shared
+// namespace for a component, distinct for each component
+func componentInstallerName(id string) string {
+	return naming.Truncate(installerName+"-"+id, naming.MaxNamespaceLength)
+}
+
+// scenarioGuardrails returns the guardrails of a scenario namespace, letting
+// its pods reach the shared namespace when the scenario requires components,
+// and the other namespaces of the scenario when its charts span several
+func (r *ActiveScenarioReconciler) scenarioGuardrails(scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	plan *installPlan) *devopsbeererv1beta1.ScenarioGuardrails {
+
+	spansNamespaces := slices.ContainsFunc(plan.charts, func(chart devopsbeererv1beta1.ScenarioChart) bool {
+		return chart.NamespaceSuffix != ""
+	})
+	if len(plan.components) == 0 && !spansNamespaces {
+		return scenarioDef.Spec.Guardrails
+	}
+	scenarioGuardrails := scenarioDef.Spec.Guardrails.DeepCopy()
+	if scenarioGuardrails == nil {
+		scenarioGuardrails = &devopsbeererv1beta1.ScenarioGuardrails{}
+	}
+	if len(plan.components) > 0 {
+		scenarioGuardrails.Egress = append(scenarioGuardrails.Egress, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{corev1.LabelMetadataName: r.SharedNamespace},
+				},
+			}},
+		})
+	}
+	if spansNamespaces {
+		scenarioNamespaces := []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					scenarioLabel: scenarioDef.Spec.ID,
+					managedLabel:  "true",
+				},
+			},
+		}}
+		scenarioGuardrails.Ingress = append(scenarioGuardrails.Ingress,
+			networkingv1.NetworkPolicyIngressRule{From: scenarioNamespaces})
+		scenarioGuardrails.Egress = append(scenarioGuardrails.Egress,
+			networkingv1.NetworkPolicyEgressRule{To: scenarioNamespaces})
+	}
+	return scenarioGuardrails
+}
+
+// installComponents installs the shared components missing from the shared
+// namespace, in order. Components already deployed are left as they are.
+func (r *ActiveScenarioReconciler) installComponents(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	components []*devopsbeererv1beta1.ScenarioDefinition) (err error) {
+
+	if len(components) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "installComponents", tracing.AttrNamespace.String(r.SharedNamespace))
+	defer func() { tracing.End(span, err) }()
+
+	if err := r.ensureSharedNamespace(ctx); err != nil {
+		return err
+	}
+
+	for _, component := range components {
+		if r.RequireServiceAccount && component.Spec.ServiceAccount == nil {
+			return fmt.Errorf("ScenarioDefinition '%s' must name a ServiceAccount to install its chart as",
+				component.Name)
+		}
+		serviceAccount, err := r.ensureServiceAccount(ctx, component, r.SharedNamespace,
+			componentInstallerName(component.Spec.ID))
+		if err != nil {
+			return fmt.Errorf("failed to prepare service account of component '%s': %w", component.Spec.ID, err)
+		}
+		helmClient := r.helmClientFor(serviceAccount)
+		if helmClient == nil {
+			continue
+		}
+
+		release := componentRelease(component.Spec.ID)
+		info, err := helmClient.Release(ctx, release, r.SharedNamespace)
+		if err == nil && info.Status == "deployed" {
+			continue
+		}
+		if err != nil && !goerrors.Is(err, helm.ErrReleaseNotFound) {
+			return fmt.Errorf("failed to get the release of component '%s': %w", component.Spec.ID, err)
+		}
+
+		values, err := componentValues(component)
+		if err != nil {
+			return fmt.Errorf("invalid default parameters of component '%s': %w", component.Spec.ID, err)
+		}
+		if err := r.recoverRelease(ctx, activeScenario, helmClient, release, r.SharedNamespace); err != nil {
+			metrics.HelmFailures.WithLabelValues(component.Spec.ID,
+				helmFailureReason(metrics.ReasonRecover, err)).Inc()
+			return fmt.Errorf("failed to recover the release of component '%s': %w", component.Spec.ID, err)
+		}
+
+		log.FromContext(ctx).Info("Installing shared component", "component", component.Spec.ID, "release", release)
+		chart := chartSource(component)
+		if err := helmClient.Install(ctx, release, r.SharedNamespace, chart, values, helm.InstallOptions{}); err != nil {
+			metrics.HelmFailures.WithLabelValues(component.Spec.ID,
+				helmFailureReason(metrics.ReasonInstall, err)).Inc()
+			return fmt.Errorf("failed to install component '%s': %w", component.Spec.ID, err)
+		}
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonComponentInstalled,
+			"Installed shared component '%s' as helm release %s in namespace %s",
+			component.Spec.ID, release, r.SharedNamespace)
+	}
+
+	return nil
+}
+
+// componentValues renders the Helm values of a component from the defaults
+// of its parameters
+func componentValues(component *devopsbeererv1beta1.ScenarioDefinition) (string, error) {
+	values, err := parameters.Resolve(component.Spec.Parameters, nil)
+	if err != nil {
+		return "", err
+	}
+	if component.Spec.ValuesSchema != nil {
+		if err := parameters.Validate(component.Spec.ValuesSchema.Raw, values); err != nil {
+			return "", err
+		}
+	}
+	return parameters.Render(values)
+}
+
+// ensureSharedNamespace creates the namespace of shared components, refusing
+// to use a namespace the operator did not create
+func (r *ActiveScenarioReconciler) ensureSharedNamespace(ctx context.Context) error {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: r.SharedNamespace,
+			Labels: map[string]string{
+				managedLabel: "true",
+				sharedLabel:  "true",
+			},
+		},
+	}
+	err := r.Create(ctx, ns)
+	if err == nil || !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	if err := r.Get(ctx, client.ObjectKey{Name: r.SharedNamespace}, ns); err != nil {
+		return err
+	}
+	if ns.Labels[managedLabel] != "true" {
+		return fmt.Errorf("namespace %s already exists and is not managed by the operator", r.SharedNamespace)
+	}
+	if !ns.DeletionTimestamp.IsZero() {
+		return fmt.Errorf("shared namespace %s is terminating", r.SharedNamespace)
+	}
+	return nil
+}
+
+// releaseComponents uninstalls, in reverse installation order, the components
+// of a history that no other active or terminating history requires, nor the
+// scenario replacing it
+func (r *ActiveScenarioReconciler) releaseComponents(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	history *devopsbeererv1beta1.ScenarioHistory, components []string, successor string) (err error) {
+
+	if len(components) == 0 {
+		return nil
+	}
+
+	ctx, span := tracing.Start(ctx, "releaseComponents", tracing.AttrNamespace.String(r.SharedNamespace))
+	defer func() { tracing.End(span, err) }()
+
+	log := log.FromContext(ctx)
+
+	// Count the references of the other installed scenarios
+	required := map[string]bool{}
+	for _, phase := range []devopsbeererv1beta1.ScenarioHistoryPhase{
+		devopsbeererv1beta1.ScenarioHistoryPhaseActive,
+		devopsbeererv1beta1.ScenarioHistoryPhaseTerminating,
+	} {
+		historyList := &devopsbeererv1beta1.ScenarioHistoryList{}
+		if err := r.List(ctx, historyList, client.MatchingFields{historyPhaseField: string(phase)}); err != nil {
+			return err
+		}
+		for _, other := range historyList.Items {
+			if other.Name == history.Name {
+				continue
+			}
+			for _, id := range other.Spec.Components {
+				required[id] = true
+			}
+		}
+	}
+
+	// Keep the components the next scenario installs again
+	if successor != "" {
+		successorDef := &devopsbeererv1beta1.ScenarioDefinition{}
+		if err := r.Get(ctx, client.ObjectKey{Name: successor}, successorDef); err == nil {
+			successorComponents, err := r.resolveComponents(ctx, successorDef)
+			if err != nil {
+				log.Error(err, "Failed to resolve the components of the next scenario", "scenarioId", successor)
+			}
+			for _, id := range componentIDs(successorComponents) {
+				required[id] = true
+			}
+		} else if !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	for i := len(components) - 1; i >= 0; i-- {
+		id := components[i]
+		if required[id] {
+			continue
+		}
+
+		release := componentRelease(id)
+		log.Info("Uninstalling shared component", "component", id, "release", release)
+		serviceAccount, err := r.componentServiceAccount(ctx, id)
+		if err != nil {
+			return err
+		}
+		if helmClient := r.helmClientFor(serviceAccount); helmClient != nil {
+			if err := helmClient.Uninstall(ctx, release, r.SharedNamespace); err != nil {
+				metrics.HelmFailures.WithLabelValues(id,
+					helmFailureReason(metrics.ReasonUninstall, err)).Inc()
+				return fmt.Errorf("failed to uninstall component '%s': %w", id, err)
+			}
+		}
+		if err := r.deleteComponentInstaller(ctx, id); err != nil {
+			return fmt.Errorf("failed to delete the service account of component '%s': %w", id, err)
+		}
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonComponentUninstalled,
+			"Uninstalled shared component '%s' no longer required", id)
+	}
+
+	return nil
+}
+
+// componentServiceAccount returns the ServiceAccount the release of a
+// component is managed as, or the operator itself when its definition is gone
+func (r *ActiveScenarioReconciler) componentServiceAccount(ctx context.Context, id string) (string, error) {
+	component := &devopsbeererv1beta1.ScenarioDefinition{}
+	if err := r.Get(ctx, client.ObjectKey{Name: id}, component); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	serviceAccount := component.Spec.ServiceAccount
+	switch {
+	case serviceAccount == nil:
+		return "", nil
+	case serviceAccount.Name != "":
+		return serviceAccount.Namespace + "/" + serviceAccount.Name, nil
+	default:
+		return r.SharedNamespace + "/" + componentInstallerName(id), nil
+	}
+}
+
+// deleteComponentInstaller deletes the ServiceAccount, Role and RoleBinding
+// generated for a component
+func (r *ActiveScenarioReconciler) deleteComponentInstaller(ctx context.Context, id string) error {
+	meta := metav1.ObjectMeta{Name: componentInstallerName(id), Namespace: r.SharedNamespace}
+	for _, obj := range []client.Object{
+		&rbacv1.RoleBinding{ObjectMeta: meta},
+		&rbacv1.Role{ObjectMeta: meta},
+		&corev1.ServiceAccount{ObjectMeta: meta},
+	} {
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
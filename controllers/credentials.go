@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/credentials"
+)
+
+// credentialsSecretName names the Secret holding the credentials generated
+// for a scenario in its namespace
+const credentialsSecretName = "scenario-credentials"
+
+// ensureCredentials generates the credentials declared by a scenario into the
+// Secret of its namespace and records the Secret on the ActiveScenario.
+// Credentials already generated are kept unless rotate is set, credentials no
+// longer declared are dropped.
+func (r *ActiveScenarioReconciler) ensureCredentials(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	namespace string, rotate bool) error {
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: credentialsSecretName}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get credentials secret: %w", err)
+	}
+	exists := err == nil
+
+	if len(scenarioDef.Spec.Credentials) == 0 {
+		activeScenario.Status.CredentialsSecret = nil
+		if exists {
+			if err := r.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete credentials secret: %w", err)
+			}
+		}
+		return nil
+	}
+
+	data := map[string][]byte{}
+	for _, credential := range scenarioDef.Spec.Credentials {
+		keys := credentials.Keys(credential)
+		kept := exists && !rotate
+		for _, key := range keys {
+			if _, ok := secret.Data[key]; !ok {
+				kept = false
+			}
+		}
+		if kept {
+			for _, key := range keys {
+				data[key] = secret.Data[key]
+			}
+			continue
+		}
+		generated, err := credentials.Generate(credential)
+		if err != nil {
+			return fmt.Errorf("credential %q: %w", credential.Name, err)
+		}
+		for key, value := range generated {
+			data[key] = value
+		}
+	}
+
+	secret.Labels = map[string]string{
+		scenarioLabel: scenarioDef.Spec.ID,
+		managedLabel:  "true",
+	}
+	secret.Type = corev1.SecretTypeOpaque
+	secret.Data = data
+	if exists {
+		if err := r.Update(ctx, secret); err != nil {
+			return fmt.Errorf("failed to update credentials secret: %w", err)
+		}
+	} else {
+		secret.ObjectMeta = metav1.ObjectMeta{
+			Name:      credentialsSecretName,
+			Namespace: namespace,
+			Labels:    secret.Labels,
+		}
+		if err := r.Create(ctx, secret); err != nil {
+			return fmt.Errorf("failed to create credentials secret: %w", err)
+		}
+	}
+
+	activeScenario.Status.CredentialsSecret = &corev1.SecretReference{
+		Name:      credentialsSecretName,
+		Namespace: namespace,
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// endpointPurposeAnnotation sets the purpose of the endpoints discovered from
+// an Ingress, an HTTPRoute or a Service
+const endpointPurposeAnnotation = "devopsbeerer.io/endpoint-purpose"
+
+// httpRouteListGVK is the Gateway API HTTPRoute list, read as unstructured so
+// that clusters without the Gateway API are supported
+var httpRouteListGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1",
+	Kind:    "HTTPRouteList",
+}
+
+// discoverEndpoints returns the endpoints declared by the definition of a
+// scenario followed by the endpoints discovered in its namespaces
+func (r *ActiveScenarioReconciler) discoverEndpoints(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	history *devopsbeererv1beta1.ScenarioHistory) ([]devopsbeererv1beta1.EndpointStatus, error) {
+
+	var endpoints []devopsbeererv1beta1.EndpointStatus
+	for _, declared := range scenarioDef.Spec.Endpoints {
+		endpoints = append(endpoints, devopsbeererv1beta1.EndpointStatus{
+			Name:    declared.Name,
+			URL:     declared.URL,
+			Purpose: declared.Purpose,
+			Source:  devopsbeererv1beta1.EndpointSourceDeclared,
+		})
+	}
+
+	var discovered []devopsbeererv1beta1.EndpointStatus
+	nodeAddress := ""
+	for _, namespace := range historyNamespaces(history) {
+		ingresses := &networkingv1.IngressList{}
+		if err := r.List(ctx, ingresses, client.InNamespace(namespace)); err != nil {
+			return nil, fmt.Errorf("failed to list ingresses: %w", err)
+		}
+		for i := range ingresses.Items {
+			discovered = append(discovered, ingressEndpoints(&ingresses.Items[i])...)
+		}
+
+		routes := &unstructured.UnstructuredList{}
+		routes.SetGroupVersionKind(httpRouteListGVK)
+		if err := r.List(ctx, routes, client.InNamespace(namespace)); err != nil && !meta.IsNoMatchError(err) {
+			return nil, fmt.Errorf("failed to list HTTPRoutes: %w", err)
+		}
+		for i := range routes.Items {
+			discovered = append(discovered, httpRouteEndpoints(&routes.Items[i])...)
+		}
+
+		services := &corev1.ServiceList{}
+		if err := r.List(ctx, services, client.InNamespace(namespace)); err != nil {
+			return nil, fmt.Errorf("failed to list services: %w", err)
+		}
+		for i := range services.Items {
+			service := &services.Items[i]
+			if service.Spec.Type == corev1.ServiceTypeNodePort && nodeAddress == "" {
+				address, err := r.nodeAddress(ctx)
+				if err != nil {
+					return nil, err
+				}
+				nodeAddress = address
+			}
+			discovered = append(discovered, serviceEndpoints(service, nodeAddress)...)
+		}
+	}
+
+	slices.SortStableFunc(discovered, func(a, b devopsbeererv1beta1.EndpointStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return append(endpoints, discovered...), nil
+}
+
+// nodeAddress returns the address NodePort endpoints are published at, the
+// configured one or else the external, or internal, address of a node
+func (r *ActiveScenarioReconciler) nodeAddress(ctx context.Context) (string, error) {
+	if r.NodeAddress != "" {
+		return r.NodeAddress, nil
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	slices.SortFunc(nodes.Items, func(a, b corev1.Node) int { return strings.Compare(a.Name, b.Name) })
+	for _, addressType := range []corev1.NodeAddressType{corev1.NodeExternalIP, corev1.NodeInternalIP} {
+		for _, node := range nodes.Items {
+			for _, address := range node.Status.Addresses {
+				if address.Type == addressType && address.Address != "" {
+					return address.Address, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// ingressEndpoints returns an endpoint for every host of an Ingress, or for
+// its load balancer address when its rules name no host
+func ingressEndpoints(ingress *networkingv1.Ingress) []devopsbeererv1beta1.EndpointStatus {
+	purpose := ingress.Annotations[endpointPurposeAnnotation]
+
+	var tlsHosts []string
+	for _, tls := range ingress.Spec.TLS {
+		tlsHosts = append(tlsHosts, tls.Hosts...)
+	}
+
+	var urls []string
+	for _, rule := range ingress.Spec.Rules {
+		host := rule.Host
+		if host == "" {
+			host = ingressAddress(ingress)
+		}
+		if host == "" || strings.HasPrefix(host, "*") {
+			continue
+		}
+		scheme := "http"
+		if slices.Contains(tlsHosts, rule.Host) {
+			scheme = "https"
+		}
+		path := "/"
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 && rule.HTTP.Paths[0].Path != "" {
+			path = rule.HTTP.Paths[0].Path
+		}
+		url := scheme + "://" + host + path
+		if !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+
+	return namedEndpoints(ingress.Name, urls, purpose, devopsbeererv1beta1.EndpointSourceIngress)
+}
+
+// httpRouteEndpoints returns an endpoint for every hostname of an HTTPRoute
+func httpRouteEndpoints(route *unstructured.Unstructured) []devopsbeererv1beta1.EndpointStatus {
+	hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+	path := "/"
+	if rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules"); len(rules) > 0 {
+		if rule, ok := rules[0].(map[string]any); ok {
+			if matches, _, _ := unstructured.NestedSlice(rule, "matches"); len(matches) > 0 {
+				if match, ok := matches[0].(map[string]any); ok {
+					if value, _, _ := unstructured.NestedString(match, "path", "value"); value != "" {
+						path = value
+					}
+				}
+			}
+		}
+	}
+
+	var urls []string
+	for _, hostname := range hostnames {
+		if strings.HasPrefix(hostname, "*") {
+			continue
+		}
+		urls = append(urls, "http://"+hostname+path)
+	}
+
+	return namedEndpoints(route.GetName(), urls, route.GetAnnotations()[endpointPurposeAnnotation],
+		devopsbeererv1beta1.EndpointSourceHTTPRoute)
+}
+
+// serviceEndpoints returns an endpoint for every port of a LoadBalancer
+// Service with an address, or of a NodePort Service
+func serviceEndpoints(service *corev1.Service, nodeAddress string) []devopsbeererv1beta1.EndpointStatus {
+	var host string
+	var source devopsbeererv1beta1.EndpointSource
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		host = loadBalancerAddress(service)
+		source = devopsbeererv1beta1.EndpointSourceLoadBalancer
+	case corev1.ServiceTypeNodePort:
+		host = nodeAddress
+		source = devopsbeererv1beta1.EndpointSourceNodePort
+	}
+	if host == "" {
+		return nil
+	}
+
+	var urls []string
+	for _, port := range service.Spec.Ports {
+		number := port.Port
+		if source == devopsbeererv1beta1.EndpointSourceNodePort {
+			number = port.NodePort
+		}
+		if number == 0 {
+			continue
+		}
+		urls = append(urls, portScheme(port)+"://"+net.JoinHostPort(host, strconv.Itoa(int(number))))
+	}
+
+	return namedEndpoints(service.Name, urls, service.Annotations[endpointPurposeAnnotation], source)
+}
+
+// portScheme guesses the URL scheme of a Service port from its application
+// protocol, name and number
+func portScheme(port corev1.ServicePort) string {
+	protocol := strings.ToLower(port.Name)
+	if port.AppProtocol != nil {
+		protocol = strings.ToLower(*port.AppProtocol)
+	}
+	switch {
+	case port.Protocol == corev1.ProtocolUDP:
+		return "udp"
+	case strings.Contains(protocol, "https") || port.Port == 443:
+		return "https"
+	case strings.Contains(protocol, "http") || port.Port == 80 || port.Port == 8080:
+		return "http"
+	}
+	return "tcp"
+}
+
+// ingressAddress returns the first load balancer address of an Ingress
+func ingressAddress(ingress *networkingv1.Ingress) string {
+	for _, address := range ingress.Status.LoadBalancer.Ingress {
+		if address.Hostname != "" {
+			return address.Hostname
+		}
+		if address.IP != "" {
+			return address.IP
+		}
+	}
+	return ""
+}
+
+// loadBalancerAddress returns the first load balancer address of a Service
+func loadBalancerAddress(service *corev1.Service) string {
+	for _, address := range service.Status.LoadBalancer.Ingress {
+		if address.Hostname != "" {
+			return address.Hostname
+		}
+		if address.IP != "" {
+			return address.IP
+		}
+	}
+	return ""
+}
+
+// namedEndpoints names the endpoints of a resource after it, suffixed with
+// their index when it exposes several
+func namedEndpoints(name string, urls []string, purpose string,
+	source devopsbeererv1beta1.EndpointSource) []devopsbeererv1beta1.EndpointStatus {
+
+	endpoints := make([]devopsbeererv1beta1.EndpointStatus, 0, len(urls))
+	for i, url := range urls {
+		endpointName := name
+		if len(urls) > 1 {
+			endpointName = fmt.Sprintf("%s-%d", name, i)
+		}
+		endpoints = append(endpoints, devopsbeererv1beta1.EndpointStatus{
+			Name:    endpointName,
+			URL:     url,
+			Purpose: purpose,
+			Source:  source,
+		})
+	}
+	return endpoints
+}
+
+// refreshEndpoints records the endpoints of the running scenario on the
+// ActiveScenario, persisted with its next status update. Endpoints that cannot
+// be discovered keep their last known value.
+func (r *ActiveScenarioReconciler) refreshEndpoints(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	history *devopsbeererv1beta1.ScenarioHistory) {
+
+	endpoints, err := r.discoverEndpoints(ctx, scenarioDef, history)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to discover endpoints")
+		return
+	}
+	activeScenario.Status.Endpoints = endpoints
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import "unicode/utf8"
+
+// Event reasons emitted on ActiveScenario and ScenarioHistory objects
+const (
+	EventReasonDefinitionResolved   = "DefinitionResolved"
+	EventReasonDefinitionNotFound   = "DefinitionNotFound"
+	EventReasonInvalidParameters    = "InvalidParameters"
+	EventReasonInvalidDependencies  = "InvalidDependencies"
+	EventReasonNamespaceCreated     = "NamespaceCreated"
+	EventReasonNamespaceFailed      = "NamespaceFailed"
+	EventReasonNamespaceConflict    = "NamespaceConflict"
+	EventReasonNamespaceStuck       = "NamespaceStuck"
+	EventReasonInvalidName          = "InvalidName"
+	EventReasonGuardrailsFailed     = "GuardrailsFailed"
+	EventReasonServiceAccountFailed = "ServiceAccountFailed"
+	EventReasonCredentialsFailed    = "CredentialsFailed"
+	EventReasonInstallStarted       = "InstallStarted"
+	EventReasonInstallSucceeded     = "InstallSucceeded"
+	EventReasonInstallFailed        = "InstallFailed"
+	EventReasonReleaseRecovered     = "ReleaseRecovered"
+	EventReasonChartInstalled       = "ChartInstalled"
+	EventReasonStepCompleted        = "StepCompleted"
+	EventReasonHookSucceeded        = "HookSucceeded"
+	EventReasonHookFailed           = "HookFailed"
+	EventReasonComponentInstalled   = "ComponentInstalled"
+	EventReasonComponentUninstalled = "ComponentUninstalled"
+	EventReasonComponentFailed      = "ComponentFailed"
+	EventReasonUpdateAvailable      = "UpdateAvailable"
+	EventReasonUpgradeStarted       = "UpgradeStarted"
+	EventReasonUpgradeSucceeded     = "UpgradeSucceeded"
+	EventReasonUpgradeFailed        = "UpgradeFailed"
+	EventReasonResetStarted         = "ResetStarted"
+	EventReasonUninstallStarted     = "UninstallStarted"
+	EventReasonUninstallSucceeded   = "UninstallSucceeded"
+	EventReasonUninstallFailed      = "UninstallFailed"
+	EventReasonHistoryCreated       = "HistoryCreated"
+	EventReasonHistoryArchived      = "HistoryArchived"
+	EventReasonHistoryFailed        = "HistoryFailed"
+)
+
+// Event reasons emitted on ScenarioCatalog objects
+const (
+	EventReasonCatalogSyncFailed = "CatalogSyncFailed"
+	EventReasonEntryFailed       = "EntryFailed"
+	EventReasonDefinitionCreated = "DefinitionCreated"
+	EventReasonDefinitionUpdated = "DefinitionUpdated"
+	EventReasonDefinitionPruned  = "DefinitionPruned"
+)
+
+// Event reasons emitted on ScenarioCheck objects
+const (
+	EventReasonCheckPassed    = "CheckPassed"
+	EventReasonCheckFailed    = "CheckFailed"
+	EventReasonScenarioGraded = "ScenarioGraded"
+	EventReasonGradingFailed  = "GradingFailed"
+)
+
+// Event reasons emitted on ScenarioSnapshot and ScenarioRestore objects
+const (
+	EventReasonSnapshotReady    = "SnapshotReady"
+	EventReasonSnapshotFailed   = "SnapshotFailed"
+	EventReasonRestoreStarted   = "RestoreStarted"
+	EventReasonRestoreCompleted = "RestoreCompleted"
+	EventReasonRestoreFailed    = "RestoreFailed"
+)
+
+// maxEventMessageLength keeps event messages, which may carry Helm output,
+// well below the 1024 character limit of the Event API
+const maxEventMessageLength = 512
+
+// truncateMessage shortens a message to fit in an event, cutting it before a
+// rune so that it stays valid UTF-8
+func truncateMessage(message string) string {
+	if len(message) <= maxEventMessageLength {
+		return message
+	}
+	end := maxEventMessageLength - 3
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+	return message[:end] + "..."
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateMessage(t *testing.T) {
+	tests := map[string]struct {
+		message string
+		want    string
+	}{
+		"short": {
+			message: "Installed",
+			want:    "Installed",
+		},
+		"ascii": {
+			message: strings.Repeat("a", maxEventMessageLength+1),
+			want:    strings.Repeat("a", maxEventMessageLength-3) + "...",
+		},
+		// The rune at the cut spans bytes 508 to 510 and is dropped whole
+		"multi-byte rune at the cut": {
+			message: strings.Repeat("a", maxEventMessageLength-4) + strings.Repeat("€", 10),
+			want:    strings.Repeat("a", maxEventMessageLength-4) + "...",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := truncateMessage(tt.message)
+			if got != tt.want {
+				t.Errorf("truncateMessage() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxEventMessageLength {
+				t.Errorf("truncateMessage() = %q, not valid UTF-8 within %d bytes", got, maxEventMessageLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+const (
+	// historyPhaseField indexes ScenarioHistory objects by phase in the
+	// manager cache
+	historyPhaseField = "status.phase"
+	// scenarioIDField indexes ActiveScenario objects by requested scenario
+	scenarioIDField = "spec.scenarioId"
+
+	// activeScenarioLabel holds the name of the ActiveScenario a history
+	// was installed for
+	activeScenarioLabel = "devopsbeerer.io/active-scenario"
+	// phaseLabel mirrors the phase of a history
+	phaseLabel = "devopsbeerer.io/phase"
+)
+
+// indexHistoryPhase is the field indexer of historyPhaseField
+func indexHistoryPhase(obj client.Object) []string {
+	history, ok := obj.(*devopsbeererv1beta1.ScenarioHistory)
+	if !ok || history.Status.Phase == "" {
+		return nil
+	}
+	return []string{string(history.Status.Phase)}
+}
+
+// findActiveScenarioHistory finds the currently active scenario from history,
+// including a scenario whose namespace is still terminating. Histories are
+// looked up by phase through the cache index, so that the lookup does not
+// grow with the number of archived histories.
+func (r *ActiveScenarioReconciler) findActiveScenarioHistory(ctx context.Context) (*devopsbeererv1beta1.ScenarioHistory, error) {
+	for _, phase := range []devopsbeererv1beta1.ScenarioHistoryPhase{
+		devopsbeererv1beta1.ScenarioHistoryPhaseActive,
+		devopsbeererv1beta1.ScenarioHistoryPhaseTerminating,
+	} {
+		historyList := &devopsbeererv1beta1.ScenarioHistoryList{}
+		if err := r.List(ctx, historyList, client.MatchingFields{historyPhaseField: string(phase)}); err != nil {
+			return nil, err
+		}
+		if len(historyList.Items) > 0 {
+			return &historyList.Items[0], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// runningHistory returns the active history of the scenario of an
+// ActiveScenario, if any
+func runningHistory(ctx context.Context, c client.Reader,
+	activeScenario *devopsbeererv1beta1.ActiveScenario) (*devopsbeererv1beta1.ScenarioHistory, error) {
+
+	historyList := &devopsbeererv1beta1.ScenarioHistoryList{}
+	if err := c.List(ctx, historyList, client.MatchingFields{
+		historyPhaseField: string(devopsbeererv1beta1.ScenarioHistoryPhaseActive),
+	}); err != nil {
+		return nil, err
+	}
+	for i := range historyList.Items {
+		history := &historyList.Items[i]
+		if history.Spec.ScenarioID != activeScenario.Spec.ScenarioID {
+			continue
+		}
+		if name := history.Labels[activeScenarioLabel]; name != "" && name != activeScenario.Name {
+			continue
+		}
+		return history, nil
+	}
+	return nil, nil
+}
+
+// historyLabels returns the labels a history should carry, keeping the
+// ActiveScenario label it was created with
+func historyLabels(history *devopsbeererv1beta1.ScenarioHistory) map[string]string {
+	labels := map[string]string{
+		managedLabel:  "true",
+		scenarioLabel: history.Spec.ScenarioID,
+	}
+	if history.Status.Phase != "" {
+		labels[phaseLabel] = string(history.Status.Phase)
+	}
+	if activeScenario := history.Labels[activeScenarioLabel]; activeScenario != "" {
+		labels[activeScenarioLabel] = activeScenario
+	}
+	return labels
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// indexedClient serves ScenarioHistory lists from a client-go indexer, as the
+// manager cache does, answering field selectors on the phase from the index
+// and copying the objects it returns. Every other request goes to the
+// embedded client.
+type indexedClient struct {
+	client.Client
+	indexer toolscache.Indexer
+}
+
+// newIndexedClient returns a client holding the given objects, and archived
+// histories with a last one in the given phase in its indexer
+func newIndexedClient(histories int, phase devopsbeererv1beta1.ScenarioHistoryPhase,
+	objs ...client.Object) (*indexedClient, error) {
+
+	scheme := runtime.NewScheme()
+	if err := devopsbeererv1beta1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		historyPhaseField: func(obj interface{}) ([]string, error) {
+			return indexHistoryPhase(obj.(client.Object)), nil
+		},
+	})
+
+	installedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < histories; i++ {
+		historyPhase := devopsbeererv1beta1.ScenarioHistoryPhaseArchived
+		if i == histories-1 {
+			historyPhase = phase
+		}
+		scenarioID := fmt.Sprintf("scenario-%d", i%50)
+		if err := indexer.Add(&devopsbeererv1beta1.ScenarioHistory{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("history-%s-%d", scenarioID, installedAt.Unix()+int64(i)),
+				Labels: map[string]string{
+					scenarioLabel: scenarioID,
+					phaseLabel:    string(historyPhase),
+				},
+			},
+			Spec: devopsbeererv1beta1.ScenarioHistorySpec{
+				ScenarioID:           scenarioID,
+				Namespace:            "devopsbeerer-" + scenarioID,
+				HelmRelease:          "devopsbeerer-" + scenarioID,
+				InstalledAt:          metav1.NewTime(installedAt.Add(time.Duration(i) * time.Minute)),
+				Values:               "replicas: 1\n",
+				DefinitionGeneration: 1,
+			},
+			Status: devopsbeererv1beta1.ScenarioHistoryStatus{Phase: historyPhase},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &indexedClient{
+		Client: fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(objs...).
+			WithStatusSubresource(&devopsbeererv1beta1.ActiveScenario{}).
+			WithIndex(&devopsbeererv1beta1.ScenarioRestore{}, restoreActiveScenarioField, func(obj client.Object) []string {
+				return []string{obj.(*devopsbeererv1beta1.ScenarioRestore).Spec.ActiveScenario}
+			}).
+			Build(),
+		indexer: indexer,
+	}, nil
+}
+
+func (c *indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	historyList, ok := list.(*devopsbeererv1beta1.ScenarioHistoryList)
+	if !ok {
+		return c.Client.List(ctx, list, opts...)
+	}
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	var objs []interface{}
+	if listOpts.FieldSelector == nil {
+		objs = c.indexer.List()
+	} else {
+		value, ok := listOpts.FieldSelector.RequiresExactMatch(historyPhaseField)
+		if !ok {
+			return fmt.Errorf("field selector %s is not indexed", listOpts.FieldSelector)
+		}
+		var err error
+		if objs, err = c.indexer.ByIndex(historyPhaseField, value); err != nil {
+			return err
+		}
+	}
+
+	historyList.Items = make([]devopsbeererv1beta1.ScenarioHistory, 0, len(objs))
+	for _, obj := range objs {
+		historyList.Items = append(historyList.Items, *obj.(*devopsbeererv1beta1.ScenarioHistory).DeepCopy())
+	}
+	return nil
+}
+
+func TestFindActiveScenarioHistory(t *testing.T) {
+	tests := map[string]struct {
+		phase devopsbeererv1beta1.ScenarioHistoryPhase
+		want  bool
+	}{
+		"active":      {phase: devopsbeererv1beta1.ScenarioHistoryPhaseActive, want: true},
+		"terminating": {phase: devopsbeererv1beta1.ScenarioHistoryPhaseTerminating, want: true},
+		"archived":    {phase: devopsbeererv1beta1.ScenarioHistoryPhaseArchived},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c, err := newIndexedClient(10, tt.phase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &ActiveScenarioReconciler{Client: c}
+			history, err := r.findActiveScenarioHistory(context.Background())
+			if err != nil {
+				t.Fatalf("findActiveScenarioHistory() error = %v", err)
+			}
+			if got := history != nil; got != tt.want {
+				t.Fatalf("findActiveScenarioHistory() = %v, want found %v", history, tt.want)
+			}
+			if history != nil && history.Status.Phase != tt.phase {
+				t.Errorf("phase = %s, want %s", history.Status.Phase, tt.phase)
+			}
+		})
+	}
+}
+
+// BenchmarkFindActiveScenarioHistory measures the active history lookup every
+// reconciliation starts with, served from an indexer as by the manager cache.
+// The indexed lookup stays flat as archived histories accumulate, while
+// listing every history grows with their number.
+func BenchmarkFindActiveScenarioHistory(b *testing.B) {
+	for _, histories := range []int{100, 1000, 10000} {
+		c, err := newIndexedClient(histories, devopsbeererv1beta1.ScenarioHistoryPhaseActive)
+		if err != nil {
+			b.Fatal(err)
+		}
+		r := &ActiveScenarioReconciler{Client: c}
+		ctx := context.Background()
+
+		b.Run(fmt.Sprintf("indexed/histories=%d", histories), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				history, err := r.findActiveScenarioHistory(ctx)
+				if err != nil || history == nil {
+					b.Fatalf("active history not found: %v", err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("list/histories=%d", histories), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				historyList := &devopsbeererv1beta1.ScenarioHistoryList{}
+				if err := r.List(ctx, historyList); err != nil {
+					b.Fatal(err)
+				}
+				found := false
+				for j := range historyList.Items {
+					if historyList.Items[j].Status.Phase == devopsbeererv1beta1.ScenarioHistoryPhaseActive {
+						found = true
+						break
+					}
+				}
+				if !found {
+					b.Fatal("active history not found")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReconcileRunning measures the reconciliation of a running scenario
+// that did not change, the one repeated for every ActiveScenario every few
+// minutes. Its cost and allocations stay flat as archived histories
+// accumulate, since it only reads the active history from the index.
+func BenchmarkReconcileRunning(b *testing.B) {
+	for _, histories := range []int{100, 1000, 10000} {
+		activeScenario := &devopsbeererv1beta1.ActiveScenario{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "active",
+				Generation: 1,
+				Finalizers: []string{finalizerName},
+			},
+			Spec: devopsbeererv1beta1.ActiveScenarioSpec{
+				ScenarioID:   fmt.Sprintf("scenario-%d", (histories-1)%50),
+				UpdatePolicy: &devopsbeererv1beta1.UpdatePolicy{Type: devopsbeererv1beta1.UpdatePolicyManual},
+			},
+			Status: devopsbeererv1beta1.ActiveScenarioStatus{
+				Phase:                devopsbeererv1beta1.ActiveScenarioPhaseRunning,
+				ObservedGeneration:   1,
+				DefinitionGeneration: 1,
+			},
+		}
+		scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: activeScenario.Spec.ScenarioID, Generation: 1},
+			Spec:       devopsbeererv1beta1.ScenarioDefinitionSpec{ID: activeScenario.Spec.ScenarioID},
+		}
+		c, err := newIndexedClient(histories, devopsbeererv1beta1.ScenarioHistoryPhaseActive,
+			activeScenario, scenarioDef)
+		if err != nil {
+			b.Fatal(err)
+		}
+		r := &ActiveScenarioReconciler{Client: c, Recorder: &record.FakeRecorder{}}
+		req := ctrl.Request{NamespacedName: types.NamespacedName{Name: activeScenario.Name}}
+		ctx := ctrl.LoggerInto(context.Background(), zap.New(zap.WriteTo(io.Discard)))
+
+		b.Run(fmt.Sprintf("histories=%d", histories), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				result, err := r.Reconcile(ctx, req)
+				if err != nil || result.RequeueAfter == 0 {
+					b.Fatalf("Reconcile() = %v, %v, want a settled scenario", result, err)
+				}
+			}
+		})
+	}
+}
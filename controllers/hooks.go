@@ -0,0 +1,326 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/naming"
+)
+
+// hookLabel holds the hook a Job was created for
+const hookLabel = "devopsbeerer.io/hook"
+
+// errHookRunning is returned while the Job of a hook runs
+var errHookRunning = goerrors.New("hook is running")
+
+const (
+	// defaultHookTimeout is how long a hook Job may run unless its hook sets
+	// a timeout
+	defaultHookTimeout = 5 * time.Minute
+	// hookPollInterval is how often a running hook Job is checked
+	hookPollInterval = 5 * time.Second
+	// maxJobPrefix leaves room in the Job name for the generated suffix, Job
+	// names being limited to the length of a label value
+	maxJobPrefix = 57
+)
+
+// hookEvent is a point of the scenario lifecycle hooks run at
+type hookEvent struct {
+	// name is the name of the hook field
+	name string
+	// condition is the ActiveScenario condition reporting the hook outcome
+	condition string
+	// hook returns the hook run at this event, if any
+	hook func(hooks *devopsbeererv1beta1.ScenarioHooks) *devopsbeererv1beta1.ScenarioHook
+}
+
+var (
+	hookPreInstall = hookEvent{
+		name:      "preInstall",
+		condition: devopsbeererv1beta1.ActiveScenarioConditionPreInstallHook,
+		hook: func(hooks *devopsbeererv1beta1.ScenarioHooks) *devopsbeererv1beta1.ScenarioHook {
+			return hooks.PreInstall
+		},
+	}
+	hookPostInstall = hookEvent{
+		name:      "postInstall",
+		condition: devopsbeererv1beta1.ActiveScenarioConditionPostInstallHook,
+		hook: func(hooks *devopsbeererv1beta1.ScenarioHooks) *devopsbeererv1beta1.ScenarioHook {
+			return hooks.PostInstall
+		},
+	}
+	hookPreUninstall = hookEvent{
+		name:      "preUninstall",
+		condition: devopsbeererv1beta1.ActiveScenarioConditionPreUninstallHook,
+		hook: func(hooks *devopsbeererv1beta1.ScenarioHooks) *devopsbeererv1beta1.ScenarioHook {
+			return hooks.PreUninstall
+		},
+	}
+	hookPostUninstall = hookEvent{
+		name:      "postUninstall",
+		condition: devopsbeererv1beta1.ActiveScenarioConditionPostUninstallHook,
+		hook: func(hooks *devopsbeererv1beta1.ScenarioHooks) *devopsbeererv1beta1.ScenarioHook {
+			return hooks.PostUninstall
+		},
+	}
+)
+
+// scenarioHooks returns the hooks of a scenario, none when its definition was
+// deleted
+func (r *ActiveScenarioReconciler) scenarioHooks(ctx context.Context,
+	scenarioID string) (*devopsbeererv1beta1.ScenarioHooks, error) {
+
+	scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{}
+	if err := r.Get(ctx, types.NamespacedName{Name: scenarioID}, scenarioDef); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return scenarioDef.Spec.Hooks, nil
+}
+
+// runHook runs the hook of a scenario for an event as a Job in namespace,
+// created as the given ServiceAccount. It returns errHookRunning until the
+// Job finishes, the Job being recorded on the ActiveScenario status, which
+// the caller persists before requeueing. The outcome is recorded on the
+// condition of the event, persisted with the next status update of the
+// ActiveScenario. A failed hook returns its failure policy and an error,
+// unless the policy ignores the failure.
+func (r *ActiveScenarioReconciler) runHook(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	hooks *devopsbeererv1beta1.ScenarioHooks, event hookEvent,
+	namespace, release, serviceAccount string) (devopsbeererv1beta1.HookFailurePolicy, error) {
+
+	if hooks == nil || event.hook(hooks) == nil {
+		return "", nil
+	}
+	hook := event.hook(hooks)
+
+	hookJob := activeScenario.Status.HookJob
+	if hookJob == nil || hookJob.Hook != event.name || hookJob.Namespace != namespace {
+		if err := r.startHookJob(ctx, activeScenario, hook, event, namespace, release, serviceAccount); err != nil {
+			return r.hookFailed(ctx, activeScenario, hook, event, err)
+		}
+		return "", errHookRunning
+	}
+
+	done, err := r.hookJobOutcome(ctx, hook, hookJob)
+	if err == nil && !done {
+		return "", errHookRunning
+	}
+	activeScenario.Status.HookJob = nil
+	if err != nil {
+		return r.hookFailed(ctx, activeScenario, hook, event, err)
+	}
+
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonHookSucceeded,
+		"The %s hook Job %s completed", event.name, hookJob.Name)
+	meta.SetStatusCondition(&activeScenario.Status.Conditions, metav1.Condition{
+		Type:               event.condition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Succeeded",
+		Message:            fmt.Sprintf("Job %s completed", hookJob.Name),
+		ObservedGeneration: activeScenario.Generation,
+	})
+	return "", nil
+}
+
+// hookFailed records the failure of a hook and returns its failure policy and
+// an error, unless the policy ignores the failure
+func (r *ActiveScenarioReconciler) hookFailed(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	hook *devopsbeererv1beta1.ScenarioHook, event hookEvent, err error) (devopsbeererv1beta1.HookFailurePolicy, error) {
+
+	policy := hook.FailurePolicy
+	if policy == "" {
+		policy = devopsbeererv1beta1.HookFailurePolicyAbort
+	}
+	message := truncateMessage(fmt.Sprintf("The %s hook failed (%s): %v", event.name, policy, err))
+	r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonHookFailed, message)
+	meta.SetStatusCondition(&activeScenario.Status.Conditions, metav1.Condition{
+		Type:               event.condition,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Failed",
+		Message:            message,
+		ObservedGeneration: activeScenario.Generation,
+	})
+	if policy == devopsbeererv1beta1.HookFailurePolicyIgnore {
+		log.FromContext(ctx).Info("Ignoring failed hook", "hook", event.name, "error", err.Error())
+		return "", nil
+	}
+	return policy, fmt.Errorf("%s hook failed: %w", event.name, err)
+}
+
+// startHookJob creates the Job of a hook as the given ServiceAccount,
+// replacing the Jobs left by previous runs, and records it on the
+// ActiveScenario status
+func (r *ActiveScenarioReconciler) startHookJob(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	hook *devopsbeererv1beta1.ScenarioHook, event hookEvent,
+	namespace, release, serviceAccount string) error {
+
+	labels := map[string]string{
+		scenarioLabel: activeScenario.Spec.ScenarioID,
+		managedLabel:  "true",
+		hookLabel:     event.name,
+	}
+	if err := r.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(namespace),
+		client.MatchingLabels(labels), client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return fmt.Errorf("failed to delete previous Jobs: %w", err)
+	}
+
+	job := scenarioJob(&hook.Template, release+"-"+strings.ToLower(event.name), namespace, labels)
+
+	log.FromContext(ctx).Info("Running hook", "hook", event.name, "namespace", namespace)
+	c, err := r.clientFor(serviceAccount)
+	if err != nil {
+		return err
+	}
+	if err := c.Create(ctx, job); err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+
+	activeScenario.Status.HookJob = &devopsbeererv1beta1.HookJobStatus{
+		Hook:      event.name,
+		Namespace: namespace,
+		Name:      job.Name,
+		StartTime: metav1.Now(),
+	}
+	meta.SetStatusCondition(&activeScenario.Status.Conditions, metav1.Condition{
+		Type:               event.condition,
+		Status:             metav1.ConditionUnknown,
+		Reason:             "Running",
+		Message:            fmt.Sprintf("Job %s is running", job.Name),
+		ObservedGeneration: activeScenario.Generation,
+	})
+	return nil
+}
+
+// hookJobOutcome returns whether the Job of a hook completed, or the error it
+// failed with. A Job running for longer than the timeout of its hook is
+// deleted and fails.
+func (r *ActiveScenarioReconciler) hookJobOutcome(ctx context.Context,
+	hook *devopsbeererv1beta1.ScenarioHook, hookJob *devopsbeererv1beta1.HookJobStatus) (bool, error) {
+
+	timeout := defaultHookTimeout
+	if hook.Timeout != nil {
+		timeout = hook.Timeout.Duration
+	}
+	timedOut := time.Since(hookJob.StartTime.Time) > timeout
+
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: hookJob.Namespace, Name: hookJob.Name}, job); err != nil {
+		// The cache may not have seen the Job yet
+		if errors.IsNotFound(err) && !timedOut {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get Job %s: %w", hookJob.Name, err)
+	}
+	done, err := jobOutcome(job)
+	if done || err != nil || !timedOut {
+		return done, err
+	}
+
+	// Stop the Job rather than let it run on after its hook failed
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+		!errors.IsNotFound(err) {
+		log.FromContext(ctx).Error(err, "Failed to delete timed out hook Job", "job", job.Name)
+	}
+	return false, fmt.Errorf("job %s did not complete within %s", job.Name, timeout)
+}
+
+// scenarioJob builds a Job from a template, named after prefix and carrying
+// the given labels on top of the template ones
+func scenarioJob(template *batchv1.JobTemplateSpec, prefix, namespace string,
+	labels map[string]string) *batchv1.Job {
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: naming.Truncate(prefix, maxJobPrefix) + "-",
+			Namespace:    namespace,
+			Labels:       maps.Clone(template.Labels),
+			Annotations:  template.Annotations,
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+	if job.Labels == nil {
+		job.Labels = map[string]string{}
+	}
+	maps.Copy(job.Labels, labels)
+	// Jobs only accept pods that are not restarted forever
+	if job.Spec.Template.Spec.RestartPolicy == "" {
+		job.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+	return job
+}
+
+// jobOutcome returns whether a Job completed, or the error it failed with
+func jobOutcome(job *batchv1.Job) (bool, error) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return false, fmt.Errorf("job %s failed: %s", job.Name, condition.Message)
+		}
+	}
+	return false, nil
+}
+
+// hookRunning persists the hook Job recorded on the ActiveScenario status and
+// requeues until it finishes
+func (r *ActiveScenarioReconciler) hookRunning(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario) (ctrl.Result, error) {
+
+	if err := r.Status().Update(ctx, activeScenario); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: hookPollInterval}, nil
+}
+
+// runUninstallHook runs an uninstall hook of a scenario. A failed hook is
+// recorded on the history without blocking the uninstallation.
+func (r *ActiveScenarioReconciler) runUninstallHook(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, history *devopsbeererv1beta1.ScenarioHistory,
+	hooks *devopsbeererv1beta1.ScenarioHooks, event hookEvent) error {
+
+	_, err := r.runHook(ctx, activeScenario, hooks, event,
+		history.Spec.Namespace, history.Spec.HelmRelease, history.Spec.ServiceAccount)
+	if err == nil || goerrors.Is(err, errHookRunning) {
+		return err
+	}
+	r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonHookFailed, truncateMessage(err.Error()))
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/tracing"
+)
+
+// namespacePollInterval is how often a terminating namespace is checked
+const namespacePollInterval = 5 * time.Second
+
+// errNamespaceTerminating is returned while the namespace of an uninstalled
+// scenario is still being deleted
+var errNamespaceTerminating = goerrors.New("namespace is terminating")
+
+// namespaceDeletionConditions report why the deletion of a namespace is blocked
+var namespaceDeletionConditions = []corev1.NamespaceConditionType{
+	corev1.NamespaceDeletionDiscoveryFailure,
+	corev1.NamespaceDeletionGVParsingFailure,
+	corev1.NamespaceDeletionContentFailure,
+	corev1.NamespaceContentRemaining,
+	corev1.NamespaceFinalizersRemaining,
+}
+
+// deleteNamespace requests the deletion of a namespace
+func (r *ActiveScenarioReconciler) deleteNamespace(ctx context.Context, name string) (err error) {
+	ctx, span := tracing.Start(ctx, "deleteNamespace", tracing.AttrNamespace.String(name))
+	defer func() { tracing.End(span, err) }()
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if err := r.Delete(ctx, ns); err != nil && !errors.IsNotFound(err) && !errors.IsConflict(err) {
+		return err
+	}
+	return nil
+}
+
+// waitForNamespace returns errNamespaceTerminating until the namespaces of the
+// history are gone. Once the deletion takes longer than the timeout, the
+// resources and finalizers blocking it are reported on the history and the
+// ActiveScenario.
+func (r *ActiveScenarioReconciler) waitForNamespace(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	history *devopsbeererv1beta1.ScenarioHistory) error {
+
+	for _, namespace := range historyNamespaces(history) {
+		if err := r.waitForNamespaceDeletion(ctx, activeScenario, history, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForNamespaceDeletion returns errNamespaceTerminating until a namespace
+// of the history is gone
+func (r *ActiveScenarioReconciler) waitForNamespaceDeletion(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	history *devopsbeererv1beta1.ScenarioHistory, namespace string) error {
+
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// The namespace was recreated, or the cache has not seen the deletion yet.
+	// A namespace recreated by someone else is not the operator's to delete.
+	if ns.DeletionTimestamp.IsZero() {
+		if ns.Labels[managedLabel] != "true" || ns.Labels[scenarioLabel] != history.Spec.ScenarioID {
+			message := fmt.Sprintf("Namespace %s was recreated outside of the operator and is left in place", ns.Name)
+			r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonNamespaceConflict, message)
+			r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonNamespaceConflict, message)
+			return nil
+		}
+		if err := r.deleteNamespace(ctx, ns.Name); err != nil {
+			return fmt.Errorf("failed to delete namespace: %w", err)
+		}
+		return errNamespaceTerminating
+	}
+
+	if r.NamespaceDeletionTimeout <= 0 || time.Since(ns.DeletionTimestamp.Time) < r.NamespaceDeletionTimeout {
+		return errNamespaceTerminating
+	}
+
+	message := fmt.Sprintf("Namespace %s has been terminating for more than %s", ns.Name, r.NamespaceDeletionTimeout)
+	if blockers := namespaceDeletionBlockers(ns); len(blockers) > 0 {
+		message += ": " + strings.Join(blockers, "; ")
+	}
+	message = truncateMessage(message)
+
+	if history.Status.Message != message {
+		history.Status.Message = message
+		if err := r.Status().Update(ctx, history); err != nil {
+			return fmt.Errorf("failed to update history status: %w", err)
+		}
+		r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonNamespaceStuck, message)
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonNamespaceStuck, message)
+	}
+	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseTerminating, message); err != nil {
+		return err
+	}
+
+	return errNamespaceTerminating
+}
+
+// namespaceDeletionBlockers lists the resources and finalizers the namespace
+// controller reports as blocking the deletion of a namespace
+func namespaceDeletionBlockers(ns *corev1.Namespace) []string {
+	var blockers []string
+	for _, conditionType := range namespaceDeletionConditions {
+		for _, condition := range ns.Status.Conditions {
+			if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+				blockers = append(blockers, condition.Message)
+			}
+		}
+	}
+	return blockers
+}
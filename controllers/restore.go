@@ -0,0 +1,463 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/naming"
+	"github.com/devopsbeerer/operator/internal/snapshot"
+)
+
+const (
+	// restoreActiveScenarioField indexes ScenarioRestore objects by the
+	// ActiveScenario they restore
+	restoreActiveScenarioField = "spec.activeScenario"
+	// restoreCopyTimeout bounds the Jobs copying the data of a volume back
+	restoreCopyTimeout = 30 * time.Minute
+	// restorePollInterval is how often the Jobs copying the data of volumes
+	// back are checked
+	restorePollInterval = 10 * time.Second
+)
+
+// errRestoreCopying is returned while the data of volumes is copied back
+var errRestoreCopying = goerrors.New("volumes are being copied back")
+
+// scenarioRestore is a ScenarioRestore to install, with its snapshot
+type scenarioRestore struct {
+	restore  *devopsbeererv1beta1.ScenarioRestore
+	snapshot *devopsbeererv1beta1.ScenarioSnapshot
+}
+
+// installedBy returns whether a history was installed by the restore
+func (s *scenarioRestore) installedBy(history *devopsbeererv1beta1.ScenarioHistory) bool {
+	return history.Spec.RestoredFrom != nil && history.Spec.RestoredFrom.Restore == s.restore.Name
+}
+
+// pendingRestore returns the oldest ScenarioRestore of an ActiveScenario that
+// did not complete, if any. Restores whose snapshot cannot be restored into
+// the ActiveScenario are failed on the way.
+func (r *ActiveScenarioReconciler) pendingRestore(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario) (*scenarioRestore, error) {
+
+	restores := &devopsbeererv1beta1.ScenarioRestoreList{}
+	if err := r.List(ctx, restores, client.MatchingFields{restoreActiveScenarioField: activeScenario.Name}); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(restores.Items, func(a, b devopsbeererv1beta1.ScenarioRestore) int {
+		if c := a.CreationTimestamp.Compare(b.CreationTimestamp.Time); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	for i := range restores.Items {
+		restore := &restores.Items[i]
+		if restore.Status.Phase == devopsbeererv1beta1.ScenarioRestorePhaseCompleted ||
+			restore.Status.Phase == devopsbeererv1beta1.ScenarioRestorePhaseFailed {
+			continue
+		}
+
+		scenarioSnapshot := &devopsbeererv1beta1.ScenarioSnapshot{}
+		err := r.Get(ctx, client.ObjectKey{Name: restore.Spec.Snapshot}, scenarioSnapshot)
+		var failure string
+		switch {
+		case errors.IsNotFound(err):
+			failure = fmt.Sprintf("ScenarioSnapshot %s not found", restore.Spec.Snapshot)
+		case err != nil:
+			return nil, err
+		case scenarioSnapshot.Status.Phase != devopsbeererv1beta1.ScenarioSnapshotPhaseReady:
+			failure = fmt.Sprintf("ScenarioSnapshot %s is not ready", scenarioSnapshot.Name)
+		case scenarioSnapshot.Status.ScenarioID != activeScenario.Spec.ScenarioID:
+			failure = fmt.Sprintf("ScenarioSnapshot %s captured scenario %s, not %s",
+				scenarioSnapshot.Name, scenarioSnapshot.Status.ScenarioID, activeScenario.Spec.ScenarioID)
+		}
+		if failure != "" {
+			if err := r.finishRestore(ctx, restore, devopsbeererv1beta1.ScenarioRestorePhaseFailed, "", failure); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return &scenarioRestore{restore: restore, snapshot: scenarioSnapshot}, nil
+	}
+	return nil, nil
+}
+
+// startRestore records that the scenario is being reinstalled for a restore
+func (r *ActiveScenarioReconciler) startRestore(ctx context.Context, restore *scenarioRestore) error {
+	if restore.restore.Status.Phase == devopsbeererv1beta1.ScenarioRestorePhaseRestoring {
+		return nil
+	}
+	now := metav1.Now()
+	restore.restore.Status.Phase = devopsbeererv1beta1.ScenarioRestorePhaseRestoring
+	restore.restore.Status.StartTime = &now
+	restore.restore.Status.Message = fmt.Sprintf("Reinstalling scenario %s from snapshot %s",
+		restore.snapshot.Status.ScenarioID, restore.snapshot.Name)
+	r.Recorder.Event(restore.restore, corev1.EventTypeNormal, EventReasonRestoreStarted, restore.restore.Status.Message)
+	return r.Status().Update(ctx, restore.restore)
+}
+
+// restoreInstalled completes or fails a restore once the scenario it
+// reinstalls settled
+func (r *ActiveScenarioReconciler) restoreInstalled(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, restore *scenarioRestore) error {
+
+	switch activeScenario.Status.Phase {
+	case devopsbeererv1beta1.ActiveScenarioPhaseRunning:
+		history, err := r.findActiveScenarioHistory(ctx)
+		if err != nil || history == nil || !restore.installedBy(history) {
+			return err
+		}
+		return r.finishRestore(ctx, restore.restore, devopsbeererv1beta1.ScenarioRestorePhaseCompleted, history.Name,
+			fmt.Sprintf("Scenario %s restored from snapshot %s", history.Spec.ScenarioID, restore.snapshot.Name))
+	case devopsbeererv1beta1.ActiveScenarioPhaseFailed:
+		message := "The scenario failed to install"
+		if ready := meta.FindStatusCondition(activeScenario.Status.Conditions,
+			devopsbeererv1beta1.ActiveScenarioConditionReady); ready != nil {
+			message = ready.Message
+		}
+		return r.finishRestore(ctx, restore.restore, devopsbeererv1beta1.ScenarioRestorePhaseFailed, "", message)
+	}
+	return nil
+}
+
+// finishRestore records the outcome of a restore
+func (r *ActiveScenarioReconciler) finishRestore(ctx context.Context, restore *devopsbeererv1beta1.ScenarioRestore,
+	phase devopsbeererv1beta1.ScenarioRestorePhase, history, message string) error {
+
+	now := metav1.Now()
+	restore.Status.Phase = phase
+	restore.Status.History = history
+	restore.Status.CompletionTime = &now
+	restore.Status.Message = truncateMessage(message)
+	if phase == devopsbeererv1beta1.ScenarioRestorePhaseCompleted {
+		r.Recorder.Event(restore, corev1.EventTypeNormal, EventReasonRestoreCompleted, restore.Status.Message)
+	} else {
+		r.Recorder.Event(restore, corev1.EventTypeWarning, EventReasonRestoreFailed, restore.Status.Message)
+	}
+	return r.Status().Update(ctx, restore)
+}
+
+// restoreSnapshot creates the objects captured by the snapshot of a restore
+// in the namespace of the scenario being installed, before its charts are
+// installed. The volumes are restored from the VolumeSnapshots of the
+// snapshot, or copied back from its volumes, returning errRestoreCopying
+// until the copies complete. Objects the Helm release of the scenario
+// captured installed are adopted by the release installed.
+func (r *ActiveScenarioReconciler) restoreSnapshot(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, restore *scenarioRestore,
+	namespace, release string) error {
+
+	scenarioSnapshot := restore.snapshot
+
+	if scenarioSnapshot.Status.Data == nil {
+		return fmt.Errorf("snapshot %s holds no data", scenarioSnapshot.Name)
+	}
+	dataSecret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{
+		Namespace: scenarioSnapshot.Status.Data.Namespace,
+		Name:      scenarioSnapshot.Status.Data.Name,
+	}, dataSecret); err != nil {
+		return fmt.Errorf("failed to read the objects captured: %w", err)
+	}
+	data, err := snapshot.Decode(dataSecret.Data)
+	if err != nil {
+		return err
+	}
+
+	copying := false
+	for i := range data.Claims {
+		claim := &data.Claims[i]
+		snapshot.Adopt(&claim.ObjectMeta, namespace, scenarioSnapshot.Status.HelmRelease, release)
+		volume := volumeStatus(scenarioSnapshot, claim.Name)
+		if volume != nil && volume.Method == devopsbeererv1beta1.VolumeCaptureMethodVolumeSnapshot {
+			if err := r.restoreVolumeSnapshot(ctx, restore, volume, claim); err != nil {
+				return fmt.Errorf("failed to restore claim %s: %w", claim.Name, err)
+			}
+		}
+		if err := r.Create(ctx, claim); err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create claim %s: %w", claim.Name, err)
+		}
+		if volume != nil && volume.Method == devopsbeererv1beta1.VolumeCaptureMethodCopy {
+			restored, err := r.restoreCopy(ctx, activeScenario, restore, volume, claim.Name, namespace, release)
+			if err != nil {
+				return fmt.Errorf("failed to restore claim %s: %w", claim.Name, err)
+			}
+			copying = copying || !restored
+		}
+	}
+	if len(restore.restore.Status.Volumes) > 0 {
+		if err := r.Status().Update(ctx, restore.restore); err != nil {
+			return err
+		}
+	}
+
+	for i := range data.ConfigMaps {
+		configMap := &data.ConfigMaps[i]
+		snapshot.Adopt(&configMap.ObjectMeta, namespace, scenarioSnapshot.Status.HelmRelease, release)
+		if err := restoreObject(ctx, r.Client, configMap, func(existing *corev1.ConfigMap) {
+			existing.Labels, existing.Annotations = configMap.Labels, configMap.Annotations
+			existing.Data, existing.BinaryData = configMap.Data, configMap.BinaryData
+		}); err != nil {
+			return fmt.Errorf("failed to restore ConfigMap %s: %w", configMap.Name, err)
+		}
+	}
+	// The Secrets captured replace the credentials generated for the
+	// installation, which the data restored was created with
+	for i := range data.Secrets {
+		secret := &data.Secrets[i]
+		snapshot.Adopt(&secret.ObjectMeta, namespace, scenarioSnapshot.Status.HelmRelease, release)
+		if err := restoreObject(ctx, r.Client, secret, func(existing *corev1.Secret) {
+			existing.Labels, existing.Annotations = secret.Labels, secret.Annotations
+			existing.Data = secret.Data
+		}); err != nil {
+			return fmt.Errorf("failed to restore Secret %s: %w", secret.Name, err)
+		}
+	}
+
+	if copying {
+		return errRestoreCopying
+	}
+	log.FromContext(ctx).Info("Restored snapshot", "snapshot", scenarioSnapshot.Name, "namespace", namespace)
+	return nil
+}
+
+// restoreObject creates an object, or updates the existing one
+func restoreObject[T client.Object](ctx context.Context, c client.Client, obj T, update func(existing T)) error {
+	err := c.Create(ctx, obj)
+	if !errors.IsAlreadyExists(err) {
+		return err
+	}
+	existing := obj.DeepCopyObject().(T)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		return err
+	}
+	update(existing)
+	return c.Update(ctx, existing)
+}
+
+// volumeStatus returns the capture of a claim, nil when its data was not
+// captured
+func volumeStatus(scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot,
+	claim string) *devopsbeererv1beta1.VolumeSnapshotStatus {
+
+	for i := range scenarioSnapshot.Status.Volumes {
+		if volume := &scenarioSnapshot.Status.Volumes[i]; volume.Claim == claim && volume.Ready {
+			return volume
+		}
+	}
+	return nil
+}
+
+// restoreVolumeSnapshot binds the retained content of the VolumeSnapshot of a
+// claim to a new VolumeSnapshot of the namespace, and provisions the claim
+// from it
+func (r *ActiveScenarioReconciler) restoreVolumeSnapshot(ctx context.Context,
+	restore *scenarioRestore, volume *devopsbeererv1beta1.VolumeSnapshotStatus,
+	claim *corev1.PersistentVolumeClaim) error {
+
+	labels := map[string]string{managedLabel: "true", snapshotLabel: restore.snapshot.Name}
+	snapshotName := naming.Truncate("restore-"+claim.Name, 253)
+	// Named after the restore, so that a retried restore reuses its content
+	contentName := naming.Truncate("restore-"+restore.restore.Name+"-"+restore.snapshot.Name+"-"+claim.Name, 253)
+
+	// Retained, so that deleting the namespace leaves the snapshot in place
+	content := &unstructured.Unstructured{}
+	content.SetGroupVersionKind(volumeSnapshotContentGVK)
+	content.SetName(contentName)
+	content.SetLabels(labels)
+	contentSpec := map[string]any{
+		"deletionPolicy": "Retain",
+		"driver":         volume.Driver,
+		"source":         map[string]any{"snapshotHandle": volume.SnapshotHandle},
+		"volumeSnapshotRef": map[string]any{
+			"name":      snapshotName,
+			"namespace": claim.Namespace,
+		},
+	}
+	if volume.VolumeSnapshotClassName != "" {
+		contentSpec["volumeSnapshotClassName"] = volume.VolumeSnapshotClassName
+	}
+	content.Object["spec"] = contentSpec
+	if err := r.Create(ctx, content); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create VolumeSnapshotContent: %w", err)
+	}
+
+	volumeSnapshot := &unstructured.Unstructured{}
+	volumeSnapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	volumeSnapshot.SetName(snapshotName)
+	volumeSnapshot.SetNamespace(claim.Namespace)
+	volumeSnapshot.SetLabels(labels)
+	snapshotSpec := map[string]any{"source": map[string]any{"volumeSnapshotContentName": contentName}}
+	if volume.VolumeSnapshotClassName != "" {
+		snapshotSpec["volumeSnapshotClassName"] = volume.VolumeSnapshotClassName
+	}
+	volumeSnapshot.Object["spec"] = snapshotSpec
+	if err := r.Create(ctx, volumeSnapshot); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create VolumeSnapshot: %w", err)
+	}
+
+	claim.Spec.DataSource = &corev1.TypedLocalObjectReference{
+		APIGroup: ptr.To(volumeSnapshotGVK.Group),
+		Kind:     volumeSnapshotGVK.Kind,
+		Name:     snapshotName,
+	}
+	// A claim cannot be smaller than the snapshot it is provisioned from
+	if volume.Size != nil && claim.Spec.Resources.Requests.Storage().Cmp(*volume.Size) < 0 {
+		if claim.Spec.Resources.Requests == nil {
+			claim.Spec.Resources.Requests = corev1.ResourceList{}
+		}
+		claim.Spec.Resources.Requests[corev1.ResourceStorage] = *volume.Size
+	}
+	return nil
+}
+
+// restoreCopy binds the retained volume a claim was copied to, and copies its
+// data back into the claim with a Job recorded on the restore status. It
+// returns whether the data was copied back, the volume being released then,
+// for the next restore.
+func (r *ActiveScenarioReconciler) restoreCopy(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, restore *scenarioRestore,
+	volume *devopsbeererv1beta1.VolumeSnapshotStatus, claim, namespace, release string) (bool, error) {
+
+	status := restoredVolume(restore.restore, claim)
+	if status.Restored {
+		return true, nil
+	}
+
+	labels := map[string]string{
+		scenarioLabel: activeScenario.Spec.ScenarioID,
+		managedLabel:  "true",
+		snapshotLabel: restore.snapshot.Name,
+	}
+	backup := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      naming.Truncate("snapshot-"+claim, 253),
+			Namespace: namespace,
+			Labels:    labels,
+		},
+	}
+
+	if status.Job == "" {
+		persistentVolume := &corev1.PersistentVolume{}
+		if err := r.Get(ctx, client.ObjectKey{Name: volume.PersistentVolume}, persistentVolume); err != nil {
+			return false, fmt.Errorf("failed to get volume %s: %w", volume.PersistentVolume, err)
+		}
+		if ref := persistentVolume.Spec.ClaimRef; ref != nil &&
+			(ref.Namespace != namespace || ref.Name != backup.Name) {
+			bound := &corev1.PersistentVolumeClaim{}
+			err := r.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, bound)
+			if err == nil && bound.UID == ref.UID {
+				return false, fmt.Errorf("volume %s is in use by claim %s/%s", persistentVolume.Name, ref.Namespace, ref.Name)
+			}
+			if client.IgnoreNotFound(err) != nil {
+				return false, err
+			}
+			// Makes the released volume available again
+			persistentVolume.Spec.ClaimRef = nil
+			if err := r.Update(ctx, persistentVolume); err != nil {
+				return false, fmt.Errorf("failed to release volume %s: %w", persistentVolume.Name, err)
+			}
+		}
+
+		backup.Spec = corev1.PersistentVolumeClaimSpec{
+			AccessModes:      persistentVolume.Spec.AccessModes,
+			Resources:        corev1.VolumeResourceRequirements{Requests: persistentVolume.Spec.Capacity},
+			StorageClassName: ptr.To(persistentVolume.Spec.StorageClassName),
+			VolumeMode:       persistentVolume.Spec.VolumeMode,
+			VolumeName:       persistentVolume.Name,
+		}
+		if err := r.Create(ctx, backup); err != nil && !errors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create claim %s: %w", backup.Name, err)
+		}
+
+		job := copyJob(restore.snapshot.Spec.CopyImage, release+"-restore-"+claim, namespace, labels, backup.Name, claim, "")
+		if err := r.Create(ctx, job); err != nil {
+			return false, fmt.Errorf("failed to create copy Job: %w", err)
+		}
+		status.Job = job.Name
+		return false, nil
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: status.Job}, job); err != nil {
+		if errors.IsNotFound(err) {
+			return false, fmt.Errorf("copy Job %s was deleted", status.Job)
+		}
+		return false, err
+	}
+	completed, err := jobOutcome(job)
+	if err == nil && !completed {
+		if time.Since(job.CreationTimestamp.Time) < restoreCopyTimeout {
+			return false, nil
+		}
+		err = fmt.Errorf("job %s did not complete within %s", job.Name, restoreCopyTimeout)
+	}
+
+	// The volume is released once the pod of the Job is gone, whatever the
+	// outcome of the copy
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	if err := r.Delete(ctx, backup); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	status.Restored = true
+	return true, nil
+}
+
+// restoredVolume returns the status of the copy of a claim back, added to
+// the restore status when missing
+func restoredVolume(restore *devopsbeererv1beta1.ScenarioRestore, claim string) *devopsbeererv1beta1.RestoredVolumeStatus {
+	for i := range restore.Status.Volumes {
+		if restore.Status.Volumes[i].Claim == claim {
+			return &restore.Status.Volumes[i]
+		}
+	}
+	restore.Status.Volumes = append(restore.Status.Volumes, devopsbeererv1beta1.RestoredVolumeStatus{Claim: claim})
+	return &restore.Status.Volumes[len(restore.Status.Volumes)-1]
+}
+
+// activeScenarioForRestore maps a ScenarioRestore to the ActiveScenario it
+// restores
+func activeScenarioForRestore(_ context.Context, obj client.Object) []reconcile.Request {
+	restore, ok := obj.(*devopsbeererv1beta1.ScenarioRestore)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: restore.Spec.ActiveScenario}}}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/catalog"
+	"github.com/devopsbeerer/operator/internal/helm"
+	"github.com/devopsbeerer/operator/internal/metrics"
+)
+
+// catalogLabel holds the name of the ScenarioCatalog a ScenarioDefinition was
+// imported by
+const catalogLabel = "devopsbeerer.io/catalog"
+
+// defaultCatalogInterval is the sync interval of catalogs that set none
+const defaultCatalogInterval = 10 * time.Minute
+
+// ScenarioCatalogReconciler imports the charts of a ScenarioCatalog as the
+// ScenarioDefinitions it owns
+type ScenarioCatalogReconciler struct {
+	client.Client
+	Scheme     *runtime.Scheme
+	HelmClient *helm.Client
+	Recorder   record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariocatalogs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariocatalogs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariocatalogs/finalizers,verbs=update
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariodefinitions,verbs=get;list;watch;create;update;delete
+
+// Reconcile syncs the ScenarioDefinitions of a catalog with its repository
+func (r *ScenarioCatalogReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	scenarioCatalog := &devopsbeererv1beta1.ScenarioCatalog{}
+	if err := r.Get(ctx, req.NamespacedName, scenarioCatalog); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	interval := defaultCatalogInterval
+	if scenarioCatalog.Spec.Interval != nil {
+		interval = scenarioCatalog.Spec.Interval.Duration
+	}
+
+	if r.HelmClient == nil {
+		return ctrl.Result{}, r.syncFailed(ctx, scenarioCatalog, "GitUnavailable",
+			"Git operations are disabled, the repository cannot be synced")
+	}
+
+	source := scenarioCatalog.Spec.Git
+	dir, revision, err := r.HelmClient.Checkout(ctx, "catalog-"+scenarioCatalog.Name, source.URL, source.Ref)
+	if err != nil {
+		metrics.CatalogSyncs.WithLabelValues(scenarioCatalog.Name, metrics.ResultFailure).Inc()
+		if err := r.syncFailed(ctx, scenarioCatalog, "FetchFailed", err.Error()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, err
+	}
+
+	entries, err := catalog.Load(dir, source, scenarioCatalog.Spec.Manifest, scenarioCatalog.Spec.TrustedFields)
+	if err != nil {
+		metrics.CatalogSyncs.WithLabelValues(scenarioCatalog.Name, metrics.ResultFailure).Inc()
+		// Retrying does not help until the repository or the catalog changes
+		return ctrl.Result{RequeueAfter: interval},
+			r.syncFailed(ctx, scenarioCatalog, "InvalidCatalog", err.Error())
+	}
+
+	definitionList := &devopsbeererv1beta1.ScenarioDefinitionList{}
+	if err := r.List(ctx, definitionList, client.MatchingLabels{catalogLabel: scenarioCatalog.Name}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Apply the entries, the first entry of an ID wins
+	listed := map[string]bool{}
+	statuses := make([]devopsbeererv1beta1.CatalogEntryStatus, 0, len(entries))
+	failed := 0
+	for _, entry := range entries {
+		if listed[entry.Spec.ID] {
+			log.Info("Ignoring duplicate catalog entry", "id", entry.Spec.ID, "path", entry.Path)
+			continue
+		}
+		listed[entry.Spec.ID] = true
+
+		status := devopsbeererv1beta1.CatalogEntryStatus{
+			ID:    entry.Spec.ID,
+			Path:  entry.Path,
+			State: devopsbeererv1beta1.CatalogEntryStateSynced,
+		}
+		err := entry.Err
+		if err == nil {
+			status.Definition = entry.Spec.ID
+			err = r.applyEntry(ctx, scenarioCatalog, entry)
+		}
+		if err != nil {
+			failed++
+			status.State = devopsbeererv1beta1.CatalogEntryStateFailed
+			status.Message = truncateMessage(err.Error())
+			// Only report entries whose failure is new
+			if previous := catalogEntry(scenarioCatalog, entry.Spec.ID); previous == nil || previous.Message != status.Message {
+				r.Recorder.Eventf(scenarioCatalog, corev1.EventTypeWarning, EventReasonEntryFailed,
+					"Failed to sync scenario '%s': %s", entry.Spec.ID, status.Message)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	// Prune the definitions of scenarios no longer listed
+	if scenarioCatalog.Spec.Prune == nil || *scenarioCatalog.Spec.Prune {
+		for i := range definitionList.Items {
+			definition := &definitionList.Items[i]
+			if listed[definition.Name] || !metav1.IsControlledBy(definition, scenarioCatalog) {
+				continue
+			}
+			if err := r.Delete(ctx, definition); err != nil && !errors.IsNotFound(err) {
+				return ctrl.Result{}, fmt.Errorf("failed to prune ScenarioDefinition %s: %w", definition.Name, err)
+			}
+			log.Info("Pruned ScenarioDefinition", "definition", definition.Name)
+			r.Recorder.Eventf(scenarioCatalog, corev1.EventTypeNormal, EventReasonDefinitionPruned,
+				"Pruned ScenarioDefinition '%s' no longer listed by the catalog", definition.Name)
+		}
+	}
+
+	metrics.CatalogSyncs.WithLabelValues(scenarioCatalog.Name, metrics.ResultSuccess).Inc()
+
+	now := metav1.Now()
+	scenarioCatalog.Status.ObservedGeneration = scenarioCatalog.Generation
+	scenarioCatalog.Status.Revision = revision
+	scenarioCatalog.Status.LastSyncTime = &now
+	scenarioCatalog.Status.Entries = statuses
+	condition := metav1.Condition{
+		Type:               devopsbeererv1beta1.ScenarioCatalogConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		Message:            fmt.Sprintf("Synced %d scenarios at %s", len(statuses), revision),
+		ObservedGeneration: scenarioCatalog.Generation,
+	}
+	if failed > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "EntriesFailed"
+		condition.Message = fmt.Sprintf("%d of %d scenarios failed to sync at %s", failed, len(statuses), revision)
+	}
+	meta.SetStatusCondition(&scenarioCatalog.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, scenarioCatalog); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// applyEntry creates or updates the ScenarioDefinition of a catalog entry.
+// Definitions the catalog does not own are left untouched.
+func (r *ScenarioCatalogReconciler) applyEntry(ctx context.Context,
+	scenarioCatalog *devopsbeererv1beta1.ScenarioCatalog, entry catalog.Entry) error {
+
+	definition := &devopsbeererv1beta1.ScenarioDefinition{}
+	err := r.Get(ctx, client.ObjectKey{Name: entry.Spec.ID}, definition)
+	if errors.IsNotFound(err) {
+		definition = &devopsbeererv1beta1.ScenarioDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   entry.Spec.ID,
+				Labels: map[string]string{catalogLabel: scenarioCatalog.Name},
+			},
+			Spec: entry.Spec,
+		}
+		if err := controllerutil.SetControllerReference(scenarioCatalog, definition, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, definition); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(scenarioCatalog, corev1.EventTypeNormal, EventReasonDefinitionCreated,
+			"Created ScenarioDefinition '%s' from %s", definition.Name, entry.Path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !metav1.IsControlledBy(definition, scenarioCatalog) {
+		return fmt.Errorf("ScenarioDefinition '%s' already exists and is not managed by the catalog", definition.Name)
+	}
+	if equality.Semantic.DeepEqual(definition.Spec, entry.Spec) && definition.Labels[catalogLabel] == scenarioCatalog.Name {
+		return nil
+	}
+
+	definition.Spec = entry.Spec
+	if definition.Labels == nil {
+		definition.Labels = map[string]string{}
+	}
+	definition.Labels[catalogLabel] = scenarioCatalog.Name
+	if err := r.Update(ctx, definition); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(scenarioCatalog, corev1.EventTypeNormal, EventReasonDefinitionUpdated,
+		"Updated ScenarioDefinition '%s' from %s", definition.Name, entry.Path)
+	return nil
+}
+
+// syncFailed reports a catalog that could not be synced at all, keeping the
+// entries of the last sync
+func (r *ScenarioCatalogReconciler) syncFailed(ctx context.Context,
+	scenarioCatalog *devopsbeererv1beta1.ScenarioCatalog, reason, message string) error {
+
+	message = truncateMessage(message)
+	if ready := meta.FindStatusCondition(scenarioCatalog.Status.Conditions,
+		devopsbeererv1beta1.ScenarioCatalogConditionReady); ready == nil || ready.Message != message {
+		r.Recorder.Event(scenarioCatalog, corev1.EventTypeWarning, EventReasonCatalogSyncFailed, message)
+	}
+
+	scenarioCatalog.Status.ObservedGeneration = scenarioCatalog.Generation
+	meta.SetStatusCondition(&scenarioCatalog.Status.Conditions, metav1.Condition{
+		Type:               devopsbeererv1beta1.ScenarioCatalogConditionReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: scenarioCatalog.Generation,
+	})
+	return r.Status().Update(ctx, scenarioCatalog)
+}
+
+// catalogEntry returns the status of an entry at the last sync
+func catalogEntry(scenarioCatalog *devopsbeererv1beta1.ScenarioCatalog,
+	id string) *devopsbeererv1beta1.CatalogEntryStatus {
+
+	for i := range scenarioCatalog.Status.Entries {
+		if scenarioCatalog.Status.Entries[i].ID == id {
+			return &scenarioCatalog.Status.Entries[i]
+		}
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ScenarioCatalogReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// Catalogs are synced periodically, status updates must not trigger a sync
+		For(&devopsbeererv1beta1.ScenarioCatalog{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
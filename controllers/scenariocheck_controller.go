@@ -0,0 +1,444 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+const (
+	// scenarioCheckLabel holds the name of the ScenarioCheck a Job runs for
+	scenarioCheckLabel = "devopsbeerer.io/scenario-check"
+	// gradedCheckLabel holds the graded check a Job runs
+	gradedCheckLabel = "devopsbeerer.io/graded-check"
+)
+
+const (
+	// defaultCheckTimeout fails the Job of a graded check that sets no timeout
+	defaultCheckTimeout = 5 * time.Minute
+	// checkPollInterval is how often running checks are looked at, on top of
+	// the updates of their Jobs
+	checkPollInterval = 10 * time.Second
+	// checkLogLines and maxCheckLogs bound the logs recorded for a check
+	checkLogLines = 50
+	maxCheckLogs  = 4096
+)
+
+// ScenarioCheckReconciler runs the graded checks of a ScenarioCheck and
+// records its score on the ScenarioHistory of the scenario
+type ScenarioCheckReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Clientset reads the logs of check Jobs, which the controller-runtime
+	// client cannot. Logs are not recorded when unset.
+	Clientset kubernetes.Interface
+	Recorder  record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariochecks,verbs=get;list;watch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariochecks/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariochecks/finalizers,verbs=update
+
+// The Jobs of checks and the logs of their pods are managed through the
+// scenario ClusterRole the ActiveScenario controller binds in the scenario
+// namespace.
+
+// Reconcile runs the checks of a ScenarioCheck once. A ScenarioCheck that
+// completed or failed is left as is, a new one grades the scenario again.
+func (r *ScenarioCheckReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	scenarioCheck := &devopsbeererv1beta1.ScenarioCheck{}
+	if err := r.Get(ctx, req.NamespacedName, scenarioCheck); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	switch scenarioCheck.Status.Phase {
+	case devopsbeererv1beta1.ScenarioCheckPhaseCompleted, devopsbeererv1beta1.ScenarioCheckPhaseFailed:
+		return ctrl.Result{}, nil
+	case devopsbeererv1beta1.ScenarioCheckPhaseRunning:
+		return r.runChecks(ctx, scenarioCheck)
+	default:
+		return r.startChecks(ctx, scenarioCheck)
+	}
+}
+
+// startChecks resolves the scenario graded and the checks to run
+func (r *ScenarioCheckReconciler) startChecks(ctx context.Context,
+	scenarioCheck *devopsbeererv1beta1.ScenarioCheck) (ctrl.Result, error) {
+
+	activeScenario := &devopsbeererv1beta1.ActiveScenario{}
+	err := r.Get(ctx, client.ObjectKey{Name: scenarioCheck.Spec.ActiveScenario}, activeScenario)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("ActiveScenario %s not found", scenarioCheck.Spec.ActiveScenario))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if activeScenario.Status.Phase != devopsbeererv1beta1.ActiveScenarioPhaseRunning {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("ActiveScenario %s is not running", activeScenario.Name))
+	}
+
+	history, err := runningHistory(ctx, r.Client, activeScenario)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if history == nil {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("No history of scenario %s is active", activeScenario.Spec.ScenarioID))
+	}
+
+	scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{}
+	err = r.Get(ctx, client.ObjectKey{Name: history.Spec.ScenarioID}, scenarioDef)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("ScenarioDefinition %s not found", history.Spec.ScenarioID))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	checks, err := selectChecks(scenarioDef.Spec.Checks, scenarioCheck.Spec.Checks)
+	if err != nil {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck, err.Error())
+	}
+	if len(checks) == 0 {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("Scenario %s has no graded checks", history.Spec.ScenarioID))
+	}
+
+	now := metav1.Now()
+	status := &scenarioCheck.Status
+	status.Phase = devopsbeererv1beta1.ScenarioCheckPhaseRunning
+	status.ScenarioID = history.Spec.ScenarioID
+	status.History = history.Name
+	status.StartTime = &now
+	status.Message = fmt.Sprintf("Running %d checks", len(checks))
+	status.Results = nil
+	status.MaxScore = 0
+	for _, check := range checks {
+		status.MaxScore += check.Points
+		status.Results = append(status.Results, devopsbeererv1beta1.CheckResult{
+			Name:     check.Name,
+			Phase:    devopsbeererv1beta1.CheckResultPhasePending,
+			MaxScore: check.Points,
+		})
+	}
+	if err := r.Status().Update(ctx, scenarioCheck); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// selectChecks returns the graded checks named, or every check when none is
+func selectChecks(checks []devopsbeererv1beta1.GradedCheck, names []string) ([]devopsbeererv1beta1.GradedCheck, error) {
+	if len(names) == 0 {
+		return checks, nil
+	}
+	var selected []devopsbeererv1beta1.GradedCheck
+	for _, name := range names {
+		i := slices.IndexFunc(checks, func(check devopsbeererv1beta1.GradedCheck) bool { return check.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("the scenario has no check %s", name)
+		}
+		selected = append(selected, checks[i])
+	}
+	return selected, nil
+}
+
+// runChecks runs the checks not done yet, and records the score once every
+// check is done. HTTP checks only request Services of the scenario namespace,
+// so that grading cannot read other addresses through the operator.
+func (r *ScenarioCheckReconciler) runChecks(ctx context.Context,
+	scenarioCheck *devopsbeererv1beta1.ScenarioCheck) (ctrl.Result, error) {
+
+	status := &scenarioCheck.Status
+	history := &devopsbeererv1beta1.ScenarioHistory{}
+	err := r.Get(ctx, client.ObjectKey{Name: status.History}, history)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("ScenarioHistory %s not found", status.History))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if history.Status.Phase != devopsbeererv1beta1.ScenarioHistoryPhaseActive {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("Scenario %s was uninstalled before the checks completed", status.ScenarioID))
+	}
+
+	scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{}
+	err = r.Get(ctx, client.ObjectKey{Name: status.ScenarioID}, scenarioDef)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, r.checksFailed(ctx, scenarioCheck,
+			fmt.Sprintf("ScenarioDefinition %s not found", status.ScenarioID))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	running := false
+	for i := range status.Results {
+		result := &status.Results[i]
+		if result.Phase == devopsbeererv1beta1.CheckResultPhasePassed ||
+			result.Phase == devopsbeererv1beta1.CheckResultPhaseFailed {
+			continue
+		}
+		if result.StartedAt == nil {
+			result.StartedAt = ptr.To(metav1.Now())
+		}
+
+		j := slices.IndexFunc(scenarioDef.Spec.Checks, func(check devopsbeererv1beta1.GradedCheck) bool {
+			return check.Name == result.Name
+		})
+		switch {
+		case j < 0:
+			r.finishCheck(scenarioCheck, result, false, "The check was removed from the scenario")
+		case scenarioDef.Spec.Checks[j].HTTP != nil:
+			passed, message := verifyHTTP(ctx, r.Client, history.Spec.Namespace, scenarioDef.Spec.Checks[j].HTTP)
+			r.finishCheck(scenarioCheck, result, passed, message)
+		case scenarioDef.Spec.Checks[j].Job != nil:
+			done, err := r.runCheckJob(ctx, scenarioCheck, result, &scenarioDef.Spec.Checks[j], history)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			running = running || !done
+		default:
+			r.finishCheck(scenarioCheck, result, false, "The check has nothing to run")
+		}
+	}
+
+	if running {
+		if err := r.Status().Update(ctx, scenarioCheck); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: checkPollInterval}, nil
+	}
+
+	now := metav1.Now()
+	status.Score = 0
+	status.MaxScore = 0
+	for _, result := range status.Results {
+		status.Score += result.Score
+		status.MaxScore += result.MaxScore
+	}
+	status.CompletionTime = &now
+	if err := r.recordScore(ctx, scenarioCheck, history); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to record score on history: %w", err)
+	}
+
+	status.Phase = devopsbeererv1beta1.ScenarioCheckPhaseCompleted
+	status.Message = fmt.Sprintf("Scored %d of %d", status.Score, status.MaxScore)
+	r.Recorder.Eventf(scenarioCheck, corev1.EventTypeNormal, EventReasonScenarioGraded,
+		"Scenario %s scored %d of %d", status.ScenarioID, status.Score, status.MaxScore)
+	return ctrl.Result{}, r.Status().Update(ctx, scenarioCheck)
+}
+
+// runCheckJob starts the Job of a graded check, or looks at the Job started.
+// It returns whether the check is done.
+func (r *ScenarioCheckReconciler) runCheckJob(ctx context.Context,
+	scenarioCheck *devopsbeererv1beta1.ScenarioCheck, result *devopsbeererv1beta1.CheckResult,
+	check *devopsbeererv1beta1.GradedCheck, history *devopsbeererv1beta1.ScenarioHistory) (bool, error) {
+
+	if result.Job == "" {
+		job := scenarioJob(check.Job, history.Spec.HelmRelease+"-check-"+check.Name, history.Spec.Namespace,
+			map[string]string{
+				scenarioLabel:      history.Spec.ScenarioID,
+				managedLabel:       "true",
+				scenarioCheckLabel: scenarioCheck.Name,
+				gradedCheckLabel:   check.Name,
+			})
+		// Jobs are garbage collected with their ScenarioCheck
+		if err := controllerutil.SetControllerReference(scenarioCheck, job, r.Scheme); err != nil {
+			return false, err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			return false, fmt.Errorf("failed to create Job of check %s: %w", check.Name, err)
+		}
+		result.Job = job.Name
+		result.Phase = devopsbeererv1beta1.CheckResultPhaseRunning
+		result.Message = fmt.Sprintf("Job %s started", job.Name)
+		return false, nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: history.Spec.Namespace, Name: result.Job}, job)
+	if errors.IsNotFound(err) {
+		r.finishCheck(scenarioCheck, result, false, fmt.Sprintf("Job %s was deleted", result.Job))
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	completed, err := jobOutcome(job)
+	if !completed && err == nil {
+		timeout := defaultCheckTimeout
+		if check.Timeout != nil {
+			timeout = check.Timeout.Duration
+		}
+		if time.Since(result.StartedAt.Time) < timeout {
+			return false, nil
+		}
+		result.Logs = r.jobLogs(ctx, job)
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		r.finishCheck(scenarioCheck, result, false, fmt.Sprintf("Job %s timed out after %s", job.Name, timeout))
+		return true, nil
+	}
+
+	result.Logs = r.jobLogs(ctx, job)
+	if err != nil {
+		r.finishCheck(scenarioCheck, result, false, err.Error())
+	} else {
+		r.finishCheck(scenarioCheck, result, true, fmt.Sprintf("Job %s completed", job.Name))
+	}
+	return true, nil
+}
+
+// jobLogs returns the last lines logged by the latest pod of a Job. Logs are
+// informative, failing to read them is only logged.
+func (r *ScenarioCheckReconciler) jobLogs(ctx context.Context, job *batchv1.Job) string {
+	if r.Clientset == nil {
+		return ""
+	}
+	log := log.FromContext(ctx)
+
+	pods, err := r.Clientset.CoreV1().Pods(job.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: batchv1.JobNameLabel + "=" + job.Name,
+	})
+	if err != nil {
+		log.Error(err, "Failed to list pods of check Job", "job", job.Name)
+		return ""
+	}
+	if len(pods.Items) == 0 {
+		return ""
+	}
+	latest := slices.MaxFunc(pods.Items, func(a, b corev1.Pod) int {
+		return a.CreationTimestamp.Compare(b.CreationTimestamp.Time)
+	})
+
+	logs, err := r.Clientset.CoreV1().Pods(job.Namespace).GetLogs(latest.Name, &corev1.PodLogOptions{
+		TailLines:  ptr.To(int64(checkLogLines)),
+		LimitBytes: ptr.To(int64(maxCheckLogs)),
+	}).DoRaw(ctx)
+	if err != nil {
+		log.Error(err, "Failed to read logs of check Job", "job", job.Name, "pod", latest.Name)
+		return ""
+	}
+	return string(logs)
+}
+
+// finishCheck records the outcome of a graded check
+func (r *ScenarioCheckReconciler) finishCheck(scenarioCheck *devopsbeererv1beta1.ScenarioCheck,
+	result *devopsbeererv1beta1.CheckResult, passed bool, message string) {
+
+	now := metav1.Now()
+	result.CompletedAt = &now
+	result.Message = truncateMessage(message)
+	if passed {
+		result.Phase = devopsbeererv1beta1.CheckResultPhasePassed
+		result.Score = result.MaxScore
+		r.Recorder.Eventf(scenarioCheck, corev1.EventTypeNormal, EventReasonCheckPassed,
+			"Check '%s' passed: %s", result.Name, result.Message)
+		return
+	}
+	result.Phase = devopsbeererv1beta1.CheckResultPhaseFailed
+	result.Score = 0
+	r.Recorder.Eventf(scenarioCheck, corev1.EventTypeWarning, EventReasonCheckFailed,
+		"Check '%s' failed: %s", result.Name, result.Message)
+}
+
+// recordScore records the score of a ScenarioCheck on the history of the
+// scenario, where it is kept once the scenario is archived
+func (r *ScenarioCheckReconciler) recordScore(ctx context.Context,
+	scenarioCheck *devopsbeererv1beta1.ScenarioCheck, history *devopsbeererv1beta1.ScenarioHistory) error {
+
+	status := scenarioCheck.Status
+	attempts := int32(1)
+	if previous := history.Status.Score; previous != nil {
+		attempts = previous.Attempts + 1
+		// Already recorded by a reconciliation that failed to update the
+		// ScenarioCheck
+		if previous.Check == scenarioCheck.Name {
+			attempts = previous.Attempts
+		}
+	}
+
+	score := &devopsbeererv1beta1.ScenarioScore{
+		Score:    status.Score,
+		MaxScore: status.MaxScore,
+		Check:    scenarioCheck.Name,
+		GradedAt: *status.CompletionTime,
+		Attempts: attempts,
+	}
+	for _, result := range status.Results {
+		score.Checks = append(score.Checks, devopsbeererv1beta1.CheckScore{
+			Name:     result.Name,
+			Passed:   result.Phase == devopsbeererv1beta1.CheckResultPhasePassed,
+			Score:    result.Score,
+			MaxScore: result.MaxScore,
+		})
+	}
+	history.Status.Score = score
+	return r.Status().Update(ctx, history)
+}
+
+// checksFailed fails a ScenarioCheck that cannot be run
+func (r *ScenarioCheckReconciler) checksFailed(ctx context.Context,
+	scenarioCheck *devopsbeererv1beta1.ScenarioCheck, message string) error {
+
+	now := metav1.Now()
+	scenarioCheck.Status.Phase = devopsbeererv1beta1.ScenarioCheckPhaseFailed
+	scenarioCheck.Status.Message = truncateMessage(message)
+	scenarioCheck.Status.CompletionTime = &now
+	r.Recorder.Event(scenarioCheck, corev1.EventTypeWarning, EventReasonGradingFailed, scenarioCheck.Status.Message)
+	return r.Status().Update(ctx, scenarioCheck)
+}
+
+// SetupWithManager sets up the controller with the Manager. ScenarioChecks own
+// the Jobs of their checks, whose updates trigger a reconciliation.
+func (r *ScenarioCheckReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// The controller requeues itself, status updates must not trigger a
+		// reconciliation
+		For(&devopsbeererv1beta1.ScenarioCheck{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+func TestRunChecksHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("beers"))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const namespace = "devopsbeerer-basic-oauth2"
+
+	tests := map[string]struct {
+		url         string
+		wantPhase   devopsbeererv1beta1.CheckResultPhase
+		wantMessage string
+	}{
+		"service of the scenario": {
+			url:         "http://beer-api." + namespace + ".svc:" + serverURL.Port(),
+			wantPhase:   devopsbeererv1beta1.CheckResultPhasePassed,
+			wantMessage: "HTTP 200",
+		},
+		"address outside the cluster": {
+			url:       server.URL,
+			wantPhase: devopsbeererv1beta1.CheckResultPhaseFailed,
+			wantMessage: `Invalid URL: host "` + serverURL.Hostname() +
+				`" is not a Service of namespace ` + namespace,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if err := clientgoscheme.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			if err := devopsbeererv1beta1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+
+			scenarioCheck := &devopsbeererv1beta1.ScenarioCheck{
+				ObjectMeta: metav1.ObjectMeta{Name: "grade"},
+				Spec:       devopsbeererv1beta1.ScenarioCheckSpec{ActiveScenario: "active"},
+				Status: devopsbeererv1beta1.ScenarioCheckStatus{
+					Phase:      devopsbeererv1beta1.ScenarioCheckPhaseRunning,
+					ScenarioID: "basic-oauth2",
+					History:    "history-basic-oauth2",
+					Results: []devopsbeererv1beta1.CheckResult{{
+						Name:     "beers",
+						Phase:    devopsbeererv1beta1.CheckResultPhasePending,
+						MaxScore: 1,
+					}},
+				},
+			}
+			scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "basic-oauth2"},
+				Spec: devopsbeererv1beta1.ScenarioDefinitionSpec{
+					ID: "basic-oauth2",
+					Checks: []devopsbeererv1beta1.GradedCheck{{
+						Name:   "beers",
+						Points: 1,
+						HTTP:   &devopsbeererv1beta1.HTTPVerification{URL: tt.url, BodyContains: "beers"},
+					}},
+				},
+			}
+			history := &devopsbeererv1beta1.ScenarioHistory{
+				ObjectMeta: metav1.ObjectMeta{Name: "history-basic-oauth2"},
+				Spec: devopsbeererv1beta1.ScenarioHistorySpec{
+					ScenarioID: "basic-oauth2",
+					Namespace:  namespace,
+				},
+				Status: devopsbeererv1beta1.ScenarioHistoryStatus{Phase: devopsbeererv1beta1.ScenarioHistoryPhaseActive},
+			}
+			// The test server answers on the cluster IP of the scenario Service
+			service := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "beer-api", Namespace: namespace},
+				Spec:       corev1.ServiceSpec{ClusterIP: serverURL.Hostname()},
+			}
+
+			r := &ScenarioCheckReconciler{
+				Client: fake.NewClientBuilder().
+					WithScheme(scheme).
+					WithObjects(scenarioCheck, scenarioDef, history, service).
+					WithStatusSubresource(&devopsbeererv1beta1.ScenarioCheck{}, &devopsbeererv1beta1.ScenarioHistory{}).
+					Build(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: types.NamespacedName{Name: scenarioCheck.Name},
+			}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			got := &devopsbeererv1beta1.ScenarioCheck{}
+			if err := r.Get(context.Background(), types.NamespacedName{Name: scenarioCheck.Name}, got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != devopsbeererv1beta1.ScenarioCheckPhaseCompleted {
+				t.Fatalf("phase = %s, want %s", got.Status.Phase, devopsbeererv1beta1.ScenarioCheckPhaseCompleted)
+			}
+			result := got.Status.Results[0]
+			if result.Phase != tt.wantPhase || result.Message != tt.wantMessage {
+				t.Errorf("result = %s, %q, want %s, %q", result.Phase, result.Message, tt.wantPhase, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/metrics"
+	"github.com/devopsbeerer/operator/internal/retention"
+)
+
+// ScenarioHistoryReconciler keeps the labels of ScenarioHistory objects in sync
+// with their phase and prunes the archived entries of a scenario according to
+// the retention policy
+type ScenarioHistoryReconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+
+	// Retention decides which archived entries are pruned, nothing is pruned
+	// when it is not enabled
+	Retention retention.Policy
+
+	// Archiver exports pruned entries before they are deleted, when set
+	Archiver retention.Archiver
+}
+
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariohistories,verbs=get;list;watch;update;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;create;update
+
+// Reconcile labels a ScenarioHistory and enforces the retention policy on its
+// scenario
+func (r *ScenarioHistoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	history := &devopsbeererv1beta1.ScenarioHistory{}
+	if err := r.Get(ctx, req.NamespacedName, history); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Label the history, including histories created before labels were set
+	if labels := historyLabels(history); !maps.Equal(labels, history.Labels) {
+		history.Labels = labels
+		if err := r.Update(ctx, history); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !r.Retention.Enabled() {
+		return ctrl.Result{}, nil
+	}
+
+	histories, err := r.scenarioHistories(ctx, history.Spec.ScenarioID)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	prune, next := r.Retention.Select(histories, time.Now())
+	if len(prune) > 0 {
+		if r.Archiver != nil {
+			if err := r.Archiver.Archive(ctx, prune); err != nil {
+				metrics.HistoriesPruned.WithLabelValues(history.Spec.ScenarioID, metrics.ResultFailure).Add(float64(len(prune)))
+				r.Recorder.Eventf(history, corev1.EventTypeWarning, EventReasonHistoryFailed,
+					"Failed to archive %d pruned histories: %v", len(prune), err)
+				return ctrl.Result{}, fmt.Errorf("failed to archive histories: %w", err)
+			}
+		}
+
+		for _, pruned := range prune {
+			if err := r.Delete(ctx, pruned); err != nil && !errors.IsNotFound(err) {
+				metrics.HistoriesPruned.WithLabelValues(history.Spec.ScenarioID, metrics.ResultFailure).Inc()
+				return ctrl.Result{}, fmt.Errorf("failed to delete history %s: %w", pruned.Name, err)
+			}
+			metrics.HistoriesPruned.WithLabelValues(history.Spec.ScenarioID, metrics.ResultSuccess).Inc()
+			log.Info("Pruned history", "history", pruned.Name, "scenarioId", pruned.Spec.ScenarioID)
+		}
+	}
+
+	// Come back when the next entry reaches its maximum age
+	return ctrl.Result{RequeueAfter: next}, nil
+}
+
+// scenarioHistories returns the ScenarioHistory entries of a scenario
+func (r *ScenarioHistoryReconciler) scenarioHistories(ctx context.Context,
+	scenarioID string) ([]devopsbeererv1beta1.ScenarioHistory, error) {
+
+	historyList := &devopsbeererv1beta1.ScenarioHistoryList{}
+	if err := r.List(ctx, historyList, client.MatchingLabels{scenarioLabel: scenarioID}); err != nil {
+		return nil, err
+	}
+	return historyList.Items, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ScenarioHistoryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&devopsbeererv1beta1.ScenarioHistory{}).
+		WithOptions(controller.Options{
+			// Histories of a scenario are pruned together
+			MaxConcurrentReconciles: 1,
+		}).
+		Complete(r)
+}
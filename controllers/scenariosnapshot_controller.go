@@ -0,0 +1,672 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/naming"
+	"github.com/devopsbeerer/operator/internal/snapshot"
+)
+
+const (
+	// snapshotLabel holds the name of the ScenarioSnapshot an object was
+	// created for
+	snapshotLabel = "devopsbeerer.io/snapshot"
+	// snapshotFinalizer releases the storage retained for a snapshot
+	snapshotFinalizer = "devopsbeerer.io/snapshot"
+	// snapshotPollInterval is how often the volumes being captured are looked at
+	snapshotPollInterval = 10 * time.Second
+)
+
+var (
+	// volumeSnapshotGVK and volumeSnapshotContentGVK are the CSI snapshot
+	// API, read as unstructured so that clusters without it are supported
+	volumeSnapshotGVK = schema.GroupVersionKind{
+		Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshot",
+	}
+	volumeSnapshotContentGVK = schema.GroupVersionKind{
+		Group: "snapshot.storage.k8s.io", Version: "v1", Kind: "VolumeSnapshotContent",
+	}
+)
+
+// ScenarioSnapshotReconciler captures the state of a running scenario for a
+// ScenarioSnapshot: the ConfigMaps, Secrets and PersistentVolumeClaims of its
+// namespace, saved in a Secret, and the data of the claims, retained in
+// VolumeSnapshots or copied volumes that outlive the scenario namespace
+type ScenarioSnapshotReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// APIReader lists the pods using a claim, which are not cached
+	APIReader client.Reader
+	Recorder  record.EventRecorder
+
+	// Namespace holds the Secrets of the objects captured by snapshots
+	Namespace string
+}
+
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariosnapshots,verbs=get;list;watch;update
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariosnapshots/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=devopsbeerer.io,resources=scenariosnapshots/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;create;update,namespace=devopsbeerer-snapshots
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=persistentvolumes,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotcontents,verbs=get;list;create;update;delete
+
+// The ConfigMaps, Secrets, claims, pods and VolumeSnapshots of a scenario are
+// captured and restored through the scenario ClusterRole the ActiveScenario
+// controller binds in its namespace. Only the volumes and the snapshot
+// contents, which are cluster-scoped, are written with cluster-wide rights.
+
+// Reconcile captures a ScenarioSnapshot once. A snapshot that is ready or
+// failed is left as is until it is deleted.
+func (r *ScenarioSnapshotReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	scenarioSnapshot := &devopsbeererv1beta1.ScenarioSnapshot{}
+	if err := r.Get(ctx, req.NamespacedName, scenarioSnapshot); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !scenarioSnapshot.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(scenarioSnapshot, snapshotFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.releaseSnapshot(ctx, scenarioSnapshot); err != nil {
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(scenarioSnapshot, snapshotFinalizer)
+		return ctrl.Result{}, r.Update(ctx, scenarioSnapshot)
+	}
+
+	switch scenarioSnapshot.Status.Phase {
+	case devopsbeererv1beta1.ScenarioSnapshotPhaseReady, devopsbeererv1beta1.ScenarioSnapshotPhaseFailed:
+		return ctrl.Result{}, nil
+	case devopsbeererv1beta1.ScenarioSnapshotPhaseCapturing:
+		return r.captureVolumes(ctx, scenarioSnapshot)
+	default:
+		return r.startSnapshot(ctx, scenarioSnapshot)
+	}
+}
+
+// startSnapshot captures the objects of the scenario namespace and lists the
+// claims whose data is captured
+func (r *ScenarioSnapshotReconciler) startSnapshot(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot) (ctrl.Result, error) {
+
+	// Storage is retained for the snapshot from now on
+	if !controllerutil.ContainsFinalizer(scenarioSnapshot, snapshotFinalizer) {
+		controllerutil.AddFinalizer(scenarioSnapshot, snapshotFinalizer)
+		if err := r.Update(ctx, scenarioSnapshot); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	activeScenario := &devopsbeererv1beta1.ActiveScenario{}
+	err := r.Get(ctx, client.ObjectKey{Name: scenarioSnapshot.Spec.ActiveScenario}, activeScenario)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, r.snapshotFailed(ctx, scenarioSnapshot,
+			fmt.Sprintf("ActiveScenario %s not found", scenarioSnapshot.Spec.ActiveScenario))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if activeScenario.Status.Phase != devopsbeererv1beta1.ActiveScenarioPhaseRunning {
+		return ctrl.Result{}, r.snapshotFailed(ctx, scenarioSnapshot,
+			fmt.Sprintf("ActiveScenario %s is not running", activeScenario.Name))
+	}
+	history, err := runningHistory(ctx, r.Client, activeScenario)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if history == nil {
+		return ctrl.Result{}, r.snapshotFailed(ctx, scenarioSnapshot,
+			fmt.Sprintf("No history of scenario %s is active", activeScenario.Spec.ScenarioID))
+	}
+	namespace := history.Spec.Namespace
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := r.List(ctx, configMaps, client.InNamespace(namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+	claims := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, claims, client.InNamespace(namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	status := &scenarioSnapshot.Status
+	status.ConfigMaps, status.Secrets, status.Volumes = nil, nil, nil
+	data := &snapshot.Data{}
+	for _, configMap := range configMaps.Items {
+		if snapshot.CapturedConfigMap(&configMap) {
+			data.AddConfigMap(&configMap)
+			status.ConfigMaps = append(status.ConfigMaps, configMap.Name)
+		}
+	}
+	for _, secret := range secrets.Items {
+		if snapshot.CapturedSecret(&secret) {
+			data.AddSecret(&secret)
+			status.Secrets = append(status.Secrets, secret.Name)
+		}
+	}
+
+	method, err := r.volumeMethod(scenarioSnapshot)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Claims never bound hold no data, they are restored empty
+	for _, claim := range claims.Items {
+		data.AddClaim(&claim)
+		if claim.Status.Phase == corev1.ClaimBound {
+			status.Volumes = append(status.Volumes, devopsbeererv1beta1.VolumeSnapshotStatus{
+				Claim:  claim.Name,
+				Method: method,
+			})
+		}
+	}
+
+	dataSecret, err := r.saveData(ctx, scenarioSnapshot, data)
+	if err != nil {
+		return ctrl.Result{}, r.snapshotFailed(ctx, scenarioSnapshot, fmt.Sprintf("Failed to save the objects: %v", err))
+	}
+
+	status.Phase = devopsbeererv1beta1.ScenarioSnapshotPhaseCapturing
+	status.ScenarioID = history.Spec.ScenarioID
+	status.History = history.Name
+	status.Namespace = namespace
+	status.HelmRelease = history.Spec.HelmRelease
+	status.Parameters = activeScenario.Spec.Parameters
+	status.Values = history.Spec.Values
+	status.Data = &corev1.SecretReference{Namespace: dataSecret.Namespace, Name: dataSecret.Name}
+	status.Message = fmt.Sprintf("Capturing %d volumes", len(status.Volumes))
+	if err := r.Status().Update(ctx, scenarioSnapshot); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// saveData saves the objects captured in a Secret of the snapshot namespace,
+// garbage collected with the snapshot
+func (r *ScenarioSnapshotReconciler) saveData(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot, data *snapshot.Data) (*corev1.Secret, error) {
+
+	encoded, err := data.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: r.Namespace}}
+	if err := r.Create(ctx, ns); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create namespace %s: %w", r.Namespace, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      naming.Truncate("snapshot-"+scenarioSnapshot.Name, 253),
+			Namespace: r.Namespace,
+			Labels: map[string]string{
+				managedLabel:  "true",
+				snapshotLabel: scenarioSnapshot.Name,
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: encoded,
+	}
+	if err := controllerutil.SetControllerReference(scenarioSnapshot, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+	err = r.Create(ctx, secret)
+	if errors.IsAlreadyExists(err) {
+		existing := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(secret), existing); err != nil {
+			return nil, err
+		}
+		existing.Data = encoded
+		return existing, r.Update(ctx, existing)
+	}
+	return secret, err
+}
+
+// volumeMethod returns how the data of claims is captured, VolumeSnapshots
+// being preferred when the cluster serves them
+func (r *ScenarioSnapshotReconciler) volumeMethod(
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot) (devopsbeererv1beta1.VolumeCaptureMethod, error) {
+
+	method := scenarioSnapshot.Spec.VolumeMethod
+	if method != "" && method != devopsbeererv1beta1.VolumeCaptureMethodAuto {
+		return method, nil
+	}
+	_, err := r.RESTMapper().RESTMapping(volumeSnapshotGVK.GroupKind(), volumeSnapshotGVK.Version)
+	switch {
+	case meta.IsNoMatchError(err):
+		return devopsbeererv1beta1.VolumeCaptureMethodCopy, nil
+	case err != nil:
+		return "", err
+	}
+	return devopsbeererv1beta1.VolumeCaptureMethodVolumeSnapshot, nil
+}
+
+// captureVolumes captures the data of the claims not captured yet
+func (r *ScenarioSnapshotReconciler) captureVolumes(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot) (ctrl.Result, error) {
+
+	status := &scenarioSnapshot.Status
+	history := &devopsbeererv1beta1.ScenarioHistory{}
+	if err := r.Get(ctx, client.ObjectKey{Name: status.History}, history); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	if history.Status.Phase != devopsbeererv1beta1.ScenarioHistoryPhaseActive {
+		return ctrl.Result{}, r.snapshotFailed(ctx, scenarioSnapshot,
+			fmt.Sprintf("Scenario %s was uninstalled before its volumes were captured", status.ScenarioID))
+	}
+
+	capturing := 0
+	for i := range status.Volumes {
+		volume := &status.Volumes[i]
+		if volume.Ready {
+			continue
+		}
+		var failure string
+		var err error
+		switch volume.Method {
+		case devopsbeererv1beta1.VolumeCaptureMethodVolumeSnapshot:
+			failure, err = r.captureVolumeSnapshot(ctx, scenarioSnapshot, volume)
+		default:
+			failure, err = r.captureCopy(ctx, scenarioSnapshot, volume)
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if failure != "" {
+			return ctrl.Result{}, r.snapshotFailed(ctx, scenarioSnapshot,
+				fmt.Sprintf("Failed to capture claim %s: %s", volume.Claim, failure))
+		}
+		if !volume.Ready {
+			capturing++
+		}
+	}
+
+	if capturing > 0 {
+		status.Message = fmt.Sprintf("Capturing %d volumes", capturing)
+		if err := r.Status().Update(ctx, scenarioSnapshot); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: snapshotPollInterval}, nil
+	}
+
+	now := metav1.Now()
+	status.Phase = devopsbeererv1beta1.ScenarioSnapshotPhaseReady
+	status.CapturedAt = &now
+	status.Message = fmt.Sprintf("Captured %d ConfigMaps, %d Secrets and %d volumes",
+		len(status.ConfigMaps), len(status.Secrets), len(status.Volumes))
+	r.Recorder.Eventf(scenarioSnapshot, corev1.EventTypeNormal, EventReasonSnapshotReady,
+		"Captured scenario %s: %s", status.ScenarioID, status.Message)
+	return ctrl.Result{}, r.Status().Update(ctx, scenarioSnapshot)
+}
+
+// captureVolumeSnapshot takes a VolumeSnapshot of a claim and, once it is
+// ready, retains its content so that it outlives the scenario namespace. It
+// returns why the capture failed, if it did.
+func (r *ScenarioSnapshotReconciler) captureVolumeSnapshot(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot,
+	volume *devopsbeererv1beta1.VolumeSnapshotStatus) (string, error) {
+
+	namespace := scenarioSnapshot.Status.Namespace
+	if volume.VolumeSnapshot == "" {
+		volumeSnapshot := &unstructured.Unstructured{}
+		volumeSnapshot.SetGroupVersionKind(volumeSnapshotGVK)
+		volumeSnapshot.SetName(naming.Truncate(scenarioSnapshot.Name+"-"+volume.Claim, 253))
+		volumeSnapshot.SetNamespace(namespace)
+		volumeSnapshot.SetLabels(map[string]string{managedLabel: "true", snapshotLabel: scenarioSnapshot.Name})
+		spec := map[string]any{"source": map[string]any{"persistentVolumeClaimName": volume.Claim}}
+		if class := scenarioSnapshot.Spec.VolumeSnapshotClassName; class != "" {
+			spec["volumeSnapshotClassName"] = class
+		}
+		volumeSnapshot.Object["spec"] = spec
+		if err := r.Create(ctx, volumeSnapshot); err != nil && !errors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("failed to create VolumeSnapshot: %w", err)
+		}
+		volume.VolumeSnapshot = volumeSnapshot.GetName()
+		return "", nil
+	}
+
+	volumeSnapshot := &unstructured.Unstructured{}
+	volumeSnapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: volume.VolumeSnapshot}, volumeSnapshot)
+	if errors.IsNotFound(err) {
+		return fmt.Sprintf("VolumeSnapshot %s was deleted", volume.VolumeSnapshot), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if message, _, _ := unstructured.NestedString(volumeSnapshot.Object, "status", "error", "message"); message != "" {
+		return message, nil
+	}
+	if ready, _, _ := unstructured.NestedBool(volumeSnapshot.Object, "status", "readyToUse"); !ready {
+		return "", nil
+	}
+
+	contentName, _, _ := unstructured.NestedString(volumeSnapshot.Object, "status", "boundVolumeSnapshotContentName")
+	content := &unstructured.Unstructured{}
+	content.SetGroupVersionKind(volumeSnapshotContentGVK)
+	if err := r.Get(ctx, client.ObjectKey{Name: contentName}, content); err != nil {
+		return "", fmt.Errorf("failed to get VolumeSnapshotContent %s: %w", contentName, err)
+	}
+	if policy, _, _ := unstructured.NestedString(content.Object, "spec", "deletionPolicy"); policy != "Retain" {
+		if err := unstructured.SetNestedField(content.Object, "Retain", "spec", "deletionPolicy"); err != nil {
+			return "", err
+		}
+		if err := r.Update(ctx, content); err != nil {
+			return "", fmt.Errorf("failed to retain VolumeSnapshotContent %s: %w", contentName, err)
+		}
+	}
+
+	volume.VolumeSnapshotContent = contentName
+	volume.Driver, _, _ = unstructured.NestedString(content.Object, "spec", "driver")
+	volume.VolumeSnapshotClassName, _, _ = unstructured.NestedString(content.Object, "spec", "volumeSnapshotClassName")
+	volume.SnapshotHandle, _, _ = unstructured.NestedString(content.Object, "status", "snapshotHandle")
+	if size, _, _ := unstructured.NestedString(volumeSnapshot.Object, "status", "restoreSize"); size != "" {
+		if quantity, err := resource.ParseQuantity(size); err == nil {
+			volume.Size = &quantity
+		}
+	}
+	volume.Ready = true
+	return "", nil
+}
+
+// captureCopy copies the data of a claim with a Job into a new claim and, once
+// copied, retains its volume and releases it so that it outlives the scenario
+// namespace. It returns why the capture failed, if it did.
+func (r *ScenarioSnapshotReconciler) captureCopy(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot,
+	volume *devopsbeererv1beta1.VolumeSnapshotStatus) (string, error) {
+
+	namespace := scenarioSnapshot.Status.Namespace
+	labels := map[string]string{
+		scenarioLabel: scenarioSnapshot.Status.ScenarioID,
+		managedLabel:  "true",
+		snapshotLabel: scenarioSnapshot.Name,
+	}
+	backup := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      naming.Truncate("snapshot-"+scenarioSnapshot.Name+"-"+volume.Claim, 253),
+			Namespace: namespace,
+			Labels:    labels,
+		},
+	}
+
+	if volume.Job == "" {
+		claim := &corev1.PersistentVolumeClaim{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: volume.Claim}, claim)
+		if errors.IsNotFound(err) {
+			return fmt.Sprintf("claim %s was deleted", volume.Claim), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		if capacity, ok := claim.Status.Capacity[corev1.ResourceStorage]; ok {
+			size = capacity
+		}
+		backup.Spec = corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources:        corev1.VolumeResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: size}},
+			StorageClassName: claim.Spec.StorageClassName,
+			VolumeMode:       claim.Spec.VolumeMode,
+		}
+		if err := r.Create(ctx, backup); err != nil && !errors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("failed to create claim %s: %w", backup.Name, err)
+		}
+
+		// A ReadWriteOnce claim in use can only be mounted on the node of its pods
+		node, err := r.claimNode(ctx, namespace, volume.Claim)
+		if err != nil {
+			return "", err
+		}
+		job := copyJob(scenarioSnapshot.Spec.CopyImage, scenarioSnapshot.Name+"-"+volume.Claim, namespace, labels,
+			volume.Claim, backup.Name, node)
+		if err := controllerutil.SetControllerReference(scenarioSnapshot, job, r.Scheme); err != nil {
+			return "", err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			return "", fmt.Errorf("failed to create copy Job: %w", err)
+		}
+		volume.Job = job.Name
+		volume.Size = &size
+		return "", nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: volume.Job}, job)
+	if errors.IsNotFound(err) {
+		return fmt.Sprintf("copy Job %s was deleted", volume.Job), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	completed, err := jobOutcome(job)
+	if err != nil {
+		return err.Error(), nil
+	}
+	if !completed {
+		return "", nil
+	}
+
+	if err := r.Get(ctx, client.ObjectKeyFromObject(backup), backup); err != nil {
+		return "", fmt.Errorf("failed to get claim %s: %w", backup.Name, err)
+	}
+	if backup.Spec.VolumeName == "" {
+		return fmt.Sprintf("claim %s is not bound", backup.Name), nil
+	}
+	volumeName := backup.Spec.VolumeName
+	persistentVolume := &corev1.PersistentVolume{}
+	if err := r.Get(ctx, client.ObjectKey{Name: volumeName}, persistentVolume); err != nil {
+		return "", fmt.Errorf("failed to get volume %s: %w", volumeName, err)
+	}
+	if persistentVolume.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimRetain {
+		persistentVolume.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimRetain
+		if err := r.Update(ctx, persistentVolume); err != nil {
+			return "", fmt.Errorf("failed to retain volume %s: %w", volumeName, err)
+		}
+	}
+
+	// The claim is only released once the pod of the Job is gone
+	if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return "", err
+	}
+	if err := r.Delete(ctx, backup); client.IgnoreNotFound(err) != nil {
+		return "", err
+	}
+	volume.PersistentVolume = volumeName
+	volume.Ready = true
+	return "", nil
+}
+
+// claimNode returns the node a pod using a claim runs on, if any
+func (r *ScenarioSnapshotReconciler) claimNode(ctx context.Context, namespace, claim string) (string, error) {
+	pods := &corev1.PodList{}
+	if err := r.APIReader.List(ctx, pods, client.InNamespace(namespace)); err != nil {
+		return "", fmt.Errorf("failed to list pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if slices.ContainsFunc(pod.Spec.Volumes, func(volume corev1.Volume) bool {
+			return volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claim
+		}) {
+			return pod.Spec.NodeName, nil
+		}
+	}
+	return "", nil
+}
+
+// copyJob builds a Job copying the data of the source claim into the target
+// claim, on node when set
+func copyJob(image, prefix, namespace string, labels map[string]string, source, target, node string) *batchv1.Job {
+	template := &batchv1.JobTemplateSpec{
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To(int32(2)),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:    "copy",
+						Image:   image,
+						Command: []string{"sh", "-c", "cp -a /source/. /target/"},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "source", MountPath: "/source", ReadOnly: true},
+							{Name: "target", MountPath: "/target"},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{Name: "source", VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: source},
+						}},
+						{Name: "target", VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: target},
+						}},
+					},
+				},
+			},
+		},
+	}
+	if node != "" {
+		template.Spec.Template.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{node},
+					}},
+				}},
+			},
+		}}
+	}
+	return scenarioJob(template, prefix+"-copy", namespace, labels)
+}
+
+// releaseSnapshot deletes the storage retained for a snapshot: the contents
+// of its VolumeSnapshots, along with the contents restores created from them,
+// and its copied volumes
+func (r *ScenarioSnapshotReconciler) releaseSnapshot(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot) error {
+
+	log := log.FromContext(ctx)
+
+	for _, volume := range scenarioSnapshot.Status.Volumes {
+		if volume.VolumeSnapshotContent != "" {
+			content := &unstructured.Unstructured{}
+			content.SetGroupVersionKind(volumeSnapshotContentGVK)
+			err := r.Get(ctx, client.ObjectKey{Name: volume.VolumeSnapshotContent}, content)
+			if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+				return err
+			}
+			if err == nil {
+				// Deletes the snapshot in the storage system along with the content
+				if err := unstructured.SetNestedField(content.Object, "Delete", "spec", "deletionPolicy"); err != nil {
+					return err
+				}
+				if err := r.Update(ctx, content); err != nil {
+					return fmt.Errorf("failed to release VolumeSnapshotContent %s: %w", content.GetName(), err)
+				}
+				if err := r.Delete(ctx, content); client.IgnoreNotFound(err) != nil {
+					return err
+				}
+			}
+		}
+
+		if volume.PersistentVolume != "" {
+			persistentVolume := &corev1.PersistentVolume{}
+			err := r.Get(ctx, client.ObjectKey{Name: volume.PersistentVolume}, persistentVolume)
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			// The volume is reclaimed once no claim is bound to it
+			if err == nil && persistentVolume.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete {
+				persistentVolume.Spec.PersistentVolumeReclaimPolicy = corev1.PersistentVolumeReclaimDelete
+				if err := r.Update(ctx, persistentVolume); err != nil {
+					return fmt.Errorf("failed to release volume %s: %w", persistentVolume.Name, err)
+				}
+			}
+		}
+	}
+
+	// Contents created by restores refer to the same snapshots, deleting them
+	// only deletes the objects
+	contents := &unstructured.UnstructuredList{}
+	contents.SetGroupVersionKind(volumeSnapshotContentGVK.GroupVersion().WithKind("VolumeSnapshotContentList"))
+	err := r.List(ctx, contents, client.MatchingLabels{snapshotLabel: scenarioSnapshot.Name})
+	if err != nil && !meta.IsNoMatchError(err) {
+		return err
+	}
+	for i := range contents.Items {
+		if err := r.Delete(ctx, &contents.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	log.Info("Released snapshot storage", "snapshot", scenarioSnapshot.Name)
+	return nil
+}
+
+// snapshotFailed fails a ScenarioSnapshot that cannot be captured
+func (r *ScenarioSnapshotReconciler) snapshotFailed(ctx context.Context,
+	scenarioSnapshot *devopsbeererv1beta1.ScenarioSnapshot, message string) error {
+
+	scenarioSnapshot.Status.Phase = devopsbeererv1beta1.ScenarioSnapshotPhaseFailed
+	scenarioSnapshot.Status.Message = truncateMessage(message)
+	r.Recorder.Event(scenarioSnapshot, corev1.EventTypeWarning, EventReasonSnapshotFailed,
+		scenarioSnapshot.Status.Message)
+	return r.Status().Update(ctx, scenarioSnapshot)
+}
+
+// SetupWithManager sets up the controller with the Manager. ScenarioSnapshots
+// own the Jobs copying their volumes, whose updates trigger a reconciliation.
+func (r *ScenarioSnapshotReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		// The controller requeues itself, status updates must not trigger a
+		// reconciliation
+		For(&devopsbeererv1beta1.ScenarioSnapshot{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
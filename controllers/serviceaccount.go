@@ -0,0 +1,250 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/helm"
+)
+
+// installerName names the ServiceAccount, Role and RoleBinding generated in
+// the scenario namespace from the rules of a ScenarioDefinition
+const installerName = "devopsbeerer-installer"
+
+// operatorBindingName names the RoleBinding granting the operator its rights
+// in a scenario namespace
+const operatorBindingName = "devopsbeerer-operator"
+
+// helmReleaseRule lets the generated ServiceAccount manage the secrets Helm
+// stores its releases in
+var helmReleaseRule = rbacv1.PolicyRule{
+	APIGroups: []string{""},
+	Resources: []string{"secrets"},
+	Verbs:     []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+}
+
+// hookJobRule lets the generated ServiceAccount create the Jobs of the hooks
+// of its scenario, which are created as it
+var hookJobRule = rbacv1.PolicyRule{
+	APIGroups: []string{"batch"},
+	Resources: []string{"jobs"},
+	Verbs:     []string{"create"},
+}
+
+// ensureServiceAccount prepares the ServiceAccount the chart of a scenario is
+// installed as and returns it in namespace/name form. It returns an empty
+// string when the chart is installed as the operator itself. Generated
+// ServiceAccounts, Roles and RoleBindings are given the name passed.
+func (r *ActiveScenarioReconciler) ensureServiceAccount(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition, namespace, name string) (string, error) {
+
+	serviceAccount := scenarioDef.Spec.ServiceAccount
+	if serviceAccount == nil {
+		return "", nil
+	}
+	if serviceAccount.Name != "" {
+		// Impersonating any ServiceAccount would hand the rights of, say, a
+		// kube-system controller to whoever can create a definition
+		ref := serviceAccount.Namespace + "/" + serviceAccount.Name
+		if serviceAccount.Namespace != r.SharedNamespace && !slices.Contains(r.AllowedServiceAccounts, ref) {
+			return "", fmt.Errorf("ServiceAccount %s is neither in the shared namespace %s nor allowed by the operator",
+				ref, r.SharedNamespace)
+		}
+		return ref, nil
+	}
+
+	labels := map[string]string{
+		scenarioLabel: scenarioDef.Spec.ID,
+		managedLabel:  "true",
+	}
+
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, sa, func() error {
+		sa.Labels = labels
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("failed to create service account: %w", err)
+	}
+
+	if err := r.bindInstaller(ctx, scenarioDef, namespace, namespace, name); err != nil {
+		return "", err
+	}
+
+	return namespace + "/" + name, nil
+}
+
+// grantInstaller lets the ServiceAccount generated for a scenario in another
+// namespace, given in namespace/name form, install charts into namespace
+func (r *ActiveScenarioReconciler) grantInstaller(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition, namespace, serviceAccount string) error {
+
+	if scenarioDef.Spec.ServiceAccount == nil || scenarioDef.Spec.ServiceAccount.Name != "" {
+		return nil
+	}
+	serviceAccountNamespace, name, _ := strings.Cut(serviceAccount, "/")
+	return r.bindInstaller(ctx, scenarioDef, namespace, serviceAccountNamespace, name)
+}
+
+// bindInstaller creates the Role granting the rules of a scenario in
+// namespace, bound to a ServiceAccount of serviceAccountNamespace. Both the
+// Role and the RoleBinding are named after the ServiceAccount.
+func (r *ActiveScenarioReconciler) bindInstaller(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition, namespace, serviceAccountNamespace, name string) error {
+
+	labels := map[string]string{
+		scenarioLabel: scenarioDef.Spec.ID,
+		managedLabel:  "true",
+	}
+
+	// The Role is created empty and granted its rules by an update, which
+	// names it: the operator may only escalate Roles of the installer names
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+	}
+	if err := r.Create(ctx, role); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, role, func() error {
+		role.Labels = labels
+		role.Rules = []rbacv1.PolicyRule{helmReleaseRule}
+		if scenarioDef.Spec.Hooks != nil && namespace == serviceAccountNamespace {
+			role.Rules = append(role.Rules, hookJobRule)
+		}
+		role.Rules = append(role.Rules, scenarioDef.Spec.ServiceAccount.Rules...)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		binding.Labels = labels
+		binding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name,
+		}
+		binding.Subjects = []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      name,
+			Namespace: serviceAccountNamespace,
+		}}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to create role binding: %w", err)
+	}
+
+	return nil
+}
+
+// bindOperator binds the ClusterRole of the rights the operator needs inside
+// scenario namespaces, such as running Jobs, to its own ServiceAccount in
+// namespace. Nothing is bound without such a ClusterRole, the operator then
+// holds these rights on the whole cluster.
+func (r *ActiveScenarioReconciler) bindOperator(ctx context.Context,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition, namespace string) error {
+
+	if r.ScenarioClusterRole == "" {
+		return nil
+	}
+	serviceAccountNamespace, serviceAccountName, _ := strings.Cut(r.ServiceAccount, "/")
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      operatorBindingName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				scenarioLabel: scenarioDef.Spec.ID,
+				managedLabel:  "true",
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     r.ScenarioClusterRole,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccountName,
+			Namespace: serviceAccountNamespace,
+		}},
+	}
+
+	// The role of a binding cannot change, a binding left by another release
+	// of the operator is replaced
+	existing := &rbacv1.RoleBinding{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(binding), existing)
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("failed to get operator role binding: %w", err)
+	case existing.RoleRef == binding.RoleRef && slices.Equal(existing.Subjects, binding.Subjects):
+		return nil
+	default:
+		if err := r.Delete(ctx, existing); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to replace operator role binding: %w", err)
+		}
+	}
+	if err := r.Create(ctx, binding); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create operator role binding: %w", err)
+	}
+	return nil
+}
+
+// helmClientFor returns the helm client impersonating the given ServiceAccount,
+// in namespace/name form, or the operator's own client when it is empty
+func (r *ActiveScenarioReconciler) helmClientFor(serviceAccount string) *helm.Client {
+	if r.HelmClient == nil || serviceAccount == "" {
+		return r.HelmClient
+	}
+	namespace, name, _ := strings.Cut(serviceAccount, "/")
+	return r.HelmClient.Impersonate(namespace, name)
+}
+
+// clientFor returns a client impersonating the given ServiceAccount, in
+// namespace/name form, or the operator's own client when it is empty
+func (r *ActiveScenarioReconciler) clientFor(serviceAccount string) (client.Client, error) {
+	if r.Config == nil || serviceAccount == "" {
+		return r.Client, nil
+	}
+	namespace, name, _ := strings.Cut(serviceAccount, "/")
+	config := rest.CopyConfig(r.Config)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name),
+	}
+	impersonated, err := client.New(config, client.Options{Scheme: r.Scheme, Mapper: r.RESTMapper()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %w", serviceAccount, err)
+	}
+	return impersonated, nil
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// stepLabel holds the step a verification Job was created for
+const stepLabel = "devopsbeerer.io/step"
+
+const (
+	// defaultVerificationInterval is how often the current step is verified
+	// unless its verification sets an interval
+	defaultVerificationInterval = 30 * time.Second
+	// httpVerificationTimeout bounds the requests of HTTP verifications
+	httpVerificationTimeout = 10 * time.Second
+	// maxVerificationBody is how much of a response body is searched
+	maxVerificationBody = 1 << 20
+)
+
+// verificationClient returns the client sending the requests of an HTTP
+// verification to an address, whatever the host of the URL resolves to.
+// Redirects are not followed, so that a scenario cannot point the operator at
+// a URL the verification does not name, and the redirect status is reported
+// instead.
+func verificationClient(address string) *http.Client {
+	dialer := &net.Dialer{Timeout: httpVerificationTimeout}
+	return &http.Client{
+		Timeout: httpVerificationTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: httpVerificationTimeout,
+			DisableKeepAlives:   true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// trackSteps updates the progress of the steps of the running scenario,
+// persisted with the next status update of the ActiveScenario. Steps are done
+// in order, only the current step is verified. It returns when the current
+// step is due for verification, 0 when it has no verification.
+func (r *ActiveScenarioReconciler) trackSteps(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	history *devopsbeererv1beta1.ScenarioHistory) time.Duration {
+
+	now := metav1.Now()
+	previous := map[string]devopsbeererv1beta1.StepStatus{}
+	for _, status := range activeScenario.Status.Steps {
+		previous[status.Name] = status
+	}
+
+	var statuses []devopsbeererv1beta1.StepStatus
+	var requeueAfter time.Duration
+	current := ""
+	for _, step := range scenarioDef.Spec.Steps {
+		status, ok := previous[step.Name]
+		if !ok {
+			status = devopsbeererv1beta1.StepStatus{Name: step.Name, Phase: devopsbeererv1beta1.StepPhasePending}
+		}
+		if status.Phase != devopsbeererv1beta1.StepPhaseCompleted &&
+			slices.Contains(activeScenario.Spec.CompletedSteps, step.Name) {
+			r.completeStep(activeScenario, &status, now, "Marked as completed")
+		}
+
+		if status.Phase != devopsbeererv1beta1.StepPhaseCompleted && current == "" {
+			if status.Phase == devopsbeererv1beta1.StepPhasePending {
+				status.Phase = devopsbeererv1beta1.StepPhaseInProgress
+				status.StartedAt = &now
+			}
+			if verification := step.Verification; verification != nil {
+				interval := defaultVerificationInterval
+				if verification.Interval != nil {
+					interval = verification.Interval.Duration
+				}
+				if status.LastVerification == nil || now.Sub(status.LastVerification.Time) >= interval {
+					completed, message := r.verifyStep(ctx, activeScenario, step.Name, verification, history)
+					status.LastVerification = &now
+					status.Message = message
+					if completed {
+						r.completeStep(activeScenario, &status, now, message)
+					}
+				}
+				if status.Phase != devopsbeererv1beta1.StepPhaseCompleted {
+					requeueAfter = interval - now.Sub(status.LastVerification.Time)
+				}
+			}
+			if status.Phase != devopsbeererv1beta1.StepPhaseCompleted {
+				current = step.Name
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	activeScenario.Status.Steps = statuses
+	activeScenario.Status.CurrentStep = current
+	return requeueAfter
+}
+
+// completeStep marks a step as completed
+func (r *ActiveScenarioReconciler) completeStep(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	status *devopsbeererv1beta1.StepStatus, now metav1.Time, message string) {
+
+	if status.StartedAt == nil {
+		status.StartedAt = &now
+	}
+	status.Phase = devopsbeererv1beta1.StepPhaseCompleted
+	status.CompletedAt = &now
+	status.Message = message
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonStepCompleted,
+		"Step '%s' completed: %s", status.Name, message)
+}
+
+// verifyStep runs the verification of a step, returning whether the step is
+// completed and the outcome of the verification
+func (r *ActiveScenarioReconciler) verifyStep(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, step string,
+	verification *devopsbeererv1beta1.StepVerification,
+	history *devopsbeererv1beta1.ScenarioHistory) (bool, string) {
+
+	if verification.HTTP != nil {
+		return verifyHTTP(ctx, r.Client, history.Spec.Namespace, verification.HTTP)
+	}
+	if verification.Job != nil {
+		completed, message, err := r.verifyJob(ctx, activeScenario, step, verification.Job, history)
+		if err != nil {
+			return false, truncateMessage(err.Error())
+		}
+		return completed, message
+	}
+	return false, "No verification"
+}
+
+// verifyHTTP requests the URL of an HTTP verification on the cluster IP of the
+// Service of the scenario namespace it names. Other addresses are refused, so
+// that a scenario cannot make the operator request them and read them back
+// through the expected body.
+func verifyHTTP(ctx context.Context, reader client.Reader, namespace string,
+	verification *devopsbeererv1beta1.HTTPVerification) (bool, string) {
+
+	ctx, cancel := context.WithTimeout(ctx, httpVerificationTimeout)
+	defer cancel()
+
+	target, err := url.Parse(verification.URL)
+	if err != nil {
+		return false, truncateMessage(fmt.Sprintf("Invalid URL: %v", err))
+	}
+	address, err := serviceAddress(ctx, reader, namespace, target)
+	if err != nil {
+		return false, truncateMessage(fmt.Sprintf("Invalid URL: %v", err))
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false, truncateMessage(fmt.Sprintf("Invalid URL: %v", err))
+	}
+	for _, header := range verification.HTTPHeaders {
+		request.Header.Add(header.Name, header.Value)
+	}
+	response, err := verificationClient(address).Do(request)
+	if err != nil {
+		return false, truncateMessage(fmt.Sprintf("Request failed: %v", err))
+	}
+	defer response.Body.Close()
+
+	if !expectedStatus(verification, response.StatusCode) {
+		return false, fmt.Sprintf("HTTP %d", response.StatusCode)
+	}
+	if verification.BodyContains != "" {
+		body, err := io.ReadAll(io.LimitReader(response.Body, maxVerificationBody))
+		if err != nil {
+			return false, truncateMessage(fmt.Sprintf("Failed to read the response: %v", err))
+		}
+		if !strings.Contains(string(body), verification.BodyContains) {
+			return false, fmt.Sprintf("HTTP %d, the response does not contain %q",
+				response.StatusCode, verification.BodyContains)
+		}
+	}
+	return true, fmt.Sprintf("HTTP %d", response.StatusCode)
+}
+
+// serviceAddress returns the cluster IP and port an HTTP verification URL is
+// requested on. The host of the URL must name a Service of the namespace, as
+// <service>.<namespace>.svc optionally followed by the cluster domain, which
+// has a cluster IP, so not an ExternalName or headless Service.
+func serviceAddress(ctx context.Context, reader client.Reader, namespace string,
+	target *url.URL) (string, error) {
+
+	port := target.Port()
+	switch {
+	case target.Scheme == "https" && port == "":
+		port = "443"
+	case target.Scheme == "http" && port == "":
+		port = "80"
+	case target.Scheme != "https" && target.Scheme != "http":
+		return "", fmt.Errorf("scheme %q is not http or https", target.Scheme)
+	}
+
+	labels := strings.Split(target.Hostname(), ".")
+	if len(labels) < 3 || labels[1] != namespace || labels[2] != "svc" {
+		return "", fmt.Errorf("host %q is not a Service of namespace %s", target.Hostname(), namespace)
+	}
+	service := &corev1.Service{}
+	if err := reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: labels[0]}, service); err != nil {
+		return "", fmt.Errorf("failed to get Service %s: %w", labels[0], err)
+	}
+	if service.Spec.Type == corev1.ServiceTypeExternalName ||
+		service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", fmt.Errorf("service %s has no cluster IP", service.Name)
+	}
+	return net.JoinHostPort(service.Spec.ClusterIP, port), nil
+}
+
+// expectedStatus returns whether an HTTP verification succeeds on a status
+// code
+func expectedStatus(verification *devopsbeererv1beta1.HTTPVerification, code int) bool {
+	if len(verification.ExpectedStatus) == 0 {
+		return code >= http.StatusOK && code < http.StatusBadRequest
+	}
+	return slices.Contains(verification.ExpectedStatus, int32(code))
+}
+
+// verifyJob checks the verification Job of a step, starting it when none is
+// running. A failed Job is deleted so that the next verification runs it
+// again.
+func (r *ActiveScenarioReconciler) verifyJob(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, step string,
+	template *batchv1.JobTemplateSpec,
+	history *devopsbeererv1beta1.ScenarioHistory) (bool, string, error) {
+
+	labels := map[string]string{
+		scenarioLabel: activeScenario.Spec.ScenarioID,
+		managedLabel:  "true",
+		stepLabel:     step,
+	}
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(history.Spec.Namespace), client.MatchingLabels(labels)); err != nil {
+		return false, "", fmt.Errorf("failed to list verification Jobs: %w", err)
+	}
+
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if !job.DeletionTimestamp.IsZero() {
+			continue
+		}
+		completed, err := jobOutcome(job)
+		switch {
+		case completed:
+			return true, fmt.Sprintf("Verification Job %s completed", job.Name), nil
+		case err != nil:
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+				return false, "", client.IgnoreNotFound(err)
+			}
+			return false, truncateMessage(fmt.Sprintf("Verification %v", err)), nil
+		default:
+			return false, fmt.Sprintf("Verification Job %s is running", job.Name), nil
+		}
+	}
+
+	job := scenarioJob(template, history.Spec.HelmRelease+"-step-"+step, history.Spec.Namespace, labels)
+	if err := r.Create(ctx, job); err != nil {
+		return false, "", fmt.Errorf("failed to create verification Job: %w", err)
+	}
+	return false, fmt.Sprintf("Verification Job %s started", job.Name), nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+func TestVerifyHTTP(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		followed = true
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, target.URL, http.StatusFound)
+		case "/denied":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			_, _ = w.Write([]byte("beers"))
+		}
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const namespace = "devopsbeerer-basic-oauth2"
+	// The Services of the scenario are served by the test server on their
+	// cluster IP
+	beerAPI := "http://beer-api." + namespace + ".svc:" + serverURL.Port()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "beer-api", Namespace: namespace},
+			Spec:       corev1.ServiceSpec{ClusterIP: serverURL.Hostname()},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "beer-api", Namespace: "other"},
+			Spec:       corev1.ServiceSpec{ClusterIP: serverURL.Hostname()},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "external", Namespace: namespace},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: serverURL.Hostname()},
+		},
+	).Build()
+
+	tests := map[string]struct {
+		verification devopsbeererv1beta1.HTTPVerification
+		want         bool
+		wantMessage  string
+	}{
+		"ok": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: beerAPI, BodyContains: "beers"},
+			want:         true,
+			wantMessage:  "HTTP 200",
+		},
+		"cluster domain": {
+			verification: devopsbeererv1beta1.HTTPVerification{
+				URL: "http://beer-api." + namespace + ".svc.cluster.local:" + serverURL.Port(),
+			},
+			want:        true,
+			wantMessage: "HTTP 200",
+		},
+		"body mismatch": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: beerAPI, BodyContains: "wine"},
+			wantMessage:  `HTTP 200, the response does not contain "wine"`,
+		},
+		"expected status": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: beerAPI + "/denied", ExpectedStatus: []int32{403}},
+			want:         true,
+			wantMessage:  "HTTP 403",
+		},
+		"unexpected status": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: beerAPI + "/denied"},
+			wantMessage:  "HTTP 403",
+		},
+		"redirect not followed": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: beerAPI + "/redirect", BodyContains: "internal"},
+			wantMessage:  `HTTP 302, the response does not contain "internal"`,
+		},
+		"address outside the cluster": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: server.URL, BodyContains: "beers"},
+			wantMessage: fmt.Sprintf(`Invalid URL: host %q is not a Service of namespace %s`,
+				serverURL.Hostname(), namespace),
+		},
+		"service of another namespace": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: "http://beer-api.other.svc:" + serverURL.Port()},
+			wantMessage:  `Invalid URL: host "beer-api.other.svc" is not a Service of namespace ` + namespace,
+		},
+		"external name service": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: "http://external." + namespace + ".svc"},
+			wantMessage:  "Invalid URL: service external has no cluster IP",
+		},
+		"missing service": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: "http://missing." + namespace + ".svc"},
+			wantMessage:  `Invalid URL: failed to get Service missing: services "missing" not found`,
+		},
+		"unsupported scheme": {
+			verification: devopsbeererv1beta1.HTTPVerification{URL: "file:///etc/passwd"},
+			wantMessage:  `Invalid URL: scheme "file" is not http or https`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, message := verifyHTTP(context.Background(), reader, namespace, &tt.verification)
+			if got != tt.want || message != tt.wantMessage {
+				t.Errorf("verifyHTTP() = %v, %q, want %v, %q", got, message, tt.want, tt.wantMessage)
+			}
+		})
+	}
+	if followed {
+		t.Errorf("verifyHTTP() followed a redirect")
+	}
+}
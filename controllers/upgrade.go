@@ -0,0 +1,436 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+	"github.com/devopsbeerer/operator/internal/guardrails"
+	"github.com/devopsbeerer/operator/internal/helm"
+	"github.com/devopsbeerer/operator/internal/metrics"
+	"github.com/devopsbeerer/operator/internal/schedule"
+	"github.com/devopsbeerer/operator/internal/tracing"
+)
+
+// maxHistoryRevisions is the number of revisions kept on a history
+const maxHistoryRevisions = 20
+
+// settled reports whether a running or failed scenario whose ActiveScenario
+// did not change needs no reconciliation, and when to check it again. It
+// checks the health of a running scenario and polls its chart source when its
+// update policy follows it. A change of its ScenarioDefinition retries a
+// failed scenario, while the updates of a running one are applied as its
+// update policy allows and reported by its UpToDate condition until then.
+func (r *ActiveScenarioReconciler) settled(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario) (time.Duration, bool, error) {
+
+	log := log.FromContext(ctx)
+	requeueAfter := 5 * time.Minute
+
+	if activeScenario.Status.Phase == devopsbeererv1beta1.ActiveScenarioPhaseRunning {
+		if err := r.checkHealth(ctx, activeScenario); err != nil {
+			log.Error(err, "Health check failed")
+		}
+	}
+
+	// A pending restore reinstalls the scenario
+	restore, err := r.pendingRestore(ctx, activeScenario)
+	if err != nil || restore != nil {
+		return requeueAfter, false, err
+	}
+
+	// A deleted definition leaves the installed scenario as it is
+	scenarioDef := &devopsbeererv1beta1.ScenarioDefinition{}
+	if err := r.Get(ctx, types.NamespacedName{Name: activeScenario.Spec.ScenarioID}, scenarioDef); err != nil {
+		return requeueAfter, errors.IsNotFound(err), client.IgnoreNotFound(err)
+	}
+	if activeScenario.Status.Phase == devopsbeererv1beta1.ActiveScenarioPhaseFailed {
+		return requeueAfter, scenarioDef.Generation == activeScenario.Status.DefinitionGeneration, nil
+	}
+
+	history, err := r.findActiveScenarioHistory(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if history == nil || history.Spec.ScenarioID != activeScenario.Spec.ScenarioID {
+		return requeueAfter, true, nil
+	}
+
+	policy := r.updatePolicy(activeScenario)
+	now := time.Now()
+	if policy.Type != devopsbeererv1beta1.UpdatePolicyManual && r.HelmClient != nil {
+		interval := r.SourcePollInterval
+		if policy.Interval != nil && policy.Interval.Duration > 0 {
+			interval = policy.Interval.Duration
+		}
+		lastCheck := activeScenario.Status.LastSourceCheck
+		if lastCheck == nil || now.Sub(lastCheck.Time) >= interval {
+			revision, err := r.HelmClient.ResolveRevision(ctx, chartSource(scenarioDef))
+			if err != nil {
+				metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+					helmFailureReason(metrics.ReasonFetch, err)).Inc()
+				log.Error(err, "Failed to check the chart source")
+			} else {
+				activeScenario.Status.AvailableRevision = revision
+			}
+			checked := metav1.NewTime(now)
+			activeScenario.Status.LastSourceCheck = &checked
+			requeueAfter = min(requeueAfter, interval)
+		} else {
+			requeueAfter = min(requeueAfter, interval-now.Sub(lastCheck.Time))
+		}
+	}
+
+	if activeScenario.Status.Phase == devopsbeererv1beta1.ActiveScenarioPhaseRunning {
+		r.refreshEndpoints(ctx, activeScenario, scenarioDef, history)
+		if verifyAfter := r.trackSteps(ctx, activeScenario, scenarioDef, history); verifyAfter > 0 {
+			requeueAfter = min(requeueAfter, verifyAfter)
+		}
+	}
+
+	// The parameters did not change, only the definition and source may have
+	pending := pendingUpdate(activeScenario, scenarioDef,
+		upgradeTrigger(activeScenario, scenarioDef, history, history.Spec.Values))
+	if pending != "" {
+		due, waiting, next := r.updateDue(policy, now)
+		if due {
+			return 0, false, nil
+		}
+		if next > 0 {
+			requeueAfter = min(requeueAfter, next)
+		}
+		pending += waiting
+	}
+
+	r.recordPendingUpdate(activeScenario, scenarioDef, pending)
+	return requeueAfter, true, r.Status().Update(ctx, activeScenario)
+}
+
+// pendingUpdate describes the update of the definition or the chart source a
+// trigger reports, or returns an empty message for any other trigger
+func pendingUpdate(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	trigger devopsbeererv1beta1.RevisionTrigger) string {
+
+	switch trigger {
+	case devopsbeererv1beta1.RevisionTriggerDefinitionChanged:
+		return fmt.Sprintf("ScenarioDefinition '%s' changed", scenarioDef.Name)
+	case devopsbeererv1beta1.RevisionTriggerSourceChanged:
+		return fmt.Sprintf("Chart source moved to %s", activeScenario.Status.AvailableRevision)
+	}
+	return ""
+}
+
+// updateDue reports whether an update policy applies the updates of the
+// definition and the chart source now. Otherwise it returns why the update
+// waits, and how long until the update window opens for a Window policy.
+func (r *ActiveScenarioReconciler) updateDue(policy devopsbeererv1beta1.UpdatePolicy,
+	now time.Time) (bool, string, time.Duration) {
+
+	switch policy.Type {
+	case devopsbeererv1beta1.UpdatePolicyAuto:
+		return true, "", 0
+	case devopsbeererv1beta1.UpdatePolicyWindow:
+		window, err := r.updateWindow(policy)
+		if err != nil {
+			return false, fmt.Sprintf(", invalid update window: %v", err), 0
+		}
+		if window.Contains(now) {
+			return true, "", 0
+		}
+		next := window.Next(now)
+		return false, fmt.Sprintf(", waiting for the update window opening at %s", next.Format(time.RFC3339)), next.Sub(now)
+	default:
+		return false, ", the update policy is Manual", 0
+	}
+}
+
+// recordPendingUpdate reports the update a running scenario waits for on its
+// UpToDate condition, with an event when it changed
+func (r *ActiveScenarioReconciler) recordPendingUpdate(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition, pending string) {
+
+	if pending != "" {
+		if upToDate := meta.FindStatusCondition(activeScenario.Status.Conditions,
+			devopsbeererv1beta1.ActiveScenarioConditionUpToDate); upToDate == nil || upToDate.Message != pending {
+			r.Recorder.Event(activeScenario, corev1.EventTypeNormal, EventReasonUpdateAvailable, pending)
+		}
+	}
+
+	activeScenario.Status.DefinitionGeneration = scenarioDef.Generation
+	setUpToDateCondition(activeScenario, scenarioDef, pending)
+}
+
+// updatePolicy returns the update policy of an ActiveScenario, defaulting to
+// the operator setting
+func (r *ActiveScenarioReconciler) updatePolicy(activeScenario *devopsbeererv1beta1.ActiveScenario) devopsbeererv1beta1.UpdatePolicy {
+	if activeScenario.Spec.UpdatePolicy != nil {
+		return *activeScenario.Spec.UpdatePolicy
+	}
+	if r.AutoUpgrade {
+		return devopsbeererv1beta1.UpdatePolicy{Type: devopsbeererv1beta1.UpdatePolicyAuto}
+	}
+	return devopsbeererv1beta1.UpdatePolicy{Type: devopsbeererv1beta1.UpdatePolicyManual}
+}
+
+// updateWindow parses the window of a Window update policy
+func (r *ActiveScenarioReconciler) updateWindow(policy devopsbeererv1beta1.UpdatePolicy) (*schedule.Window, error) {
+	if policy.Window == nil {
+		return nil, fmt.Errorf("no window set")
+	}
+	return schedule.NewWindow(policy.Window)
+}
+
+// upgradeTrigger returns why the running scenario must be upgraded to run the
+// given values, its current definition and the latest revision of its chart
+// source, or an empty trigger when it already does. Histories recorded before
+// the definition generation was tracked are not upgraded for it.
+func upgradeTrigger(activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	history *devopsbeererv1beta1.ScenarioHistory,
+	values string) devopsbeererv1beta1.RevisionTrigger {
+
+	switch {
+	case history.Spec.Values != values:
+		return devopsbeererv1beta1.RevisionTriggerSpecChanged
+	case history.Spec.DefinitionGeneration != 0 && history.Spec.DefinitionGeneration != scenarioDef.Generation:
+		return devopsbeererv1beta1.RevisionTriggerDefinitionChanged
+	case activeScenario.Status.AvailableRevision != "" &&
+		activeScenario.Status.AvailableRevision != history.Spec.SourceRevision:
+		return devopsbeererv1beta1.RevisionTriggerSourceChanged
+	}
+	return ""
+}
+
+// resolveRevision resolves the revision of a chart source and records it as
+// the latest available. A chart repository source is pinned to the version it
+// resolves to, so that the revision recorded is the one installed.
+func (r *ActiveScenarioReconciler) resolveRevision(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario, chart *helm.ChartSource) string {
+
+	revision := r.chartRevision(ctx, chart)
+	if revision == "" {
+		return ""
+	}
+
+	now := metav1.Now()
+	activeScenario.Status.AvailableRevision = revision
+	activeScenario.Status.LastSourceCheck = &now
+	return revision
+}
+
+// helmRevision returns the current revision of a helm release, or 0 when it
+// cannot be determined
+func (r *ActiveScenarioReconciler) helmRevision(ctx context.Context, helmClient *helm.Client,
+	releaseName, namespace string) int32 {
+
+	release, err := helmClient.Release(ctx, releaseName, namespace)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to get the helm release revision", "release", releaseName)
+		return 0
+	}
+	return int32(release.Revision)
+}
+
+// recordRevision appends the revision the history now runs to its revisions,
+// keeping the latest ones
+func recordRevision(history *devopsbeererv1beta1.ScenarioHistory,
+	trigger devopsbeererv1beta1.RevisionTrigger, helmRevision int32) {
+
+	revision := int32(1)
+	if n := len(history.Status.Revisions); n > 0 {
+		revision = history.Status.Revisions[n-1].Revision + 1
+	}
+	history.Status.Revisions = append(history.Status.Revisions, devopsbeererv1beta1.ScenarioRevision{
+		Revision:             revision,
+		Trigger:              trigger,
+		DeployedAt:           metav1.Now(),
+		DefinitionGeneration: history.Spec.DefinitionGeneration,
+		SourceRevision:       history.Spec.SourceRevision,
+		HelmRevision:         helmRevision,
+	})
+	if n := len(history.Status.Revisions); n > maxHistoryRevisions {
+		history.Status.Revisions = history.Status.Revisions[n-maxHistoryRevisions:]
+	}
+}
+
+// upgradeScenario upgrades the running scenario in place with the current
+// chart, values, guardrails and shared components of its definition, keeping
+// its namespace and recording the upgrade as a new revision of its history
+func (r *ActiveScenarioReconciler) upgradeScenario(ctx context.Context,
+	activeScenario *devopsbeererv1beta1.ActiveScenario,
+	scenarioDef *devopsbeererv1beta1.ScenarioDefinition,
+	plan *installPlan,
+	history *devopsbeererv1beta1.ScenarioHistory,
+	values string, trigger devopsbeererv1beta1.RevisionTrigger) (_ ctrl.Result, err error) {
+
+	ctx, span := tracing.Start(ctx, "upgradeScenario",
+		tracing.AttrScenarioID.String(scenarioDef.Spec.ID),
+		tracing.AttrRelease.String(history.Spec.HelmRelease),
+		tracing.AttrNamespace.String(history.Spec.Namespace))
+	defer func() { tracing.End(span, err) }()
+
+	log := log.FromContext(ctx)
+
+	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseDeploying,
+		fmt.Sprintf("Upgrading scenario %s: %s", scenarioDef.Spec.Name, trigger)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	labels := map[string]string{
+		scenarioLabel: scenarioDef.Spec.ID,
+		managedLabel:  "true",
+	}
+	if err := guardrails.Apply(ctx, r.Client, history.Spec.Namespace, r.scenarioGuardrails(scenarioDef, plan),
+		labels, r.Guardrails); err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonGuardrailsFailed,
+			"Failed to apply guardrails to namespace %s: %v", history.Spec.Namespace, err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to apply namespace guardrails: %v", err))
+	}
+
+	// Scenarios installed before the operator role was bound get it now
+	if err := r.bindOperator(ctx, scenarioDef, history.Spec.Namespace); err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonServiceAccountFailed,
+			"Failed to bind the operator role: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to bind the operator role: %v", err))
+	}
+	serviceAccount, err := r.ensureServiceAccount(ctx, scenarioDef, history.Spec.Namespace, installerName)
+	if err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonServiceAccountFailed,
+			"Failed to prepare service account: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to prepare service account: %v", err))
+	}
+
+	// Keep the credentials the running charts use, generating new ones only
+	if err := r.ensureCredentials(ctx, activeScenario, scenarioDef, history.Spec.Namespace, false); err != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeWarning, EventReasonCredentialsFailed,
+			"Failed to generate credentials: %v", err)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to generate credentials: %v", err))
+	}
+
+	if err := r.installComponents(ctx, activeScenario, plan.components); err != nil {
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonComponentFailed,
+			truncateMessage(err.Error()))
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to install shared components: %v", err))
+	}
+
+	chart := chartSource(scenarioDef)
+	sourceRevision := r.resolveRevision(ctx, activeScenario, &chart)
+	log.Info("Upgrading helm chart",
+		"trigger", trigger,
+		"chart", chart.String(),
+		"release", history.Spec.HelmRelease,
+		"namespace", history.Spec.Namespace)
+
+	var helmRevision int32
+	if helmClient := r.helmClientFor(serviceAccount); helmClient != nil {
+		r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonUpgradeStarted,
+			"Upgrading helm release %s from %s", history.Spec.HelmRelease, chart.String())
+		if err := r.recoverRelease(ctx, activeScenario, helmClient, history.Spec.HelmRelease, history.Spec.Namespace); err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+				helmFailureReason(metrics.ReasonRecover, err)).Inc()
+			r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUpgradeFailed,
+				truncateMessage(fmt.Sprintf("Failed to recover helm release %s: %v", history.Spec.HelmRelease, err)))
+			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("Failed to recover helm release: %v", err))
+		}
+		if err := helmClient.Install(ctx, history.Spec.HelmRelease, history.Spec.Namespace, chart, values, helm.InstallOptions{}); err != nil {
+			metrics.HelmFailures.WithLabelValues(scenarioDef.Spec.ID,
+				helmFailureReason(metrics.ReasonUpgrade, err)).Inc()
+			message := truncateMessage(fmt.Sprintf("Failed to upgrade helm release %s: %v", history.Spec.HelmRelease, err))
+			r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUpgradeFailed, message)
+			r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonUpgradeFailed, message)
+			return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+				fmt.Sprintf("Failed to upgrade helm chart: %v", err))
+		}
+		helmRevision = r.helmRevision(ctx, helmClient, history.Spec.HelmRelease, history.Spec.Namespace)
+	}
+
+	releases, err := r.installCharts(ctx, activeScenario, scenarioDef, plan, history.Spec.Namespace,
+		history.Spec.HelmRelease, serviceAccount, labels)
+	if err != nil {
+		message := truncateMessage(err.Error())
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUpgradeFailed, message)
+		r.Recorder.Event(history, corev1.EventTypeWarning, EventReasonUpgradeFailed, message)
+		return r.updateStatusAndRequeue(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseFailed,
+			fmt.Sprintf("Failed to upgrade scenario charts: %v", err))
+	}
+
+	// Record what the release now runs as a new revision of the history
+	history.Spec.Values = values
+	history.Spec.DefinitionGeneration = scenarioDef.Generation
+	history.Spec.SourceRevision = sourceRevision
+	history.Spec.ServiceAccount = serviceAccount
+	previousComponents := history.Spec.Components
+	history.Spec.Components = componentIDs(plan.components)
+	previousReleases := history.Spec.Releases
+	history.Spec.Releases = releases
+	if err := r.Update(ctx, history); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update history: %w", err)
+	}
+	recordRevision(history, trigger, helmRevision)
+	if err := r.Status().Update(ctx, history); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update history status: %w", err)
+	}
+
+	// Remove the charts the scenario no longer has
+	if err := r.removeCharts(ctx, history, previousReleases); err != nil {
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonUpgradeFailed,
+			truncateMessage(err.Error()))
+		log.Error(err, "Failed to remove scenario charts")
+	}
+
+	// Release the components the scenario no longer requires
+	if err := r.releaseComponents(ctx, activeScenario, history,
+		removedComponents(previousComponents, history.Spec.Components), ""); err != nil {
+		r.Recorder.Event(activeScenario, corev1.EventTypeWarning, EventReasonComponentFailed,
+			truncateMessage(err.Error()))
+		log.Error(err, "Failed to release shared components")
+	}
+
+	r.Recorder.Eventf(activeScenario, corev1.EventTypeNormal, EventReasonUpgradeSucceeded,
+		"Upgraded scenario '%s'", scenarioDef.Spec.Name)
+	r.Recorder.Eventf(history, corev1.EventTypeNormal, EventReasonUpgradeSucceeded,
+		"Upgraded to revision %d: %s", history.Status.Revisions[len(history.Status.Revisions)-1].Revision, trigger)
+
+	r.refreshEndpoints(ctx, activeScenario, scenarioDef, history)
+	r.trackSteps(ctx, activeScenario, scenarioDef, history)
+	setUpToDateCondition(activeScenario, scenarioDef, "")
+	if err := r.updateStatus(ctx, activeScenario, devopsbeererv1beta1.ActiveScenarioPhaseRunning,
+		fmt.Sprintf("Scenario '%s' is running", scenarioDef.Spec.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+}
@@ -0,0 +1,41 @@
+#!/bin/sh
+# Adds the conversion webhook configuration to the generated CRDs that serve
+# more than one version. controller-gen cannot emit it, and the CRDs are
+# rendered as Helm templates so the webhook service name is templated here.
+set -e
+
+for crd in "$@"; do
+	# Only CRDs served in several versions need conversion
+	if [ "$(grep -c '^    name: v1' "$crd")" -lt 2 ]; then
+		continue
+	fi
+
+	awk '
+	/^    controller-gen.kubebuilder.io\/version:/ {
+		print
+		print "    {{- if and .Values.webhook.enabled .Values.webhook.certManager.enabled }}"
+		print "    cert-manager.io/inject-ca-from: {{ .Release.Namespace }}/{{ include \"devopsbeerer-operator.fullname\" . }}-serving-cert"
+		print "    {{- end }}"
+		next
+	}
+	/^spec:$/ && !done {
+		print
+		print "  {{- if .Values.webhook.enabled }}"
+		print "  conversion:"
+		print "    strategy: Webhook"
+		print "    webhook:"
+		print "      clientConfig:"
+		print "        service:"
+		print "          name: {{ include \"devopsbeerer-operator.fullname\" . }}-webhook"
+		print "          namespace: {{ .Release.Namespace }}"
+		print "          path: /convert"
+		print "      conversionReviewVersions:"
+		print "      - v1"
+		print "  {{- end }}"
+		done = 1
+		next
+	}
+	{ print }
+	' "$crd" > "$crd.tmp"
+	mv "$crd.tmp" "$crd"
+done
@@ -0,0 +1,321 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// Chart.yaml annotations describing the scenario of a chart. Tags, features
+// and requires are comma-separated lists.
+const (
+	AnnotationID          = "devopsbeerer.io/id"
+	AnnotationName        = "devopsbeerer.io/name"
+	AnnotationDescription = "devopsbeerer.io/description"
+	AnnotationTags        = "devopsbeerer.io/tags"
+	AnnotationFeatures    = "devopsbeerer.io/features"
+	AnnotationRequires    = "devopsbeerer.io/requires"
+)
+
+// Manifest lists the scenarios of a catalog
+type Manifest struct {
+	Scenarios []ManifestEntry `json:"scenarios"`
+}
+
+// ManifestEntry describes a scenario of a catalog manifest. The name,
+// description, tags, features and requires it leaves unset are read from the
+// Chart.yaml of its chart. The fields from ServiceAccount on are only
+// imported when the catalog trusts them.
+type ManifestEntry struct {
+	// ID is the scenario ID, also the name of its ScenarioDefinition
+	ID string `json:"id"`
+	// Path is the chart directory relative to the catalog path (defaults to ID)
+	Path           string                          `json:"path,omitempty"`
+	Name           string                          `json:"name,omitempty"`
+	Description    string                          `json:"description,omitempty"`
+	Tags           []string                        `json:"tags,omitempty"`
+	Features       []string                        `json:"features,omitempty"`
+	Parameters     []v1beta1.ScenarioParameter     `json:"parameters,omitempty"`
+	ServiceAccount *v1beta1.ScenarioServiceAccount `json:"serviceAccount,omitempty"`
+	Guardrails     *v1beta1.ScenarioGuardrails     `json:"guardrails,omitempty"`
+	Requires       []string                        `json:"requires,omitempty"`
+	Charts         []v1beta1.ScenarioChart         `json:"charts,omitempty"`
+	Hooks          *v1beta1.ScenarioHooks          `json:"hooks,omitempty"`
+	Endpoints      []v1beta1.ScenarioEndpoint      `json:"endpoints,omitempty"`
+	Credentials    []v1beta1.ScenarioCredential    `json:"credentials,omitempty"`
+	Steps          []v1beta1.ScenarioStep          `json:"steps,omitempty"`
+	Checks         []v1beta1.GradedCheck           `json:"checks,omitempty"`
+}
+
+// Entry is a scenario read from a catalog
+type Entry struct {
+	// Path is the chart directory relative to the repository root
+	Path string
+	// Spec is the ScenarioDefinition spec of the scenario
+	Spec v1beta1.ScenarioDefinitionSpec
+	// Err is set when the scenario could not be read, only Spec.ID and Path
+	// are set then
+	Err error
+}
+
+// chartMetadata is the part of Chart.yaml describing a scenario
+type chartMetadata struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Type        string            `json:"type"`
+	Keywords    []string          `json:"keywords"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Load reads the scenarios of a catalog checked out in dir, from its manifest
+// when set, or else from the Chart.yaml of every application chart in the
+// catalog path. Scenarios that cannot be read are returned with their error,
+// an error is only returned when the catalog itself cannot be read. Entries
+// setting fields the catalog does not trust fail.
+func Load(dir string, source v1beta1.CatalogGitSource, manifest string,
+	trusted []v1beta1.CatalogTrustedField) ([]Entry, error) {
+	if source.Path != "" && !filepath.IsLocal(source.Path) {
+		return nil, fmt.Errorf("catalog path %q is outside of the repository", source.Path)
+	}
+
+	var manifestEntries []ManifestEntry
+	if manifest != "" {
+		entries, err := readManifest(dir, source.Path, manifest)
+		if err != nil {
+			return nil, err
+		}
+		manifestEntries = entries
+	} else {
+		entries, err := discoverCharts(filepath.Join(dir, source.Path))
+		if err != nil {
+			return nil, err
+		}
+		manifestEntries = entries
+	}
+
+	entries := make([]Entry, 0, len(manifestEntries))
+	for i, manifestEntry := range manifestEntries {
+		entries = append(entries, load(dir, source, manifestEntry, i, trusted))
+	}
+	return entries, nil
+}
+
+// readManifest returns the entries of a catalog manifest
+func readManifest(dir, catalogPath, manifest string) ([]ManifestEntry, error) {
+	if !filepath.IsLocal(manifest) {
+		return nil, fmt.Errorf("manifest %q is outside of the catalog path", manifest)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, catalogPath, manifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	parsed := Manifest{}
+	if err := yaml.UnmarshalStrict(data, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", manifest, err)
+	}
+	return parsed.Scenarios, nil
+}
+
+// discoverCharts returns an entry for every application chart directly under
+// the catalog directory
+func discoverCharts(catalogDir string) ([]ManifestEntry, error) {
+	dirs, err := os.ReadDir(catalogDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog path: %w", err)
+	}
+
+	var entries []ManifestEntry
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		chart, err := readChart(filepath.Join(catalogDir, dir.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil && chart.Type == "library" {
+			continue
+		}
+		entry := ManifestEntry{Path: dir.Name(), ID: dir.Name()}
+		if chart != nil && chart.Annotations[AnnotationID] != "" {
+			entry.ID = chart.Annotations[AnnotationID]
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, nil
+}
+
+// load builds the ScenarioDefinition spec of the i-th catalog entry
+func load(dir string, source v1beta1.CatalogGitSource, manifestEntry ManifestEntry, i int,
+	trusted []v1beta1.CatalogTrustedField) Entry {
+	if manifestEntry.ID == "" {
+		return Entry{
+			Path: manifestEntry.Path,
+			Spec: v1beta1.ScenarioDefinitionSpec{ID: fmt.Sprintf("scenarios[%d]", i)},
+			Err:  fmt.Errorf("scenario has no id"),
+		}
+	}
+
+	chartPath := manifestEntry.Path
+	if chartPath == "" {
+		chartPath = manifestEntry.ID
+	}
+	entry := Entry{
+		Path: path.Join(filepath.ToSlash(source.Path), filepath.ToSlash(chartPath)),
+		Spec: v1beta1.ScenarioDefinitionSpec{ID: manifestEntry.ID},
+	}
+	if !filepath.IsLocal(chartPath) {
+		entry.Err = fmt.Errorf("chart path %q is outside of the catalog path", chartPath)
+		return entry
+	}
+	if untrusted := untrustedFields(manifestEntry, trusted); len(untrusted) > 0 {
+		entry.Err = fmt.Errorf("scenario sets %s, which the catalog does not trust", strings.Join(untrusted, ", "))
+		return entry
+	}
+
+	chartDir := filepath.Join(dir, filepath.FromSlash(entry.Path))
+	chart, err := readChart(chartDir)
+	if err != nil {
+		entry.Err = err
+		return entry
+	}
+
+	spec := v1beta1.ScenarioDefinitionSpec{
+		ID:          manifestEntry.ID,
+		Name:        first(manifestEntry.Name, chart.Annotations[AnnotationName], chart.Name),
+		Description: first(manifestEntry.Description, chart.Annotations[AnnotationDescription], chart.Description),
+		Chart: v1beta1.ChartSource{
+			Git: &v1beta1.GitChartSource{URL: source.URL, Ref: source.Ref, Path: entry.Path},
+		},
+		Tags:           manifestEntry.Tags,
+		Features:       manifestEntry.Features,
+		Parameters:     manifestEntry.Parameters,
+		ServiceAccount: manifestEntry.ServiceAccount,
+		Guardrails:     manifestEntry.Guardrails,
+		Requires:       manifestEntry.Requires,
+		Charts:         manifestEntry.Charts,
+		Hooks:          manifestEntry.Hooks,
+		Endpoints:      manifestEntry.Endpoints,
+		Credentials:    manifestEntry.Credentials,
+		Steps:          manifestEntry.Steps,
+		Checks:         manifestEntry.Checks,
+	}
+	if spec.Tags == nil {
+		spec.Tags = splitList(chart.Annotations[AnnotationTags])
+	}
+	if spec.Tags == nil {
+		spec.Tags = chart.Keywords
+	}
+	if spec.Features == nil {
+		spec.Features = splitList(chart.Annotations[AnnotationFeatures])
+	}
+	if spec.Requires == nil {
+		spec.Requires = splitList(chart.Annotations[AnnotationRequires])
+	}
+	for i := range spec.Parameters {
+		if spec.Parameters[i].Type == "" {
+			spec.Parameters[i].Type = v1beta1.ScenarioParameterTypeString
+		}
+	}
+
+	// Validate the values against the schema shipped with the chart
+	schema, err := os.ReadFile(filepath.Join(chartDir, "values.schema.json"))
+	switch {
+	case err == nil:
+		// Normalized like the API server stores it, so that unchanged schemas
+		// compare equal
+		var parsed any
+		if err := json.Unmarshal(schema, &parsed); err != nil {
+			entry.Err = fmt.Errorf("invalid values.schema.json: %w", err)
+			return entry
+		}
+		normalized, err := json.Marshal(parsed)
+		if err != nil {
+			entry.Err = fmt.Errorf("invalid values.schema.json: %w", err)
+			return entry
+		}
+		spec.ValuesSchema = &apiextensionsv1.JSON{Raw: normalized}
+	case !errors.Is(err, os.ErrNotExist):
+		entry.Err = fmt.Errorf("failed to read values.schema.json: %w", err)
+		return entry
+	}
+
+	if spec.Name == "" || spec.Description == "" {
+		entry.Err = fmt.Errorf("chart %s has no name or description", entry.Path)
+		return entry
+	}
+
+	entry.Spec = spec
+	return entry
+}
+
+// untrustedFields returns the fields a manifest entry sets that the catalog
+// does not trust
+func untrustedFields(manifestEntry ManifestEntry, trusted []v1beta1.CatalogTrustedField) []string {
+	var untrusted []string
+	for _, field := range []struct {
+		name v1beta1.CatalogTrustedField
+		set  bool
+	}{
+		{v1beta1.CatalogTrustedFieldServiceAccount, manifestEntry.ServiceAccount != nil},
+		{v1beta1.CatalogTrustedFieldGuardrails, manifestEntry.Guardrails != nil},
+		{v1beta1.CatalogTrustedFieldCharts, len(manifestEntry.Charts) > 0},
+		{v1beta1.CatalogTrustedFieldHooks, manifestEntry.Hooks != nil},
+		{v1beta1.CatalogTrustedFieldEndpoints, len(manifestEntry.Endpoints) > 0},
+		{v1beta1.CatalogTrustedFieldCredentials, len(manifestEntry.Credentials) > 0},
+		{v1beta1.CatalogTrustedFieldSteps, len(manifestEntry.Steps) > 0},
+		{v1beta1.CatalogTrustedFieldChecks, len(manifestEntry.Checks) > 0},
+	} {
+		if field.set && !slices.Contains(trusted, field.name) {
+			untrusted = append(untrusted, string(field.name))
+		}
+	}
+	return untrusted
+}
+
+// readChart reads the Chart.yaml of a chart directory
+func readChart(chartDir string) (*chartMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(chartDir, "Chart.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Chart.yaml: %w", err)
+	}
+	chart := &chartMetadata{}
+	if err := yaml.Unmarshal(data, chart); err != nil {
+		return nil, fmt.Errorf("invalid Chart.yaml: %w", err)
+	}
+	return chart, nil
+}
+
+// splitList splits a comma-separated annotation
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// first returns the first non-empty value
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
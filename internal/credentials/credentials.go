@@ -0,0 +1,165 @@
+package credentials
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// Defaults of the generated credentials
+const (
+	DefaultLength  = 32
+	DefaultRSABits = 2048
+	DefaultCurve   = "P-256"
+)
+
+// Suffixes of the Secret keys holding the parts of key pairs
+const (
+	PrivateKeySuffix = ".key"
+	PublicKeySuffix  = ".pub"
+	JWKSSuffix       = ".jwks"
+)
+
+const (
+	passwordAlphabet     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_."
+	clientSecretAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// Keys returns the Secret keys a credential is stored under
+func Keys(credential v1beta1.ScenarioCredential) []string {
+	switch credential.Type {
+	case v1beta1.CredentialTypeRSAKeyPair, v1beta1.CredentialTypeECKeyPair:
+		return []string{credential.Name + PrivateKeySuffix, credential.Name + PublicKeySuffix}
+	case v1beta1.CredentialTypeJWTSigningKey:
+		return []string{credential.Name + PrivateKeySuffix, credential.Name + PublicKeySuffix,
+			credential.Name + JWKSSuffix}
+	default:
+		return []string{credential.Name}
+	}
+}
+
+// Reference returns the Helm values referencing a credential stored in the
+// named Secret
+func Reference(secretName string, credential v1beta1.ScenarioCredential) map[string]interface{} {
+	reference := map[string]interface{}{"secretName": secretName}
+	switch credential.Type {
+	case v1beta1.CredentialTypeRSAKeyPair, v1beta1.CredentialTypeECKeyPair, v1beta1.CredentialTypeJWTSigningKey:
+		reference["privateKey"] = credential.Name + PrivateKeySuffix
+		reference["publicKey"] = credential.Name + PublicKeySuffix
+		if credential.Type == v1beta1.CredentialTypeJWTSigningKey {
+			reference["jwks"] = credential.Name + JWKSSuffix
+		}
+	default:
+		reference["key"] = credential.Name
+	}
+	return reference
+}
+
+// Generate generates a credential, returning the Secret data holding it
+func Generate(credential v1beta1.ScenarioCredential) (map[string][]byte, error) {
+	switch credential.Type {
+	case v1beta1.CredentialTypePassword:
+		return randomString(credential, passwordAlphabet)
+	case v1beta1.CredentialTypeClientSecret:
+		return randomString(credential, clientSecretAlphabet)
+	case v1beta1.CredentialTypeRSAKeyPair, v1beta1.CredentialTypeJWTSigningKey:
+		bits := DefaultRSABits
+		if credential.Bits != nil {
+			bits = int(*credential.Bits)
+		}
+		key, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		data, err := keyPair(credential.Name, key, key.Public())
+		if err != nil || credential.Type == v1beta1.CredentialTypeRSAKeyPair {
+			return data, err
+		}
+		jwks, err := json.Marshal(map[string]interface{}{"keys": []interface{}{jwk(&key.PublicKey)}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode JWKS: %w", err)
+		}
+		data[credential.Name+JWKSSuffix] = jwks
+		return data, nil
+	case v1beta1.CredentialTypeECKeyPair:
+		var curve elliptic.Curve
+		switch credential.Curve {
+		case "", DefaultCurve:
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", credential.Curve)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate EC key: %w", err)
+		}
+		return keyPair(credential.Name, key, key.Public())
+	}
+	return nil, fmt.Errorf("unsupported credential type %q", credential.Type)
+}
+
+// randomString generates a random string of the credential length
+func randomString(credential v1beta1.ScenarioCredential, alphabet string) (map[string][]byte, error) {
+	length := DefaultLength
+	if credential.Length != nil {
+		length = int(*credential.Length)
+	}
+	size := big.NewInt(int64(len(alphabet)))
+	value := make([]byte, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate %s: %w", credential.Name, err)
+		}
+		value[i] = alphabet[n.Int64()]
+	}
+	return map[string][]byte{credential.Name: value}, nil
+}
+
+// keyPair encodes a private key as PKCS #8 and its public key as PKIX PEM
+func keyPair(name string, private any, public crypto.PublicKey) (map[string][]byte, error) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return map[string][]byte{
+		name + PrivateKeySuffix: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}),
+		name + PublicKeySuffix:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}),
+	}, nil
+}
+
+// jwk returns the JSON Web Key of an RS256 signing key, identified by the
+// thumbprint of its public key
+func jwk(key *rsa.PublicKey) map[string]string {
+	n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+	e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	// RFC 7638 thumbprint, from the required members in lexicographic order
+	thumbprint := sha256.Sum256([]byte(fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, e, n)))
+	return map[string]string{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		"n":   n,
+		"e":   e,
+	}
+}
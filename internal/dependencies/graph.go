@@ -0,0 +1,71 @@
+package dependencies
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CycleError reports a dependency cycle
+type CycleError struct {
+	// Path lists the IDs of the cycle, starting and ending with the same ID
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle: %s", strings.Join(e.Path, " -> "))
+}
+
+// Order returns the transitive dependencies of root in installation order,
+// every ID after the IDs it requires. Uninstalling follows the reverse order.
+// requires returns the direct dependencies of an ID. A cycle, root included,
+// is returned as a *CycleError.
+func Order(root string, requires func(id string) ([]string, error)) ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	var order, path []string
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch state[id] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for i, p := range path {
+				if p == id {
+					start = i
+					break
+				}
+			}
+			cycle := append(append([]string{}, path[start:]...), id)
+			return &CycleError{Path: cycle}
+		}
+
+		state[id] = visiting
+		path = append(path, id)
+		deps, err := requires(id)
+		if err != nil {
+			return err
+		}
+		for _, dep := range deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		if id != root {
+			order = append(order, id)
+		}
+		return nil
+	}
+
+	if err := visit(root); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
@@ -0,0 +1,134 @@
+package dependencies
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var errUnknown = errors.New("unknown scenario")
+
+// graph returns the requires function of a dependency graph, failing on IDs
+// it does not hold
+func graph(edges map[string][]string) func(string) ([]string, error) {
+	return func(id string) ([]string, error) {
+		deps, ok := edges[id]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUnknown, id)
+		}
+		return deps, nil
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tests := map[string]struct {
+		edges     map[string][]string
+		want      []string
+		wantCycle []string
+		wantErr   error
+	}{
+		"no dependencies": {
+			edges: map[string][]string{"app": nil},
+		},
+		"chain": {
+			edges: map[string][]string{
+				"app":      {"keycloak"},
+				"keycloak": {"postgres"},
+				"postgres": nil,
+			},
+			want: []string{"postgres", "keycloak"},
+		},
+		"dependencies in declaration order": {
+			edges: map[string][]string{
+				"app":      {"redis", "postgres"},
+				"redis":    nil,
+				"postgres": nil,
+			},
+			want: []string{"redis", "postgres"},
+		},
+		"diamond": {
+			edges: map[string][]string{
+				"app":      {"keycloak", "grafana"},
+				"keycloak": {"postgres"},
+				"grafana":  {"postgres"},
+				"postgres": nil,
+			},
+			want: []string{"postgres", "keycloak", "grafana"},
+		},
+		"self cycle": {
+			edges:     map[string][]string{"app": {"app"}},
+			wantCycle: []string{"app", "app"},
+		},
+		"self cycle of a dependency": {
+			edges: map[string][]string{
+				"app":      {"keycloak"},
+				"keycloak": {"keycloak"},
+			},
+			wantCycle: []string{"keycloak", "keycloak"},
+		},
+		"indirect cycle through root": {
+			edges: map[string][]string{
+				"app":      {"keycloak"},
+				"keycloak": {"postgres"},
+				"postgres": {"app"},
+			},
+			wantCycle: []string{"app", "keycloak", "postgres", "app"},
+		},
+		"indirect cycle below root": {
+			edges: map[string][]string{
+				"app":      {"keycloak"},
+				"keycloak": {"postgres"},
+				"postgres": {"vault"},
+				"vault":    {"keycloak"},
+			},
+			wantCycle: []string{"keycloak", "postgres", "vault", "keycloak"},
+		},
+		"unknown dependency": {
+			edges: map[string][]string{
+				"app":      {"keycloak"},
+				"keycloak": {"missing"},
+			},
+			wantErr: errUnknown,
+		},
+		"unknown root": {
+			edges:   map[string][]string{},
+			wantErr: errUnknown,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Order("app", graph(tt.edges))
+			if tt.wantCycle != nil {
+				var cycleErr *CycleError
+				if !errors.As(err, &cycleErr) {
+					t.Fatalf("Order() error = %v, want a cycle", err)
+				}
+				if !reflect.DeepEqual(cycleErr.Path, tt.wantCycle) {
+					t.Errorf("Order() cycle = %v, want %v", cycleErr.Path, tt.wantCycle)
+				}
+				return
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Order() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Order() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Order() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCycleError(t *testing.T) {
+	err := &CycleError{Path: []string{"app", "keycloak", "app"}}
+	if want := "dependency cycle: app -> keycloak -> app"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,274 @@
+package guardrails
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// Names of the objects created in scenario namespaces
+const (
+	QuotaName             = "devopsbeerer-quota"
+	LimitRangeName        = "devopsbeerer-limits"
+	DefaultDenyPolicyName = "devopsbeerer-default-deny"
+	AllowPolicyName       = "devopsbeerer-allow"
+)
+
+// Options are the operator-wide guardrails applied to every scenario namespace
+type Options struct {
+	// DefaultQuota is used for resources the ScenarioDefinition sets no quota for
+	DefaultQuota corev1.ResourceList
+	// MaxQuota caps the quota of every scenario namespace
+	MaxQuota corev1.ResourceList
+	// DefaultRequests are the container requests used when the ScenarioDefinition sets none
+	DefaultRequests corev1.ResourceList
+	// DefaultLimits are the container limits used when the ScenarioDefinition sets none
+	DefaultLimits corev1.ResourceList
+	// PodSecurity is the minimum Pod Security Standards level of every
+	// scenario namespace, used when the ScenarioDefinition sets none
+	PodSecurity v1beta1.PodSecurityLevel
+}
+
+// ParseResourceList parses a comma separated list of resource=quantity pairs,
+// such as "requests.cpu=2,requests.memory=4Gi,pods=20"
+func ParseResourceList(s string) (corev1.ResourceList, error) {
+	list := corev1.ResourceList{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid resource %q, expected name=quantity", pair)
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity for resource %q: %w", name, err)
+		}
+		list[corev1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
+
+// Quota returns the requested quota completed with the defaults and capped
+// by the maximum. Resources only present in the maximum are capped too.
+func Quota(requested, defaults, max corev1.ResourceList) corev1.ResourceList {
+	quota := corev1.ResourceList{}
+	for name, quantity := range defaults {
+		quota[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range requested {
+		quota[name] = quantity.DeepCopy()
+	}
+	for name, limit := range max {
+		if quantity, ok := quota[name]; !ok || quantity.Cmp(limit) > 0 {
+			quota[name] = limit.DeepCopy()
+		}
+	}
+	return quota
+}
+
+// podSecurityLevels are the Pod Security Standards levels from the least to
+// the most restrictive
+var podSecurityLevels = []v1beta1.PodSecurityLevel{
+	v1beta1.PodSecurityLevelPrivileged,
+	v1beta1.PodSecurityLevelBaseline,
+	v1beta1.PodSecurityLevelRestricted,
+}
+
+// PodSecurity returns the requested Pod Security Standards level, raised to
+// the minimum when it is less restrictive. The minimum is used when no level
+// is requested.
+func PodSecurity(requested, minimum v1beta1.PodSecurityLevel) v1beta1.PodSecurityLevel {
+	if slices.Index(podSecurityLevels, requested) < slices.Index(podSecurityLevels, minimum) {
+		return minimum
+	}
+	return requested
+}
+
+// PodSecurityLabels returns the Pod Security Admission labels enforcing the level
+func PodSecurityLabels(level v1beta1.PodSecurityLevel) map[string]string {
+	return map[string]string{
+		"pod-security.kubernetes.io/enforce":         string(level),
+		"pod-security.kubernetes.io/enforce-version": "latest",
+		"pod-security.kubernetes.io/warn":            string(level),
+		"pod-security.kubernetes.io/warn-version":    "latest",
+		"pod-security.kubernetes.io/audit":           string(level),
+		"pod-security.kubernetes.io/audit-version":   "latest",
+	}
+}
+
+// Apply creates or updates the guardrails of a scenario namespace. They are
+// removed along with the namespace.
+func Apply(ctx context.Context, c client.Client, namespace string,
+	guardrails *v1beta1.ScenarioGuardrails, labels map[string]string, opts Options) error {
+
+	if guardrails == nil {
+		guardrails = &v1beta1.ScenarioGuardrails{}
+	}
+
+	if err := applyPodSecurity(ctx, c, namespace, guardrails, opts); err != nil {
+		return err
+	}
+	if err := applyQuota(ctx, c, namespace, guardrails, labels, opts); err != nil {
+		return err
+	}
+	if err := applyLimitRange(ctx, c, namespace, guardrails, labels, opts); err != nil {
+		return err
+	}
+	return applyNetworkPolicies(ctx, c, namespace, guardrails, labels)
+}
+
+// applyPodSecurity labels the namespace with the Pod Security Standards level,
+// which the ScenarioDefinition can only make more restrictive than the
+// operator level
+func applyPodSecurity(ctx context.Context, c client.Client, namespace string,
+	guardrails *v1beta1.ScenarioGuardrails, opts Options) error {
+
+	level := PodSecurity(guardrails.PodSecurity, opts.PodSecurity)
+	if level == "" {
+		return nil
+	}
+
+	ns := &corev1.Namespace{}
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+	patch := client.MergeFrom(ns.DeepCopy())
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	for key, value := range PodSecurityLabels(level) {
+		ns.Labels[key] = value
+	}
+	if err := c.Patch(ctx, ns, patch); err != nil {
+		return fmt.Errorf("failed to label namespace: %w", err)
+	}
+	return nil
+}
+
+// applyQuota creates the ResourceQuota of the namespace
+func applyQuota(ctx context.Context, c client.Client, namespace string,
+	guardrails *v1beta1.ScenarioGuardrails, labels map[string]string, opts Options) error {
+
+	hard := Quota(guardrails.Quota, opts.DefaultQuota, opts.MaxQuota)
+	if len(hard) == 0 {
+		return nil
+	}
+
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: QuotaName, Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, quota, func() error {
+		quota.Labels = labels
+		quota.Spec.Hard = hard
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to create resource quota: %w", err)
+	}
+	return nil
+}
+
+// applyLimitRange creates the LimitRange setting the default container
+// resources, so that pods fit a quota on requests and limits
+func applyLimitRange(ctx context.Context, c client.Client, namespace string,
+	guardrails *v1beta1.ScenarioGuardrails, labels map[string]string, opts Options) error {
+
+	requests := guardrails.DefaultRequests
+	if len(requests) == 0 {
+		requests = opts.DefaultRequests
+	}
+	limits := guardrails.DefaultLimits
+	if len(limits) == 0 {
+		limits = opts.DefaultLimits
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return nil
+	}
+
+	limitRange := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: LimitRangeName, Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, limitRange, func() error {
+		limitRange.Labels = labels
+		limitRange.Spec.Limits = []corev1.LimitRangeItem{{
+			Type:           corev1.LimitTypeContainer,
+			DefaultRequest: requests,
+			Default:        limits,
+		}}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to create limit range: %w", err)
+	}
+	return nil
+}
+
+// applyNetworkPolicies denies all traffic by default, then allows traffic
+// between the pods of the namespace, DNS and the scenario allowances
+func applyNetworkPolicies(ctx context.Context, c client.Client, namespace string,
+	guardrails *v1beta1.ScenarioGuardrails, labels map[string]string) error {
+
+	policyTypes := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}
+
+	deny := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultDenyPolicyName, Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, deny, func() error {
+		deny.Labels = labels
+		deny.Spec = networkingv1.NetworkPolicySpec{PolicyTypes: policyTypes}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to create default deny network policy: %w", err)
+	}
+
+	sameNamespace := []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}
+	dnsPort := intstr.FromInt32(53)
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+
+	ingress := []networkingv1.NetworkPolicyIngressRule{{From: sameNamespace}}
+	ingress = append(ingress, guardrails.Ingress...)
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		{To: sameNamespace},
+		{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{},
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+				},
+			}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dnsPort},
+				{Protocol: &tcp, Port: &dnsPort},
+			},
+		},
+	}
+	egress = append(egress, guardrails.Egress...)
+
+	allow := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: AllowPolicyName, Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, allow, func() error {
+		allow.Labels = labels
+		allow.Spec = networkingv1.NetworkPolicySpec{
+			PolicyTypes: policyTypes,
+			Ingress:     ingress,
+			Egress:      egress,
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to create network policy: %w", err)
+	}
+	return nil
+}
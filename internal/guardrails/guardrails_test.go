@@ -0,0 +1,47 @@
+package guardrails
+
+import (
+	"testing"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+func TestPodSecurity(t *testing.T) {
+	tests := map[string]struct {
+		requested v1beta1.PodSecurityLevel
+		minimum   v1beta1.PodSecurityLevel
+		want      v1beta1.PodSecurityLevel
+	}{
+		"none requested": {
+			minimum: v1beta1.PodSecurityLevelBaseline,
+			want:    v1beta1.PodSecurityLevelBaseline,
+		},
+		"more restrictive": {
+			requested: v1beta1.PodSecurityLevelRestricted,
+			minimum:   v1beta1.PodSecurityLevelBaseline,
+			want:      v1beta1.PodSecurityLevelRestricted,
+		},
+		"less restrictive": {
+			requested: v1beta1.PodSecurityLevelPrivileged,
+			minimum:   v1beta1.PodSecurityLevelBaseline,
+			want:      v1beta1.PodSecurityLevelBaseline,
+		},
+		"baseline under restricted": {
+			requested: v1beta1.PodSecurityLevelBaseline,
+			minimum:   v1beta1.PodSecurityLevelRestricted,
+			want:      v1beta1.PodSecurityLevelRestricted,
+		},
+		"no minimum": {
+			requested: v1beta1.PodSecurityLevelPrivileged,
+			want:      v1beta1.PodSecurityLevelPrivileged,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := PodSecurity(tt.requested, tt.minimum); got != tt.want {
+				t.Errorf("PodSecurity(%q, %q) = %q, want %q", tt.requested, tt.minimum, got, tt.want)
+			}
+		})
+	}
+}
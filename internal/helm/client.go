@@ -2,16 +2,73 @@ package helm
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/devopsbeerer/operator/internal/metrics"
+	"github.com/devopsbeerer/operator/internal/tracing"
+)
+
+// ErrChartFetch is returned when the chart source cannot be fetched
+var ErrChartFetch = errors.New("failed to fetch chart")
+
+// ErrReleaseNotFound is returned when the helm release does not exist
+var ErrReleaseNotFound = errors.New("release not found")
+
+// Release statuses left behind by an interrupted helm operation
+const (
+	StatusPendingInstall  = "pending-install"
+	StatusPendingUpgrade  = "pending-upgrade"
+	StatusPendingRollback = "pending-rollback"
+	StatusUninstalling    = "uninstalling"
 )
 
 // Client provides helm operations
 type Client struct {
 	workDir string
+	// user is impersonated by helm when set
+	user string
+}
+
+// ChartSource identifies where a chart is fetched from. Either GitURL or
+// RepoURL must be set.
+type ChartSource struct {
+	// GitURL is the Git repository holding the chart
+	GitURL string
+	// GitRef is the branch, tag or commit to check out (defaults to the remote HEAD)
+	GitRef string
+	// Path is the chart directory inside the Git repository
+	Path string
+
+	// RepoURL is the Helm (https://) or OCI (oci://) chart repository
+	RepoURL string
+	// Chart is the chart name in the repository
+	Chart string
+	// Version is the chart version constraint
+	Version string
+}
+
+// String returns a short description of the chart source
+func (s ChartSource) String() string {
+	if s.GitURL != "" {
+		ref := s.GitRef
+		if ref == "" {
+			ref = "HEAD"
+		}
+		return fmt.Sprintf("%s//%s@%s", s.GitURL, s.Path, ref)
+	}
+	if s.Version != "" {
+		return fmt.Sprintf("%s/%s@%s", s.RepoURL, s.Chart, s.Version)
+	}
+	return fmt.Sprintf("%s/%s", s.RepoURL, s.Chart)
 }
 
 // NewClient creates a new helm client
@@ -32,26 +89,71 @@ func NewClient() (*Client, error) {
 	}, nil
 }
 
-// Install installs a helm chart
-func (c *Client) Install(ctx context.Context, releaseName, namespace, repoURL, chartPath, values string) error {
-	// Clone or update the git repository
-	repoPath, err := c.cloneOrUpdateRepo(ctx, repoURL)
-	if err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
+// Impersonate returns a client running helm as the given ServiceAccount
+func (c *Client) Impersonate(namespace, serviceAccount string) *Client {
+	impersonated := *c
+	impersonated.user = fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount)
+	return &impersonated
+}
+
+// helm returns the command running helm with the given arguments
+func (c *Client) helm(ctx context.Context, args ...string) *exec.Cmd {
+	if c.user != "" {
+		args = append(args, "--kube-as-user", c.user)
 	}
+	return exec.CommandContext(ctx, "helm", args...)
+}
+
+// DefaultInstallTimeout is how long the resources of a release may take to
+// become ready unless the install options set a timeout
+const DefaultInstallTimeout = 10 * time.Minute
+
+// InstallOptions define when an installed release is ready
+type InstallOptions struct {
+	// Timeout is how long the resources may take to become ready
+	// (defaults to DefaultInstallTimeout)
+	Timeout time.Duration
+	// WaitForJobs also waits for the Jobs of the release to complete
+	WaitForJobs bool
+}
+
+// Install installs or upgrades a helm chart and waits for its release to be ready
+func (c *Client) Install(ctx context.Context, releaseName, namespace string, chart ChartSource, values string,
+	opts InstallOptions) (err error) {
+	ctx, span := tracing.Start(ctx, "helm.Install",
+		tracing.AttrHelmCommand.String("upgrade --install"),
+		tracing.AttrRelease.String(releaseName),
+		tracing.AttrNamespace.String(namespace),
+		tracing.AttrChart.String(chart.String()))
+	defer func() { tracing.End(span, err) }()
 
-	// Construct full chart path
-	fullChartPath := filepath.Join(repoPath, chartPath)
+	chartArgs, err := c.resolveChart(ctx, chart)
+	if err != nil {
+		return err
+	}
 
 	// Build helm install command
 	args := []string{
 		"upgrade", "--install",
 		releaseName,
-		fullChartPath,
+	}
+	args = append(args, chartArgs...)
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultInstallTimeout
+	}
+	args = append(args,
 		"--namespace", namespace,
-		"--create-namespace",
 		"--wait",
-		"--timeout", "10m",
+		"--timeout", timeout.String(),
+	)
+	if opts.WaitForJobs {
+		args = append(args, "--wait-for-jobs")
+	}
+	// An impersonated ServiceAccount is usually not allowed to create
+	// namespaces, so the namespace must exist beforehand
+	if c.user == "" {
+		args = append(args, "--create-namespace")
 	}
 
 	// Add values if provided
@@ -67,7 +169,7 @@ func (c *Client) Install(ctx context.Context, releaseName, namespace, repoURL, c
 	}
 
 	// Execute helm install
-	cmd := exec.CommandContext(ctx, "helm", args...)
+	cmd := c.helm(ctx, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("helm install failed: %w\nOutput: %s", err, string(output))
@@ -77,7 +179,13 @@ func (c *Client) Install(ctx context.Context, releaseName, namespace, repoURL, c
 }
 
 // Uninstall removes a helm release
-func (c *Client) Uninstall(ctx context.Context, releaseName, namespace string) error {
+func (c *Client) Uninstall(ctx context.Context, releaseName, namespace string) (err error) {
+	ctx, span := tracing.Start(ctx, "helm.Uninstall",
+		tracing.AttrHelmCommand.String("uninstall"),
+		tracing.AttrRelease.String(releaseName),
+		tracing.AttrNamespace.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
 	args := []string{
 		"uninstall",
 		releaseName,
@@ -86,7 +194,7 @@ func (c *Client) Uninstall(ctx context.Context, releaseName, namespace string) e
 		"--timeout", "5m",
 	}
 
-	cmd := exec.CommandContext(ctx, "helm", args...)
+	cmd := c.helm(ctx, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		// Check if release doesn't exist
@@ -100,7 +208,13 @@ func (c *Client) Uninstall(ctx context.Context, releaseName, namespace string) e
 }
 
 // Status checks the status of a helm release
-func (c *Client) Status(ctx context.Context, releaseName, namespace string) (string, error) {
+func (c *Client) Status(ctx context.Context, releaseName, namespace string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "helm.Status",
+		tracing.AttrHelmCommand.String("status"),
+		tracing.AttrRelease.String(releaseName),
+		tracing.AttrNamespace.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
 	args := []string{
 		"status",
 		releaseName,
@@ -108,37 +222,264 @@ func (c *Client) Status(ctx context.Context, releaseName, namespace string) (str
 		"--output", "json",
 	}
 
-	cmd := exec.CommandContext(ctx, "helm", args...)
+	cmd := c.helm(ctx, args...)
 	output, err := cmd.Output()
 	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.Contains(string(exitErr.Stderr), "not found") {
+			return "", ErrReleaseNotFound
+		}
 		return "", fmt.Errorf("helm status failed: %w", err)
 	}
 
 	return string(output), nil
 }
 
-// cloneOrUpdateRepo clones or updates a git repository
-func (c *Client) cloneOrUpdateRepo(ctx context.Context, repoURL string) (string, error) {
-	// Generate repo directory name from URL
-	repoName := strings.TrimSuffix(filepath.Base(repoURL), ".git")
-	repoPath := filepath.Join(c.workDir, repoName)
+// ReleaseInfo describes the current revision of a helm release
+type ReleaseInfo struct {
+	// Status is the status of the release, such as "deployed", "failed" or
+	// "pending-install"
+	Status string
+	// Revision is the revision number of the release
+	Revision int
+}
+
+// Release returns the current revision of a helm release
+func (c *Client) Release(ctx context.Context, releaseName, namespace string) (*ReleaseInfo, error) {
+	output, err := c.Status(ctx, releaseName, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var release struct {
+		Version int `json:"version"`
+		Info    struct {
+			Status string `json:"status"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal([]byte(output), &release); err != nil {
+		return nil, fmt.Errorf("failed to parse helm status: %w", err)
+	}
+
+	return &ReleaseInfo{Status: release.Info.Status, Revision: release.Version}, nil
+}
+
+// ReleaseStatus returns the status of a helm release, such as "deployed",
+// "failed" or "pending-install"
+func (c *Client) ReleaseStatus(ctx context.Context, releaseName, namespace string) (string, error) {
+	release, err := c.Release(ctx, releaseName, namespace)
+	if err != nil {
+		return "", err
+	}
+	return release.Status, nil
+}
+
+// ResolveRevision returns the commit the ref of a Git chart source points
+// to, or the version a chart repository resolves the version constraint of
+// its chart to, without fetching the chart
+func (c *Client) ResolveRevision(ctx context.Context, chart ChartSource) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "helm.ResolveRevision",
+		tracing.AttrChart.String(chart.String()))
+	defer func() { tracing.End(span, err) }()
+
+	if chart.GitURL != "" {
+		return c.resolveGitRef(ctx, chart.GitURL, chart.GitRef)
+	}
+
+	chartArgs, err := c.resolveChart(ctx, chart)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, "helm", append([]string{"show", "chart"}, chartArgs...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: helm show chart failed: %w", ErrChartFetch, err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if version, ok := strings.CutPrefix(line, "version:"); ok {
+			return strings.Trim(strings.TrimSpace(version), `"'`), nil
+		}
+	}
+	return "", fmt.Errorf("%w: chart %s has no version", ErrChartFetch, chart.String())
+}
+
+// resolveGitRef returns the commit a branch or tag points to in a remote
+// repository. A ref the remote does not advertise is assumed to be a commit.
+func (c *Client) resolveGitRef(ctx context.Context, repoURL, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--end-of-options", repoURL, ref, ref+"^{}")
+	output, err := cmd.Output()
+	metrics.GitOperations.WithLabelValues("ls-remote", metrics.Result(err)).Inc()
+	if err != nil {
+		return "", fmt.Errorf("%w: git ls-remote failed: %w", ErrChartFetch, err)
+	}
+
+	commit := ""
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		// Annotated tags are peeled to the commit they point to
+		if strings.HasSuffix(fields[1], "^{}") {
+			return fields[0], nil
+		}
+		if commit == "" {
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		return ref, nil
+	}
+	return commit, nil
+}
+
+// Recover unlocks a release left in a pending state by an operation that was
+// interrupted, for instance when the operator lost its leadership mid-install.
+// A pending install is uninstalled, a pending upgrade or rollback is rolled
+// back to the previous revision and an interrupted uninstall is completed.
+// It returns the pending status the release was recovered from, or an empty
+// string when the release did not need recovering.
+func (c *Client) Recover(ctx context.Context, releaseName, namespace string) (_ string, err error) {
+	status, err := c.ReleaseStatus(ctx, releaseName, namespace)
+	if errors.Is(err, ErrReleaseNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
 
-	// Check if repo already exists
-	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err == nil {
-		// Repository exists, update it
-		cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "pull", "--rebase")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return "", fmt.Errorf("git pull failed: %w\nOutput: %s", err, string(output))
+	switch status {
+	case StatusPendingInstall, StatusUninstalling:
+		if err := c.Uninstall(ctx, releaseName, namespace); err != nil {
+			return "", err
+		}
+	case StatusPendingUpgrade, StatusPendingRollback:
+		if err := c.Rollback(ctx, releaseName, namespace); err != nil {
+			return "", err
 		}
+	default:
+		return "", nil
+	}
+
+	return status, nil
+}
+
+// Rollback rolls a helm release back to its previous revision
+func (c *Client) Rollback(ctx context.Context, releaseName, namespace string) (err error) {
+	ctx, span := tracing.Start(ctx, "helm.Rollback",
+		tracing.AttrHelmCommand.String("rollback"),
+		tracing.AttrRelease.String(releaseName),
+		tracing.AttrNamespace.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	args := []string{
+		"rollback",
+		releaseName,
+		"--namespace", namespace,
+		"--wait",
+		"--timeout", "10m",
+	}
+
+	cmd := c.helm(ctx, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("helm rollback failed: %w\nOutput: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// resolveChart returns the helm arguments referencing the chart
+func (c *Client) resolveChart(ctx context.Context, chart ChartSource) ([]string, error) {
+	if chart.GitURL != "" {
+		// Clone or update the git repository, in a directory keyed by a hash
+		// of its URL: repositories sharing a base name must not share it
+		repoPath := filepath.Join(c.workDir, "repositories", repoDir(chart.GitURL))
+		if err := c.cloneOrUpdateRepo(ctx, repoPath, chart.GitURL, chart.GitRef); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrChartFetch, err)
+		}
+		return []string{filepath.Join(repoPath, chart.Path)}, nil
+	}
+
+	if chart.RepoURL == "" || chart.Chart == "" {
+		return nil, fmt.Errorf("chart source has neither a git repository nor a chart repository")
+	}
+
+	var args []string
+	if strings.HasPrefix(chart.RepoURL, "oci://") {
+		args = []string{strings.TrimSuffix(chart.RepoURL, "/") + "/" + chart.Chart}
 	} else {
-		// Clone the repository
-		cmd := exec.CommandContext(ctx, "git", "clone", repoURL, repoPath)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return "", fmt.Errorf("git clone failed: %w\nOutput: %s", err, string(output))
+		args = []string{chart.Chart, "--repo", chart.RepoURL}
+	}
+	if chart.Version != "" {
+		args = append(args, "--version", chart.Version)
+	}
+	return args, nil
+}
+
+// repoDir names the checkout directory of a Git repository after the hash of
+// its URL
+func repoDir(gitURL string) string {
+	sum := sha256.Sum256([]byte(gitURL))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Checkout checks out a Git repository in a directory of the work directory
+// of its own, named after dir, so that reading it does not race with the
+// charts checked out for installs. It returns the path of the checkout and the
+// commit checked out.
+func (c *Client) Checkout(ctx context.Context, dir, repoURL, ref string) (string, string, error) {
+	repoPath := filepath.Join(c.workDir, "checkouts", dir)
+	if err := c.cloneOrUpdateRepo(ctx, repoPath, repoURL, ref); err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrChartFetch, err)
+	}
+
+	output, err := exec.CommandContext(ctx, "git", "-C", repoPath, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return repoPath, strings.TrimSpace(string(output)), nil
+}
+
+// cloneOrUpdateRepo clones or updates a git repository in repoPath and checks
+// out the given ref, or the remote HEAD when ref is empty
+func (c *Client) cloneOrUpdateRepo(ctx context.Context, repoPath, repoURL, ref string) (err error) {
+	ctx, span := tracing.Start(ctx, "git.Fetch",
+		tracing.AttrRepository.String(repoURL),
+		tracing.AttrGitRef.String(ref))
+	defer func() { tracing.End(span, err) }()
+
+	// Clone the repository if it doesn't exist yet
+	if _, err := os.Stat(filepath.Join(repoPath, ".git")); err != nil {
+		cmd := exec.CommandContext(ctx, "git", "clone", "--", repoURL, repoPath)
+		output, err := cmd.CombinedOutput()
+		metrics.GitOperations.WithLabelValues("clone", metrics.Result(err)).Inc()
+		if err != nil {
+			return fmt.Errorf("git clone failed: %w\nOutput: %s", err, string(output))
 		}
 	}
 
-	return repoPath, nil
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// Fetch and check out the requested branch, tag or commit
+	cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "fetch", "--end-of-options", "origin", ref)
+	output, err := cmd.CombinedOutput()
+	metrics.GitOperations.WithLabelValues("pull", metrics.Result(err)).Inc()
+	if err != nil {
+		return fmt.Errorf("git fetch failed: %w\nOutput: %s", err, string(output))
+	}
+	cmd = exec.CommandContext(ctx, "git", "-C", repoPath, "checkout", "--detach", "FETCH_HEAD")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git checkout failed: %w\nOutput: %s", err, string(output))
+	}
+
+	return nil
 }
 
 // Cleanup removes temporary files
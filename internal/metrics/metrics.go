@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "devopsbeerer"
+
+// Operation results
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Helm failure reasons
+const (
+	ReasonInstall   = "install"
+	ReasonUninstall = "uninstall"
+	ReasonUpgrade   = "upgrade"
+	ReasonStatus    = "status"
+	ReasonRecover   = "recover"
+	ReasonFetch     = "fetch"
+	ReasonTimeout   = "timeout"
+)
+
+// durationBuckets cover quick chart installs up to the 10 minute helm timeout
+var durationBuckets = []float64{5, 15, 30, 60, 120, 180, 300, 480, 600, 900}
+
+var (
+	// InstallDuration observes how long installing a scenario takes
+	InstallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scenario_install_duration_seconds",
+		Help:      "Duration of scenario installations.",
+		Buckets:   durationBuckets,
+	}, []string{"scenario", "result"})
+
+	// UninstallDuration observes how long uninstalling a scenario takes
+	UninstallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scenario_uninstall_duration_seconds",
+		Help:      "Duration of scenario uninstallations.",
+		Buckets:   durationBuckets,
+	}, []string{"scenario", "result"})
+
+	// SwitchDuration observes how long replacing one scenario with another takes
+	SwitchDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scenario_switch_duration_seconds",
+		Help:      "Duration of switches from one scenario to another, uninstall included.",
+		Buckets:   durationBuckets,
+	}, []string{"from", "to", "result"})
+
+	// HelmFailures counts failed helm operations
+	HelmFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "helm_failures_total",
+		Help:      "Number of failed helm operations.",
+	}, []string{"scenario", "reason"})
+
+	// ActiveScenarios reports the scenarios currently installed
+	ActiveScenarios = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_scenarios",
+		Help:      "Scenarios currently installed, by scenario and owning ActiveScenario.",
+	}, []string{"scenario", "owner"})
+
+	// GitOperations counts git clone and pull operations
+	GitOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_operations_total",
+		Help:      "Number of git clone and pull operations.",
+	}, []string{"operation", "result"})
+
+	// HistoriesPruned counts ScenarioHistory entries deleted by the retention policy
+	HistoriesPruned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "histories_pruned_total",
+		Help:      "Number of ScenarioHistory entries pruned by the retention policy.",
+	}, []string{"scenario", "result"})
+
+	// CatalogSyncs counts ScenarioCatalog syncs
+	CatalogSyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "catalog_syncs_total",
+		Help:      "Number of ScenarioCatalog syncs.",
+	}, []string{"catalog", "result"})
+
+	// HealthChecks tracks the last successful health check of each scenario
+	HealthChecks = newHealthCheckCollector()
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(
+		InstallDuration,
+		UninstallDuration,
+		SwitchDuration,
+		HelmFailures,
+		ActiveScenarios,
+		GitOperations,
+		HistoriesPruned,
+		CatalogSyncs,
+		HealthChecks,
+	)
+}
+
+// Result returns the result label for an operation outcome
+func Result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// healthCheckCollector exposes the time since the last successful health
+// check, computed when the metrics are scraped
+type healthCheckCollector struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	desc *prometheus.Desc
+}
+
+func newHealthCheckCollector() *healthCheckCollector {
+	return &healthCheckCollector{
+		last: map[string]time.Time{},
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "health_check_age_seconds"),
+			"Seconds since the last successful health check of an installed scenario.",
+			[]string{"scenario"}, nil),
+	}
+}
+
+// Succeeded records a successful health check of the scenario
+func (c *healthCheckCollector) Succeeded(scenario string, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last[scenario] = at
+}
+
+// Forget stops reporting the scenario
+func (c *healthCheckCollector) Forget(scenario string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.last, scenario)
+}
+
+// Describe implements prometheus.Collector
+func (c *healthCheckCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *healthCheckCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for scenario, at := range c.last {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+			time.Since(at).Seconds(), scenario)
+	}
+}
@@ -0,0 +1,107 @@
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Default templates, matching the names used before templates existed
+const (
+	DefaultNamespaceTemplate = "devopsbeerer-{{ .ScenarioID }}"
+	DefaultReleaseTemplate   = "devopsbeerer-{{ .ScenarioID }}"
+)
+
+// Name length limits
+const (
+	// MaxNamespaceLength is the length limit of a DNS label
+	MaxNamespaceLength = validation.DNS1123LabelMaxLength
+	// MaxReleaseLength is the length limit of a Helm release name
+	MaxReleaseLength = 53
+)
+
+// hashLength is the number of hex characters of the hash suffix appended to
+// truncated names
+const hashLength = 8
+
+var invalidChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Variables are the values available to naming templates
+type Variables struct {
+	// ScenarioID is the ID of the scenario definition
+	ScenarioID string
+	// Instance is the name of the ActiveScenario
+	Instance string
+	// Owner is the owner of the ActiveScenario
+	Owner string
+}
+
+// Templates render the namespace and Helm release names of scenarios
+type Templates struct {
+	namespace *template.Template
+	release   *template.Template
+}
+
+// New parses the naming templates and checks they render valid names
+func New(namespaceTemplate, releaseTemplate string) (*Templates, error) {
+	namespace, err := template.New("namespace").Option("missingkey=error").Parse(namespaceTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace template: %w", err)
+	}
+	release, err := template.New("release").Option("missingkey=error").Parse(releaseTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid release template: %w", err)
+	}
+
+	t := &Templates{namespace: namespace, release: release}
+	sample := Variables{ScenarioID: "scenario", Instance: "instance", Owner: "owner"}
+	if _, err := t.Namespace(sample); err != nil {
+		return nil, err
+	}
+	if _, err := t.Release(sample); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Namespace returns the namespace of a scenario
+func (t *Templates) Namespace(vars Variables) (string, error) {
+	return render(t.namespace, vars, MaxNamespaceLength)
+}
+
+// Release returns the Helm release name of a scenario
+func (t *Templates) Release(vars Variables) (string, error) {
+	return render(t.release, vars, MaxReleaseLength)
+}
+
+// render executes a template and turns the result into a DNS label of at
+// most max characters
+func render(tmpl *template.Template, vars Variables, max int) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("failed to render %s name: %w", tmpl.Name(), err)
+	}
+
+	name := invalidChars.ReplaceAllString(strings.ToLower(b.String()), "-")
+	name = Truncate(strings.Trim(name, "-"), max)
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid %s name %q: %s", tmpl.Name(), name, strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+// Truncate shortens a name longer than max characters, replacing its end with
+// a hash of the full name so that truncated names remain distinct
+func Truncate(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	prefix := strings.TrimRight(name[:max-hashLength-1], "-")
+	return prefix + "-" + hex.EncodeToString(sum[:])[:hashLength]
+}
@@ -0,0 +1,207 @@
+package naming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// hashOf returns the hash suffix Truncate appends for a name
+func hashOf(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		namespace, release string
+		wantErr            string
+	}{
+		"defaults": {
+			namespace: DefaultNamespaceTemplate,
+			release:   DefaultReleaseTemplate,
+		},
+		"all variables": {
+			namespace: "{{ .Owner }}-{{ .Instance }}-{{ .ScenarioID }}",
+			release:   "{{ .Instance }}",
+		},
+		"invalid namespace syntax": {
+			namespace: "{{ .ScenarioID",
+			release:   DefaultReleaseTemplate,
+			wantErr:   "invalid namespace template",
+		},
+		"invalid release syntax": {
+			namespace: DefaultNamespaceTemplate,
+			release:   "{{ end }}",
+			wantErr:   "invalid release template",
+		},
+		"unknown variable": {
+			namespace: "{{ .Team }}",
+			release:   DefaultReleaseTemplate,
+			wantErr:   "failed to render namespace name",
+		},
+		"empty name": {
+			namespace: DefaultNamespaceTemplate,
+			release:   "---",
+			wantErr:   `invalid release name ""`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(tt.namespace, tt.release)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("New() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	tests := map[string]struct {
+		template      string
+		vars          Variables
+		wantNamespace string
+		wantRelease   string
+	}{
+		"default": {
+			template:      DefaultNamespaceTemplate,
+			vars:          Variables{ScenarioID: "basic-oauth2"},
+			wantNamespace: "devopsbeerer-basic-oauth2",
+			wantRelease:   "devopsbeerer-basic-oauth2",
+		},
+		"variables": {
+			template:      "{{ .Owner }}-{{ .ScenarioID }}-{{ .Instance }}",
+			vars:          Variables{ScenarioID: "keycloak", Instance: "group-2", Owner: "alice"},
+			wantNamespace: "alice-keycloak-group-2",
+			wantRelease:   "alice-keycloak-group-2",
+		},
+		"sanitised": {
+			template:      "{{ .Owner }}.{{ .ScenarioID }}",
+			vars:          Variables{ScenarioID: "Basic_OAuth2", Owner: "Alice@DevOpsBeerer.ch"},
+			wantNamespace: "alice-devopsbeerer-ch-basic-oauth2",
+			wantRelease:   "alice-devopsbeerer-ch-basic-oauth2",
+		},
+		"runs of invalid characters": {
+			template:      "{{ .Instance }}",
+			vars:          Variables{Instance: "a  //  b"},
+			wantNamespace: "a-b",
+			wantRelease:   "a-b",
+		},
+		"leading and trailing dashes": {
+			template:      "{{ .Owner }}-{{ .ScenarioID }}-",
+			vars:          Variables{ScenarioID: "keycloak", Owner: "_admin_"},
+			wantNamespace: "admin--keycloak",
+			wantRelease:   "admin--keycloak",
+		},
+		"namespace limit": {
+			template:      "{{ .ScenarioID }}",
+			vars:          Variables{ScenarioID: strings.Repeat("n", MaxNamespaceLength)},
+			wantNamespace: strings.Repeat("n", MaxNamespaceLength),
+			wantRelease:   strings.Repeat("n", MaxReleaseLength-hashLength-1) + "-" + hashOf(strings.Repeat("n", MaxNamespaceLength)),
+		},
+		"truncated": {
+			template:      "devopsbeerer-{{ .ScenarioID }}",
+			vars:          Variables{ScenarioID: long},
+			wantNamespace: "devopsbeerer-" + long[:MaxNamespaceLength-hashLength-1-len("devopsbeerer-")] + "-" + hashOf("devopsbeerer-"+long),
+			wantRelease:   "devopsbeerer-" + long[:MaxReleaseLength-hashLength-1-len("devopsbeerer-")] + "-" + hashOf("devopsbeerer-"+long),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			templates, err := New(tt.template, tt.template)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			namespace, err := templates.Namespace(tt.vars)
+			if err != nil {
+				t.Fatalf("Namespace() error = %v", err)
+			}
+			if namespace != tt.wantNamespace {
+				t.Errorf("Namespace() = %q, want %q", namespace, tt.wantNamespace)
+			}
+			release, err := templates.Release(tt.vars)
+			if err != nil {
+				t.Fatalf("Release() error = %v", err)
+			}
+			if release != tt.wantRelease {
+				t.Errorf("Release() = %q, want %q", release, tt.wantRelease)
+			}
+			if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+				t.Errorf("Namespace() = %q is not a DNS label: %v", namespace, errs)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := map[string]struct {
+		name string
+		max  int
+		want string
+	}{
+		"short": {
+			name: "basic-oauth2",
+			max:  MaxNamespaceLength,
+			want: "basic-oauth2",
+		},
+		"at the namespace limit": {
+			name: strings.Repeat("a", 63),
+			max:  MaxNamespaceLength,
+			want: strings.Repeat("a", 63),
+		},
+		"over the namespace limit": {
+			name: strings.Repeat("a", 64),
+			max:  MaxNamespaceLength,
+			want: strings.Repeat("a", 54) + "-" + hashOf(strings.Repeat("a", 64)),
+		},
+		"at the release limit": {
+			name: strings.Repeat("a", 53),
+			max:  MaxReleaseLength,
+			want: strings.Repeat("a", 53),
+		},
+		"over the release limit": {
+			name: strings.Repeat("a", 54),
+			max:  MaxReleaseLength,
+			want: strings.Repeat("a", 44) + "-" + hashOf(strings.Repeat("a", 54)),
+		},
+		"dashes before the hash trimmed": {
+			name: strings.Repeat("a", 40) + "----" + strings.Repeat("b", 20),
+			max:  MaxReleaseLength,
+			want: strings.Repeat("a", 40) + "-" + hashOf(strings.Repeat("a", 40)+"----"+strings.Repeat("b", 20)),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := Truncate(tt.name, tt.max)
+			if got != tt.want {
+				t.Errorf("Truncate() = %q, want %q", got, tt.want)
+			}
+			if len(got) > tt.max {
+				t.Errorf("Truncate() = %d characters, want at most %d", len(got), tt.max)
+			}
+		})
+	}
+}
+
+func TestTruncateDistinct(t *testing.T) {
+	prefix := strings.Repeat("a", MaxNamespaceLength)
+	first := Truncate(prefix+"-first", MaxNamespaceLength)
+	second := Truncate(prefix+"-second", MaxNamespaceLength)
+	if first == second {
+		t.Errorf("Truncate() = %q for distinct names", first)
+	}
+}
@@ -0,0 +1,245 @@
+package parameters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"sigs.k8s.io/yaml"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// Resolve validates the given parameter values against the parameter
+// declarations and maps them onto a nested Helm values tree
+func Resolve(defs []devopsbeererv1beta1.ScenarioParameter, params map[string]string) (map[string]interface{}, error) {
+	declared := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		declared[def.Name] = true
+	}
+
+	// Reject parameters the scenario does not know about
+	var unknown []string
+	for name := range params {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown parameters: %s", strings.Join(unknown, ", "))
+	}
+
+	values := map[string]interface{}{}
+	for _, def := range defs {
+		raw, ok := params[def.Name]
+		if !ok {
+			if def.Default == nil {
+				if def.Required {
+					return nil, fmt.Errorf("parameter %q is required", def.Name)
+				}
+				continue
+			}
+			raw = *def.Default
+		}
+
+		if len(def.Enum) > 0 && !contains(def.Enum, raw) {
+			return nil, fmt.Errorf("parameter %q must be one of [%s], got %q",
+				def.Name, strings.Join(def.Enum, ", "), raw)
+		}
+
+		value, err := convert(def.Type, raw)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", def.Name, err)
+		}
+
+		path := def.Path
+		if path == "" {
+			path = def.Name
+		}
+		if err := setPath(values, path, value); err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", def.Name, err)
+		}
+	}
+
+	return values, nil
+}
+
+// Validate validates the Helm values set by parameters against a JSON schema
+// document. The values are only a part of those the chart is installed with,
+// so the keys the schema requires are not enforced: the chart's own
+// values.yaml provides them.
+func Validate(schema []byte, values map[string]interface{}) error {
+	if len(schema) == 0 {
+		return nil
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(schema, &document); err != nil {
+		return fmt.Errorf("invalid values schema: %w", err)
+	}
+	dropRequired(document)
+	schema, err := json.Marshal(document)
+	if err != nil {
+		return fmt.Errorf("invalid values schema: %w", err)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("values.schema.json", bytes.NewReader(schema)); err != nil {
+		return fmt.Errorf("invalid values schema: %w", err)
+	}
+	compiled, err := compiler.Compile("values.schema.json")
+	if err != nil {
+		return fmt.Errorf("invalid values schema: %w", err)
+	}
+
+	// Round-trip through JSON so numbers have the types the validator expects
+	doc, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode values: %w", err)
+	}
+	var instance interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&instance); err != nil {
+		return fmt.Errorf("failed to decode values: %w", err)
+	}
+
+	if err := compiled.Validate(instance); err != nil {
+		return fmt.Errorf("values do not match schema: %w", err)
+	}
+	return nil
+}
+
+// schemaMaps are the keywords of a JSON schema holding schemas by name, whose
+// keys are not keywords
+var schemaMaps = map[string]bool{
+	"properties":        true,
+	"patternProperties": true,
+	"definitions":       true,
+	"$defs":             true,
+	"dependentSchemas":  true,
+	"dependencies":      true,
+}
+
+// schemaValues are the keywords of a JSON schema holding instance values
+// rather than schemas
+var schemaValues = map[string]bool{
+	"const":    true,
+	"default":  true,
+	"enum":     true,
+	"examples": true,
+}
+
+// dropRequired removes the required keywords of a JSON schema document
+func dropRequired(node interface{}) {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		delete(node, "required")
+		for keyword, value := range node {
+			switch {
+			case schemaValues[keyword]:
+			case schemaMaps[keyword]:
+				if schemas, ok := value.(map[string]interface{}); ok {
+					for _, schema := range schemas {
+						dropRequired(schema)
+					}
+				}
+			default:
+				dropRequired(value)
+			}
+		}
+	case []interface{}:
+		for _, item := range node {
+			dropRequired(item)
+		}
+	}
+}
+
+// Render renders the Helm values as a YAML document
+func Render(values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to render values: %w", err)
+	}
+	return string(out), nil
+}
+
+// Set sets a value at a dotted path of the Helm values, failing when the path
+// conflicts with a value already set
+func Set(values map[string]interface{}, path string, value interface{}) error {
+	return setPath(values, path, value)
+}
+
+// convert parses a raw parameter value into its declared type
+func convert(paramType devopsbeererv1beta1.ScenarioParameterType, raw string) (interface{}, error) {
+	switch paramType {
+	case devopsbeererv1beta1.ScenarioParameterTypeInteger:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", raw)
+		}
+		return v, nil
+	case devopsbeererv1beta1.ScenarioParameterTypeNumber:
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", raw)
+		}
+		return v, nil
+	case devopsbeererv1beta1.ScenarioParameterTypeBoolean:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected a boolean, got %q", raw)
+		}
+		return v, nil
+	default:
+		return raw, nil
+	}
+}
+
+// setPath sets a value at a dotted path, creating intermediate maps
+func setPath(values map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	current := values
+	for i, key := range keys {
+		if key == "" {
+			return fmt.Errorf("invalid values path %q", path)
+		}
+		if i == len(keys)-1 {
+			if _, exists := current[key]; exists {
+				return fmt.Errorf("values path %q conflicts with another parameter", path)
+			}
+			current[key] = value
+			return nil
+		}
+		next, ok := current[key]
+		if !ok {
+			child := map[string]interface{}{}
+			current[key] = child
+			current = child
+			continue
+		}
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("values path %q conflicts with another parameter", path)
+		}
+		current = child
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
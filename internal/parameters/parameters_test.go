@@ -0,0 +1,325 @@
+package parameters
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/utils/ptr"
+
+	devopsbeererv1beta1 "github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+func TestResolve(t *testing.T) {
+	tests := map[string]struct {
+		defs    []devopsbeererv1beta1.ScenarioParameter
+		params  map[string]string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		"no parameters": {
+			want: map[string]interface{}{},
+		},
+		"value at path": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{{
+				Name: "replicas",
+				Type: devopsbeererv1beta1.ScenarioParameterTypeInteger,
+				Path: "backend.replicaCount",
+			}},
+			params: map[string]string{"replicas": "2"},
+			want: map[string]interface{}{
+				"backend": map[string]interface{}{"replicaCount": int64(2)},
+			},
+		},
+		"name as path": {
+			defs:   []devopsbeererv1beta1.ScenarioParameter{{Name: "debug", Type: devopsbeererv1beta1.ScenarioParameterTypeBoolean}},
+			params: map[string]string{"debug": "true"},
+			want:   map[string]interface{}{"debug": true},
+		},
+		"default": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{{
+				Name:    "ratio",
+				Type:    devopsbeererv1beta1.ScenarioParameterTypeNumber,
+				Default: ptr.To("0.5"),
+			}},
+			want: map[string]interface{}{"ratio": 0.5},
+		},
+		"empty default": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{{
+				Name:     "prefix",
+				Default:  ptr.To(""),
+				Required: true,
+			}},
+			want: map[string]interface{}{"prefix": ""},
+		},
+		"value overrides default": {
+			defs:   []devopsbeererv1beta1.ScenarioParameter{{Name: "realm", Default: ptr.To("demo")}},
+			params: map[string]string{"realm": "beer"},
+			want:   map[string]interface{}{"realm": "beer"},
+		},
+		"optional without default": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{{Name: "realm"}},
+			want: map[string]interface{}{},
+		},
+		"required missing": {
+			defs:    []devopsbeererv1beta1.ScenarioParameter{{Name: "realm", Required: true}},
+			wantErr: `parameter "realm" is required`,
+		},
+		"unknown": {
+			defs:    []devopsbeererv1beta1.ScenarioParameter{{Name: "realm"}},
+			params:  map[string]string{"zone": "a", "region": "b"},
+			wantErr: "unknown parameters: region, zone",
+		},
+		"enum": {
+			defs:   []devopsbeererv1beta1.ScenarioParameter{{Name: "flow", Enum: []string{"code", "implicit"}}},
+			params: map[string]string{"flow": "code"},
+			want:   map[string]interface{}{"flow": "code"},
+		},
+		"not in enum": {
+			defs:    []devopsbeererv1beta1.ScenarioParameter{{Name: "flow", Enum: []string{"code", "implicit"}}},
+			params:  map[string]string{"flow": "password"},
+			wantErr: `parameter "flow" must be one of [code, implicit], got "password"`,
+		},
+		"default not in enum": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{{
+				Name:    "flow",
+				Default: ptr.To(""),
+				Enum:    []string{"code", "implicit"},
+			}},
+			wantErr: `parameter "flow" must be one of [code, implicit], got ""`,
+		},
+		"invalid type": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{{
+				Name: "replicas",
+				Type: devopsbeererv1beta1.ScenarioParameterTypeInteger,
+			}},
+			params:  map[string]string{"replicas": "two"},
+			wantErr: `parameter "replicas": expected an integer, got "two"`,
+		},
+		"conflicting paths": {
+			defs: []devopsbeererv1beta1.ScenarioParameter{
+				{Name: "backend", Default: ptr.To("a")},
+				{Name: "replicas", Default: ptr.To("1"), Path: "backend.replicaCount"},
+			},
+			wantErr: `parameter "replicas": values path "backend.replicaCount" conflicts with another parameter`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Resolve(tt.defs, tt.params)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Resolve() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resolve() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := map[string]struct {
+		paramType devopsbeererv1beta1.ScenarioParameterType
+		raw       string
+		want      interface{}
+		wantErr   bool
+	}{
+		"string":           {paramType: devopsbeererv1beta1.ScenarioParameterTypeString, raw: "42", want: "42"},
+		"untyped":          {raw: "true", want: "true"},
+		"integer":          {paramType: devopsbeererv1beta1.ScenarioParameterTypeInteger, raw: "-3", want: int64(-3)},
+		"integer fraction": {paramType: devopsbeererv1beta1.ScenarioParameterTypeInteger, raw: "1.5", wantErr: true},
+		"integer empty":    {paramType: devopsbeererv1beta1.ScenarioParameterTypeInteger, raw: "", wantErr: true},
+		"number":           {paramType: devopsbeererv1beta1.ScenarioParameterTypeNumber, raw: "1.5", want: 1.5},
+		"number integer":   {paramType: devopsbeererv1beta1.ScenarioParameterTypeNumber, raw: "2", want: 2.0},
+		"number invalid":   {paramType: devopsbeererv1beta1.ScenarioParameterTypeNumber, raw: "abc", wantErr: true},
+		"boolean":          {paramType: devopsbeererv1beta1.ScenarioParameterTypeBoolean, raw: "false", want: false},
+		"boolean short":    {paramType: devopsbeererv1beta1.ScenarioParameterTypeBoolean, raw: "1", want: true},
+		"boolean invalid":  {paramType: devopsbeererv1beta1.ScenarioParameterTypeBoolean, raw: "yes", wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := convert(tt.paramType, tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("convert() = %#v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convert() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("convert() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	tests := map[string]struct {
+		values  map[string]interface{}
+		path    string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		"top level": {
+			values: map[string]interface{}{},
+			path:   "a",
+			want:   map[string]interface{}{"a": "v"},
+		},
+		"nested": {
+			values: map[string]interface{}{},
+			path:   "a.b.c",
+			want: map[string]interface{}{
+				"a": map[string]interface{}{"b": map[string]interface{}{"c": "v"}},
+			},
+		},
+		"sibling": {
+			values: map[string]interface{}{"a": map[string]interface{}{"b": 1}},
+			path:   "a.c",
+			want:   map[string]interface{}{"a": map[string]interface{}{"b": 1, "c": "v"}},
+		},
+		"already set": {
+			values:  map[string]interface{}{"a": map[string]interface{}{"b": 1}},
+			path:    "a.b",
+			wantErr: true,
+		},
+		"through a value": {
+			values:  map[string]interface{}{"a": 1},
+			path:    "a.b",
+			wantErr: true,
+		},
+		"over a map": {
+			values:  map[string]interface{}{"a": map[string]interface{}{"b": 1}},
+			path:    "a",
+			wantErr: true,
+		},
+		"empty": {
+			values:  map[string]interface{}{},
+			path:    "",
+			wantErr: true,
+		},
+		"empty key": {
+			values:  map[string]interface{}{},
+			path:    "a..b",
+			wantErr: true,
+		},
+		"trailing dot": {
+			values:  map[string]interface{}{},
+			path:    "a.",
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := setPath(tt.values, tt.path, "v")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("setPath() = %#v, want an error", tt.values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setPath() error = %v", err)
+			}
+			if !reflect.DeepEqual(tt.values, tt.want) {
+				t.Errorf("setPath() = %#v, want %#v", tt.values, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// The schema of a chart requiring keys its values.yaml sets
+	schema := `{
+		"type": "object",
+		"required": ["image", "backend"],
+		"properties": {
+			"image": {"type": "string"},
+			"required": {"type": "boolean"},
+			"backend": {
+				"type": "object",
+				"required": ["replicaCount", "image"],
+				"properties": {
+					"replicaCount": {"type": "integer", "minimum": 1},
+					"image": {"type": "string"}
+				}
+			},
+			"flows": {
+				"type": "array",
+				"items": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}
+			}
+		},
+		"$defs": {"unused": {"required": ["x"]}}
+	}`
+
+	tests := map[string]struct {
+		schema  string
+		values  map[string]interface{}
+		wantErr string
+	}{
+		"no schema": {
+			values: map[string]interface{}{"anything": 1},
+		},
+		"no values": {
+			schema: schema,
+			values: map[string]interface{}{},
+		},
+		"required keys left to the chart": {
+			schema: schema,
+			values: map[string]interface{}{
+				"backend": map[string]interface{}{"replicaCount": int64(2)},
+			},
+		},
+		"required keys of array items": {
+			schema: schema,
+			values: map[string]interface{}{"flows": []interface{}{map[string]interface{}{}}},
+		},
+		"property named required": {
+			schema:  schema,
+			values:  map[string]interface{}{"required": "yes"},
+			wantErr: "values do not match schema",
+		},
+		"wrong type": {
+			schema:  schema,
+			values:  map[string]interface{}{"image": int64(1)},
+			wantErr: "values do not match schema",
+		},
+		"out of range": {
+			schema: schema,
+			values: map[string]interface{}{
+				"backend": map[string]interface{}{"replicaCount": int64(0)},
+			},
+			wantErr: "values do not match schema",
+		},
+		"invalid schema": {
+			schema:  `{"type": `,
+			values:  map[string]interface{}{},
+			wantErr: "invalid values schema",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Validate([]byte(tt.schema), tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+		})
+	}
+}
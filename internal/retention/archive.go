@@ -0,0 +1,126 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// ArchiveKey is the ConfigMap key holding the archived entries
+const ArchiveKey = "histories.jsonl"
+
+// maxConfigMapArchiveSize keeps the archive ConfigMap below the 1MiB limit of
+// objects, the oldest entries are dropped beyond it
+const maxConfigMapArchiveSize = 900 * 1024
+
+// Archiver exports ScenarioHistory entries before they are pruned
+type Archiver interface {
+	Archive(ctx context.Context, histories []*v1beta1.ScenarioHistory) error
+}
+
+// ConfigMapArchiver appends pruned entries as JSON Lines to a ConfigMap
+type ConfigMapArchiver struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+}
+
+// FileArchiver appends pruned entries as JSON Lines to a file, typically on a
+// PersistentVolume
+type FileArchiver struct {
+	Path string
+}
+
+// Archive appends the entries to the ConfigMap, creating it if needed
+func (a *ConfigMapArchiver) Archive(ctx context.Context, histories []*v1beta1.ScenarioHistory) error {
+	lines, err := marshalLines(histories)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err = a.Client.Get(ctx, client.ObjectKey{Namespace: a.Namespace, Name: a.Name}, configMap)
+	if errors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: a.Namespace, Name: a.Name},
+			Data:       map[string]string{ArchiveKey: string(trimArchive(lines))},
+		}
+		return a.Client.Create(ctx, configMap)
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[ArchiveKey] = string(trimArchive(append([]byte(configMap.Data[ArchiveKey]), lines...)))
+	return a.Client.Update(ctx, configMap)
+}
+
+// Archive appends the entries to the file, creating it if needed
+func (a *FileArchiver) Archive(_ context.Context, histories []*v1beta1.ScenarioHistory) error {
+	lines, err := marshalLines(histories)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	file, err := os.OpenFile(a.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	// Entries are deleted from the cluster once archived
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return file.Close()
+}
+
+// marshalLines encodes entries as JSON Lines
+func marshalLines(histories []*v1beta1.ScenarioHistory) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, history := range histories {
+		entry := history.DeepCopy()
+		entry.APIVersion = v1beta1.GroupVersion.String()
+		entry.Kind = "ScenarioHistory"
+		entry.ManagedFields = nil
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode history %s: %w", history.Name, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// trimArchive drops the oldest lines of an archive larger than the ConfigMap
+// budget
+func trimArchive(archive []byte) []byte {
+	for len(archive) > maxConfigMapArchiveSize {
+		i := bytes.IndexByte(archive, '\n')
+		if i < 0 {
+			return nil
+		}
+		archive = archive[i+1:]
+	}
+	return archive
+}
@@ -0,0 +1,91 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+func TestTrimArchive(t *testing.T) {
+	line := func(c byte, size int) string {
+		return strings.Repeat(string(c), size-1) + "\n"
+	}
+	half := maxConfigMapArchiveSize / 2
+
+	tests := map[string]struct {
+		archive string
+		want    string
+	}{
+		"empty": {},
+		"under the budget": {
+			archive: line('a', 10) + line('b', 10),
+			want:    line('a', 10) + line('b', 10),
+		},
+		"at the budget": {
+			archive: line('a', half) + line('b', maxConfigMapArchiveSize-half),
+			want:    line('a', half) + line('b', maxConfigMapArchiveSize-half),
+		},
+		"oldest line dropped": {
+			archive: line('a', 10) + line('b', half) + line('c', half),
+			want:    line('b', half) + line('c', half),
+		},
+		"several lines dropped": {
+			archive: line('a', half) + line('b', half) + line('c', half) + line('d', 10),
+			want:    line('c', half) + line('d', 10),
+		},
+		"single line over the budget": {
+			archive: line('a', maxConfigMapArchiveSize+1),
+		},
+		"unterminated line over the budget": {
+			archive: line('a', 10) + strings.Repeat("b", maxConfigMapArchiveSize+1),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := trimArchive([]byte(tt.archive))
+			if !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("trimArchive() = %d bytes, want %d bytes", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestFileArchiver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive", ArchiveKey)
+	archiver := &FileArchiver{Path: path}
+
+	first := entry("first", 2, v1beta1.ScenarioHistoryPhaseArchived)
+	second := entry("second", 1, v1beta1.ScenarioHistoryPhaseArchived)
+	if err := archiver.Archive(context.Background(), []*v1beta1.ScenarioHistory{&first}); err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+	if err := archiver.Archive(context.Background(), []*v1beta1.ScenarioHistory{&second}); err != nil {
+		t.Fatalf("Archive() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("archive holds %d lines, want 2", len(lines))
+	}
+	for i, want := range []string{"first", "second"} {
+		history := &v1beta1.ScenarioHistory{}
+		if err := json.Unmarshal([]byte(lines[i]), history); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if history.Name != want || history.Kind != "ScenarioHistory" ||
+			history.APIVersion != v1beta1.GroupVersion.String() {
+			t.Errorf("line %d = %s %s %s, want ScenarioHistory %s", i, history.APIVersion, history.Kind, history.Name, want)
+		}
+	}
+}
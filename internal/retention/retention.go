@@ -0,0 +1,86 @@
+package retention
+
+import (
+	"sort"
+	"time"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// Policy decides which archived ScenarioHistory entries of a scenario are pruned
+type Policy struct {
+	// MaxPerScenario is the number of archived entries kept per scenario,
+	// failures kept by KeepFailures excluded (0 keeps them all)
+	MaxPerScenario int
+	// MaxAge prunes entries uninstalled for longer than this (0 disables it)
+	MaxAge time.Duration
+	// KeepFailures is the number of most recent failed entries kept whatever
+	// their age and the number of entries
+	KeepFailures int
+}
+
+// Enabled reports whether the policy prunes anything
+func (p Policy) Enabled() bool {
+	return p.MaxPerScenario > 0 || p.MaxAge > 0
+}
+
+// Failed reports whether a history entry records a failed scenario
+func Failed(history *v1beta1.ScenarioHistory) bool {
+	return history.Status.UninstallReason == v1beta1.UninstallReasonFailed ||
+		history.Status.Health == "Unhealthy"
+}
+
+// Select returns the archived entries of one scenario that are pruned at the
+// given time, and how long until the next entry reaches MaxAge (0 when none
+// will). Active and terminating entries are never pruned.
+func (p Policy) Select(histories []v1beta1.ScenarioHistory, now time.Time) ([]*v1beta1.ScenarioHistory, time.Duration) {
+	archived := make([]*v1beta1.ScenarioHistory, 0, len(histories))
+	for i := range histories {
+		if histories[i].Status.Phase == v1beta1.ScenarioHistoryPhaseArchived {
+			archived = append(archived, &histories[i])
+		}
+	}
+
+	// Most recent first
+	sort.SliceStable(archived, func(i, j int) bool {
+		return archived[j].Spec.InstalledAt.Before(&archived[i].Spec.InstalledAt)
+	})
+
+	var prune []*v1beta1.ScenarioHistory
+	var next time.Duration
+	kept, failures := 0, 0
+	for _, history := range archived {
+		if Failed(history) && failures < p.KeepFailures {
+			failures++
+			continue
+		}
+
+		if p.MaxAge > 0 {
+			age := now.Sub(uninstalledAt(history))
+			if age >= p.MaxAge {
+				prune = append(prune, history)
+				continue
+			}
+			if remaining := p.MaxAge - age; next == 0 || remaining < next {
+				next = remaining
+			}
+		}
+
+		if p.MaxPerScenario > 0 && kept >= p.MaxPerScenario {
+			prune = append(prune, history)
+			continue
+		}
+		kept++
+	}
+
+	return prune, next
+}
+
+// uninstalledAt returns when an entry was uninstalled, falling back to its
+// installation for entries archived before the time was recorded
+func uninstalledAt(history *v1beta1.ScenarioHistory) time.Time {
+	if history.Status.UninstalledAt != nil {
+		return history.Status.UninstalledAt.Time
+	}
+	return history.Spec.InstalledAt.Time
+}
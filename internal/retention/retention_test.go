@@ -0,0 +1,165 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+var now = time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+// entry returns a history installed the given number of days before now and
+// uninstalled a day later, unless it is still installed
+func entry(name string, days int, phase v1beta1.ScenarioHistoryPhase) v1beta1.ScenarioHistory {
+	history := v1beta1.ScenarioHistory{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1beta1.ScenarioHistorySpec{
+			ScenarioID:  "basic-oauth2",
+			InstalledAt: metav1.NewTime(now.AddDate(0, 0, -days)),
+		},
+		Status: v1beta1.ScenarioHistoryStatus{Phase: phase},
+	}
+	if phase == v1beta1.ScenarioHistoryPhaseArchived {
+		history.Status.UninstalledAt = ptrTime(now.AddDate(0, 0, 1-days))
+	}
+	return history
+}
+
+func failed(history v1beta1.ScenarioHistory) v1beta1.ScenarioHistory {
+	history.Status.UninstallReason = v1beta1.UninstallReasonFailed
+	return history
+}
+
+func ptrTime(t time.Time) *metav1.Time {
+	mt := metav1.NewTime(t)
+	return &mt
+}
+
+func TestPolicySelect(t *testing.T) {
+	archived := v1beta1.ScenarioHistoryPhaseArchived
+	day := 24 * time.Hour
+
+	tests := map[string]struct {
+		policy    Policy
+		histories []v1beta1.ScenarioHistory
+		want      []string
+		wantNext  time.Duration
+	}{
+		"disabled": {
+			histories: []v1beta1.ScenarioHistory{entry("a", 30, archived), entry("b", 20, archived)},
+		},
+		"max per scenario keeps the most recent": {
+			policy: Policy{MaxPerScenario: 2},
+			histories: []v1beta1.ScenarioHistory{
+				entry("oldest", 30, archived),
+				entry("newest", 10, archived),
+				entry("middle", 20, archived),
+				entry("second", 15, archived),
+			},
+			want: []string{"middle", "oldest"},
+		},
+		"active and terminating are never pruned": {
+			policy: Policy{MaxPerScenario: 1, MaxAge: day},
+			histories: []v1beta1.ScenarioHistory{
+				entry("active", 40, v1beta1.ScenarioHistoryPhaseActive),
+				entry("terminating", 35, v1beta1.ScenarioHistoryPhaseTerminating),
+				entry("archived", 30, archived),
+			},
+			want: []string{"archived"},
+		},
+		"max age": {
+			policy: Policy{MaxAge: 7 * day},
+			histories: []v1beta1.ScenarioHistory{
+				entry("old", 30, archived),
+				entry("recent", 3, archived),
+			},
+			want:     []string{"old"},
+			wantNext: 5 * day,
+		},
+		"max age boundary": {
+			policy:    Policy{MaxAge: 6 * day},
+			histories: []v1beta1.ScenarioHistory{entry("boundary", 7, archived)},
+			want:      []string{"boundary"},
+		},
+		"next is the soonest to expire": {
+			policy: Policy{MaxAge: 10 * day},
+			histories: []v1beta1.ScenarioHistory{
+				entry("later", 2, archived),
+				entry("sooner", 6, archived),
+			},
+			wantNext: 5 * day,
+		},
+		"uninstall time missing falls back to installation": {
+			policy: Policy{MaxAge: 7 * day},
+			histories: func() []v1beta1.ScenarioHistory {
+				history := entry("legacy", 8, archived)
+				history.Status.UninstalledAt = nil
+				return []v1beta1.ScenarioHistory{history}
+			}(),
+			want: []string{"legacy"},
+		},
+		"failures kept beyond the limits": {
+			policy: Policy{MaxPerScenario: 1, MaxAge: 7 * day, KeepFailures: 1},
+			histories: []v1beta1.ScenarioHistory{
+				failed(entry("old failure", 30, archived)),
+				entry("newest", 1, archived),
+				entry("older", 2, archived),
+			},
+			want:     []string{"older"},
+			wantNext: 6 * day,
+		},
+		"only the most recent failures are kept": {
+			policy: Policy{MaxPerScenario: 1, KeepFailures: 1},
+			histories: []v1beta1.ScenarioHistory{
+				failed(entry("oldest failure", 30, archived)),
+				failed(entry("recent failure", 10, archived)),
+				entry("newest", 1, archived),
+			},
+			want: []string{"oldest failure"},
+		},
+		"unhealthy counts as failed": {
+			policy: Policy{MaxPerScenario: 1, KeepFailures: 1},
+			histories: func() []v1beta1.ScenarioHistory {
+				unhealthy := entry("unhealthy", 30, archived)
+				unhealthy.Status.Health = "Unhealthy"
+				return []v1beta1.ScenarioHistory{unhealthy, entry("b", 2, archived), entry("a", 1, archived)}
+			}(),
+			want: []string{"b"},
+		},
+		"expired entries do not count against the maximum": {
+			policy: Policy{MaxPerScenario: 2, MaxAge: 7 * day},
+			histories: []v1beta1.ScenarioHistory{
+				entry("expired", 30, archived),
+				entry("kept", 4, archived),
+				entry("newest", 1, archived),
+				entry("over", 5, archived),
+			},
+			want:     []string{"over", "expired"},
+			wantNext: 3 * day,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			prune, next := tt.policy.Select(tt.histories, now)
+			var got []string
+			for _, history := range prune {
+				got = append(got, history.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Select() pruned %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Select() pruned %v, want %v", got, tt.want)
+				}
+			}
+			if next != tt.wantNext {
+				t.Errorf("Select() next = %s, want %s", next, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devopsbeerer/operator/api/v1beta1"
+)
+
+// Window is a recurring window of time, opening at the same time of day on
+// some days of the week
+type Window struct {
+	start    time.Duration
+	duration time.Duration
+	days     map[time.Weekday]bool
+	location *time.Location
+}
+
+// NewWindow parses the update window of an ActiveScenario
+func NewWindow(w *v1beta1.UpdateWindow) (*Window, error) {
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start %q: %w", w.Start, err)
+	}
+	if w.Duration.Duration <= 0 || w.Duration.Duration > 24*time.Hour {
+		return nil, fmt.Errorf("invalid duration %s, expected up to 24h", w.Duration.Duration)
+	}
+
+	location := time.UTC
+	if w.TimeZone != "" {
+		if location, err = time.LoadLocation(w.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", w.TimeZone, err)
+		}
+	}
+
+	window := &Window{
+		start:    time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute,
+		duration: w.Duration.Duration,
+		location: location,
+	}
+	if len(w.Days) > 0 {
+		window.days = map[time.Weekday]bool{}
+		for _, day := range w.Days {
+			weekday, err := parseWeekday(day)
+			if err != nil {
+				return nil, err
+			}
+			window.days[weekday] = true
+		}
+	}
+	return window, nil
+}
+
+// Contains reports whether the window is open at the given time
+func (w *Window) Contains(t time.Time) bool {
+	// A window opened the day before may still be open
+	for day := -1; day <= 0; day++ {
+		if open, ok := w.opening(t, day); ok && !t.Before(open) && t.Before(open.Add(w.duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns when the window next opens after the given time
+func (w *Window) Next(t time.Time) time.Time {
+	for day := 0; day <= 7; day++ {
+		if open, ok := w.opening(t, day); ok && open.After(t) {
+			return open
+		}
+	}
+	// Not reached, every week has an opening day
+	return t.Add(24 * time.Hour)
+}
+
+// opening returns when the window opens on the day offset from the given
+// time, and whether it opens on that day at all
+func (w *Window) opening(t time.Time, offset int) (time.Time, bool) {
+	t = t.In(w.location)
+	date := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, w.location)
+	if w.days != nil && !w.days[date.Weekday()] {
+		return time.Time{}, false
+	}
+	open := time.Date(date.Year(), date.Month(), date.Day(),
+		int(w.start/time.Hour), int(w.start%time.Hour/time.Minute), 0, 0, w.location)
+	return open, true
+}
+
+// parseWeekday converts a day of the week of the API
+func parseWeekday(day v1beta1.Weekday) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if weekday.String() == string(day) {
+			return weekday, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q", day)
+}
@@ -0,0 +1,155 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys of the Secret holding the objects captured by a snapshot
+const (
+	ConfigMapsKey = "configmaps.json"
+	SecretsKey    = "secrets.json"
+	ClaimsKey     = "persistentvolumeclaims.json"
+)
+
+// MaxDataSize keeps the Secret holding the objects captured below the 1MiB
+// limit of objects
+const MaxDataSize = 900 * 1024
+
+// Helm ownership metadata, which lets a release adopt objects it did not
+// create
+const (
+	helmManagedByLabel        = "app.kubernetes.io/managed-by"
+	helmReleaseNameAnnotation = "meta.helm.sh/release-name"
+	helmReleaseNSAnnotation   = "meta.helm.sh/release-namespace"
+)
+
+// Data holds the objects of a scenario namespace captured by a snapshot
+type Data struct {
+	ConfigMaps []corev1.ConfigMap
+	Secrets    []corev1.Secret
+	Claims     []corev1.PersistentVolumeClaim
+}
+
+// CapturedConfigMap returns whether a ConfigMap is captured. The CA bundle
+// published in every namespace is not.
+func CapturedConfigMap(configMap *corev1.ConfigMap) bool {
+	return configMap.Name != "kube-root-ca.crt"
+}
+
+// CapturedSecret returns whether a Secret is captured. Helm release records
+// and ServiceAccount tokens belong to the installation, not to its state.
+func CapturedSecret(secret *corev1.Secret) bool {
+	switch secret.Type {
+	case "helm.sh/release.v1", corev1.SecretTypeServiceAccountToken:
+		return false
+	}
+	return true
+}
+
+// AddConfigMap captures a ConfigMap, keeping only what is needed to create
+// it again
+func (d *Data) AddConfigMap(configMap *corev1.ConfigMap) {
+	d.ConfigMaps = append(d.ConfigMaps, corev1.ConfigMap{
+		ObjectMeta: cleanMeta(configMap.ObjectMeta),
+		Data:       configMap.Data,
+		BinaryData: configMap.BinaryData,
+	})
+}
+
+// AddSecret captures a Secret, keeping only what is needed to create it again
+func (d *Data) AddSecret(secret *corev1.Secret) {
+	d.Secrets = append(d.Secrets, corev1.Secret{
+		ObjectMeta: cleanMeta(secret.ObjectMeta),
+		Type:       secret.Type,
+		Data:       secret.Data,
+	})
+}
+
+// AddClaim captures a PersistentVolumeClaim, keeping only what is needed to
+// create it again, unbound
+func (d *Data) AddClaim(claim *corev1.PersistentVolumeClaim) {
+	d.Claims = append(d.Claims, corev1.PersistentVolumeClaim{
+		ObjectMeta: cleanMeta(claim.ObjectMeta),
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      claim.Spec.AccessModes,
+			Resources:        claim.Spec.Resources,
+			StorageClassName: claim.Spec.StorageClassName,
+			VolumeMode:       claim.Spec.VolumeMode,
+		},
+	})
+}
+
+// cleanMeta keeps the name, labels and annotations of an object
+func cleanMeta(meta metav1.ObjectMeta) metav1.ObjectMeta {
+	annotations := map[string]string{}
+	for key, value := range meta.Annotations {
+		// Refers to the object as it was last applied, not as it is restored
+		if key == corev1.LastAppliedConfigAnnotation {
+			continue
+		}
+		annotations[key] = value
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	return metav1.ObjectMeta{Name: meta.Name, Labels: meta.Labels, Annotations: annotations}
+}
+
+// Encode returns the Secret data holding the objects
+func (d *Data) Encode() (map[string][]byte, error) {
+	data := map[string][]byte{}
+	size := 0
+	for key, objects := range map[string]any{
+		ConfigMapsKey: d.ConfigMaps,
+		SecretsKey:    d.Secrets,
+		ClaimsKey:     d.Claims,
+	} {
+		encoded, err := json.Marshal(objects)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		data[key] = encoded
+		size += len(encoded)
+	}
+	if size > MaxDataSize {
+		return nil, fmt.Errorf("the objects captured take %d bytes, more than the %d a Secret can hold",
+			size, MaxDataSize)
+	}
+	return data, nil
+}
+
+// Decode reads the objects from the Secret data Encode returned
+func Decode(data map[string][]byte) (*Data, error) {
+	d := &Data{}
+	for key, objects := range map[string]any{
+		ConfigMapsKey: &d.ConfigMaps,
+		SecretsKey:    &d.Secrets,
+		ClaimsKey:     &d.Claims,
+	} {
+		if len(data[key]) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(data[key], objects); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	return d, nil
+}
+
+// Adopt moves an object to namespace. An object installed by the Helm release
+// of the scenario captured is handed to the release restored, so that
+// installing the release adopts it instead of failing on an existing object.
+func Adopt(meta *metav1.ObjectMeta, namespace, capturedRelease, release string) {
+	meta.Namespace = namespace
+	if meta.Labels[helmManagedByLabel] != "Helm" || meta.Annotations[helmReleaseNameAnnotation] == "" {
+		return
+	}
+	if meta.Annotations[helmReleaseNameAnnotation] == capturedRelease {
+		meta.Annotations[helmReleaseNameAnnotation] = release
+	}
+	meta.Annotations[helmReleaseNSAnnotation] = namespace
+}
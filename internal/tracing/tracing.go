@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/devopsbeerer/operator"
+
+// Span attribute keys
+const (
+	AttrScenarioID  = attribute.Key("devopsbeerer.scenario.id")
+	AttrRelease     = attribute.Key("helm.release")
+	AttrNamespace   = attribute.Key("k8s.namespace.name")
+	AttrChart       = attribute.Key("helm.chart")
+	AttrRepository  = attribute.Key("git.repository")
+	AttrGitRef      = attribute.Key("git.ref")
+	AttrHelmCommand = attribute.Key("helm.command")
+)
+
+// Options configures the OTLP trace exporter
+type Options struct {
+	// Endpoint is the OTLP/HTTP collector endpoint (host:port). Tracing is
+	// disabled when empty.
+	Endpoint string
+	// Insecure disables TLS towards the collector
+	Insecure bool
+	// SampleRatio is the fraction of root traces to sample
+	SampleRatio float64
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+}
+
+// Setup installs the global tracer provider and returns a function flushing
+// and stopping it. Without an endpoint the global no-op provider is kept.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporterOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(opts.Endpoint)}
+	if opts.Insecure {
+		exporterOpts = append(exporterOpts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span from the operator tracer
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}